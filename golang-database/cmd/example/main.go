@@ -0,0 +1,117 @@
+package main
+
+import (
+   "fmt"
+   "encoding/json"
+   "github.com/ayush/golang-database/db"
+)
+
+type Address struct {
+   City     string
+   State    string
+   Country  string
+   Pincode  json.Number
+}
+
+type User struct {
+   Name     string
+   Age      json.Number
+   Contact  string
+   Company  string
+   Address  Address
+}
+
+func main() {
+   dir := "./"
+   database, err := db.New(dir, nil)
+   if err != nil {
+      fmt.Println("Error:", err)
+   }
+   
+   employees := []User{
+      {
+         Name: "Ayush",
+         Age: "19",
+         Contact: "9004903289",
+         Company: "Expansion Tricks",
+         Address: Address{
+            City: "Mumbai",
+            State: "Maharashtra",
+            Country: "India",
+            Pincode: "400033",
+         },
+      },
+      
+      {
+         Name: "Alkesh",
+         Age: "20",
+         Contact: "9004267596",
+         Company: "Dashboard.io",
+         Address: Address{
+            City: "Mumbai",
+            State: "Maharashtra",
+            Country: "India",
+            Pincode: "400033",
+         },
+      },
+      
+      {
+         Name: "John",
+         Age: "19",
+         Contact: "1234567890",
+         Company: "Expansion Tricks",
+         Address: Address{
+            City: "Kochi",
+            State: "Kerala",
+            Country: "India",
+            Pincode: "40123",
+         },
+      },
+   }
+   
+   for _, user := range employees {
+      database.Write("users", user.Name, User{
+         Name: user.Name,
+         Age: user.Age,
+         Contact: user.Contact,
+         Company: user.Company,
+         Address: user.Address,
+      })
+   }
+   
+   records, err := database.ReadAll("users")
+   if err != nil {
+      fmt.Println("Error:", err)
+   }
+   fmt.Println(records)
+   
+   allUsers := []User{}
+   for _, found := range records {
+      employee := User{}
+      if err := json.Unmarshal([]byte(found), &employee); err != nil {
+         fmt.Println("Error:", err)
+      }
+      allUsers = append(allUsers, employee)
+   }
+   fmt.Println(allUsers)
+   
+   perCity, err := database.GroupBy("users", "Address.City", "Age")
+   if err != nil {
+      fmt.Println("Error:", err)
+   }
+   for _, group := range perCity {
+      fmt.Printf("%s: %d users, average age %.1f\n", group.Key, group.Count, group.Avg)
+   }
+   
+   /*
+   if err := database.Delete("users", "John"); err != nil {
+      fmt.Println("Error:", err)
+   }
+   */
+   
+   /*
+   if err := database.Delete("users", ""); err != nil {
+      fmt.Println("Error:", err)
+   }
+   */
+}
\ No newline at end of file
@@ -0,0 +1,46 @@
+// Command shard converts collections of a data directory from the flat
+// layout to the sharded one:
+//
+//    shard -dir ./data users orders
+//
+// Collection locks only exist inside a process, so this tool is meant for
+// directories no other program is writing to. A running service should
+// call Driver.Shard itself, which migrates while it keeps serving.
+package main
+
+import (
+   "flag"
+   "fmt"
+   "os"
+   "github.com/ayush/golang-database/db"
+)
+
+func main() {
+   dir := flag.String("dir", "./", "data directory")
+   flag.Parse()
+   
+   if flag.NArg() == 0 {
+      fmt.Fprintln(os.Stderr, "Usage: shard -dir <data directory> <collection>...")
+      os.Exit(2)
+   }
+   
+   database, err := db.New(*dir, nil)
+   if err != nil {
+      fmt.Println("Error:", err)
+      os.Exit(1)
+   }
+   
+   for _, collection := range flag.Args() {
+      if err := database.Shard(collection); err != nil {
+         fmt.Printf("Error sharding '%s': %v\n", collection, err)
+         os.Exit(1)
+      }
+      
+      count, err := database.Count(collection)
+      if err != nil {
+         fmt.Println("Error:", err)
+         os.Exit(1)
+      }
+      fmt.Printf("Sharded '%s' (%d records)\n", collection, count)
+   }
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+   "encoding/json"
+   "fmt"
+   "strings"
+   "time"
+)
+
+// Right is a set of permissions on records.
+type Right uint8
+
+const (
+   RightRead Right = 1 << iota
+   RightWrite
+   RightDelete
+   
+   RightAll = RightRead | RightWrite | RightDelete
+)
+
+func (r Right) String() string {
+   var names []string
+   for _, right := range []struct {
+      bit  Right
+      name string
+   }{{RightRead, "read"}, {RightWrite, "write"}, {RightDelete, "delete"}} {
+      if r & right.bit != 0 {
+         names = append(names, right.name)
+      }
+   }
+   if len(names) == 0 {
+      return "none"
+   }
+   return strings.Join(names, "+")
+}
+
+// Policy decides whether principal holds right on a record. An empty
+// resource stands for the whole collection, as used by ReadAll, the
+// aggregations and collection deletes; an empty collection names the
+// store itself, which administrative calls such as Verify and replication
+// are checked against.
+type Policy interface {
+   Allowed(principal string, right Right, collection, resource string) bool
+}
+
+// Rule grants Rights to Principal on the records of Collection whose names
+// start with Prefix. "*" matches any principal or collection. Rules with a
+// Prefix only cover single records, never the whole collection.
+type Rule struct {
+   Principal  string
+   Collection string
+   Prefix     string
+   Rights     Right
+}
+
+func (rule Rule) matches(principal string, right Right, collection, resource string) bool {
+   if rule.Principal != "*" && rule.Principal != principal {
+      return false
+   }
+   if rule.Collection != "*" && rule.Collection != collection {
+      return false
+   }
+   // A prefix only says something about plain record names; one that could
+   // lead elsewhere matches no prefix, whoever calls the policy.
+   if rule.Prefix != "" && (resource == "" || validResource(resource) != nil || !strings.HasPrefix(resource, rule.Prefix)) {
+      return false
+   }
+   return rule.Rights & right == right
+}
+
+// Rules is a Policy that allows an operation when any single rule grants
+// every right it needs.
+type Rules []Rule
+
+func (rules Rules) Allowed(principal string, right Right, collection, resource string) bool {
+   for _, rule := range rules {
+      if rule.matches(principal, right, collection, resource) {
+         return true
+      }
+   }
+   return false
+}
+
+// AccessError is returned when the principal of a scoped handle isn't
+// allowed to do something.
+type AccessError struct {
+   Principal  string
+   Right      Right
+   Collection string
+   Resource   string
+}
+
+func (e *AccessError) Error() string {
+   target := e.Collection
+   if target == "" {
+      target = "the store"
+   }
+   if e.Resource != "" {
+      target += "/" + e.Resource
+   }
+   return fmt.Sprintf("Access denied! '%s' may not %s %s", e.Principal, e.Right, target)
+}
+
+// WithPrincipal returns a handle on the same store whose operations are
+// checked against Options.Policy on behalf of principal. The unscoped
+// Driver returned by New is not restricted.
+func (d *Driver) WithPrincipal(principal string) *Driver {
+   return &Driver{store: d.store, principal: principal, scoped: true, namespace: d.namespace}
+}
+
+// Principal returns who the handle acts for, or "" when it isn't scoped.
+func (d *Driver) Principal() string {
+   return d.principal
+}
+
+func (d *Driver) authorize(right Right, collection, resource string) error {
+   if !d.scoped {
+      return nil
+   }
+   if d.policy != nil && d.policy.Allowed(d.principal, right, collection, resource) {
+      return nil
+   }
+   
+   err := &AccessError{Principal: d.principal, Right: right, Collection: collection, Resource: resource}
+   d.recordDenial(err)
+   return err
+}
+
+// authorizeAdmin guards calls that act on the store as a whole.
+func (d *Driver) authorizeAdmin() error {
+   return d.authorize(RightAll, "", "")
+}
+
+type auditEntry struct {
+   Time       time.Time `json:"time"`
+   Principal  string    `json:"principal"`
+   Right      string    `json:"right"`
+   Collection string    `json:"collection,omitempty"`
+   Resource   string    `json:"resource,omitempty"`
+}
+
+func (d *Driver) recordDenial(e *AccessError) {
+   if d.audit == nil {
+      d.log.Warn("%s\n", e.Error())
+      return
+   }
+   
+   b, _ := json.Marshal(auditEntry{
+      Time: time.Now(),
+      Principal: e.Principal,
+      Right: e.Right.String(),
+      Collection: e.Collection,
+      Resource: e.Resource,
+   })
+   
+   d.mutex.Lock()
+   defer d.mutex.Unlock()
+   if _, err := d.audit.Write(append(b, '\n')); err != nil {
+      d.log.Error("Unable to write audit log: %v\n", err)
+   }
+}
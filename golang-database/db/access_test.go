@@ -0,0 +1,147 @@
+package db_test
+
+import (
+   "bytes"
+   "encoding/json"
+   "errors"
+   "testing"
+   "github.com/ayush/golang-database/db"
+)
+
+func newPolicyDriver(t *testing.T, rules db.Rules) (*db.Driver, *bytes.Buffer) {
+   t.Helper()
+   
+   audit := &bytes.Buffer{}
+   options := quietOptions()
+   options.Policy = rules
+   options.AuditLog = audit
+   d, err := db.New(t.TempDir(), options)
+   if err != nil {
+      t.Fatalf("New: %v", err)
+   }
+   seedUsers(t, d)
+   return d, audit
+}
+
+func TestScopedHandleRights(t *testing.T) {
+   d, _ := newPolicyDriver(t, db.Rules{
+      {Principal: "reporting", Collection: "users", Rights: db.RightRead},
+      {Principal: "billing", Collection: "*", Rights: db.RightRead | db.RightWrite},
+   })
+   
+   reporting := d.WithPrincipal("reporting")
+   if _, err := reporting.ReadAll("users"); err != nil {
+      t.Fatalf("ReadAll: %v", err)
+   }
+   if _, err := reporting.Count("users"); err != nil {
+      t.Fatalf("Count: %v", err)
+   }
+   
+   var access *db.AccessError
+   if err := reporting.Write("users", "Ayush", sampleUsers[0]); !errors.As(err, &access) || access.Right != db.RightWrite {
+      t.Fatalf("Write = %v, want an *AccessError for write", err)
+   }
+   
+   billing := d.WithPrincipal("billing")
+   if err := billing.Write("orders", "1", map[string]int{"total": 5}); err != nil {
+      t.Fatalf("Write: %v", err)
+   }
+   if err := billing.Delete("users", ""); !errors.As(err, &access) {
+      t.Fatalf("Delete = %v, want an *AccessError", err)
+   }
+   if _, err := d.ReadAll("users"); err != nil {
+      t.Fatalf("collection gone after a denied Delete: %v", err)
+   }
+}
+
+func TestPrefixRules(t *testing.T) {
+   d, _ := newPolicyDriver(t, db.Rules{
+      {Principal: "*", Collection: "users", Prefix: "A", Rights: db.RightAll},
+   })
+   
+   scoped := d.WithPrincipal("anyone")
+   var got user
+   if err := scoped.Read("users", "Ayush", &got); err != nil {
+      t.Fatalf("Read: %v", err)
+   }
+   if err := scoped.Delete("users", "Alkesh"); err != nil {
+      t.Fatalf("Delete: %v", err)
+   }
+   if err := scoped.Read("users", "John", &got); err == nil {
+      t.Fatal("Read outside the prefix was allowed")
+   }
+   if _, err := scoped.ReadAll("users"); err == nil {
+      t.Fatal("a prefix rule allowed reading the whole collection")
+   }
+   if _, err := scoped.Insert("users", sampleUsers[2]); err == nil {
+      t.Fatal("a prefix rule allowed an Insert with a generated name")
+   }
+}
+
+func TestPrefixRulesCantLeaveTheirCollection(t *testing.T) {
+   d, _ := newPolicyDriver(t, db.Rules{
+      {Principal: "bob", Collection: "users", Prefix: "bob", Rights: db.RightAll},
+   })
+   d.Write("admins", "root", sampleUsers[0])
+   bob := d.WithPrincipal("bob")
+   
+   if err := bob.Write("users", "bob-1", sampleUsers[1]); err != nil {
+      t.Fatalf("Write within the prefix: %v", err)
+   }
+   for _, resource := range []string{"bob/../../admins/root", `bob\..\..\admins\root`, "bob..", "bob/x"} {
+      if err := bob.Write("users", resource, sampleUsers[1]); err == nil {
+         t.Errorf("bob wrote users/%s", resource)
+      }
+      if err := bob.Delete("users", resource); err == nil {
+         t.Errorf("bob deleted users/%s", resource)
+      }
+   }
+   
+   var root user
+   if err := d.Read("admins", "root", &root); err != nil || root.Name != sampleUsers[0].Name {
+      t.Fatalf("admins/root = %+v, %v; want it untouched", root, err)
+   }
+   rules := db.Rules{{Principal: "bob", Collection: "users", Prefix: "bob", Rights: db.RightRead}}
+   if rules.Allowed("bob", db.RightRead, "users", "bob/../../admins/root") {
+      t.Fatal("a prefix rule matched a name leading out of its collection")
+   }
+}
+
+func TestDenialsAreAudited(t *testing.T) {
+   d, audit := newPolicyDriver(t, nil)
+   
+   if err := d.WithPrincipal("intruder").Delete("users", "John"); err == nil {
+      t.Fatal("Delete without any rule was allowed")
+   }
+   
+   var entry struct {
+      Principal  string
+      Right      string
+      Collection string
+      Resource   string
+   }
+   if err := json.Unmarshal(audit.Bytes(), &entry); err != nil {
+      t.Fatalf("audit log %q: %v", audit.String(), err)
+   }
+   if entry.Principal != "intruder" || entry.Right != "delete" || entry.Collection != "users" || entry.Resource != "John" {
+      t.Fatalf("audit entry = %+v", entry)
+   }
+}
+
+func TestAdministrativeCallsNeedStoreRights(t *testing.T) {
+   d, _ := newPolicyDriver(t, db.Rules{
+      {Principal: "app", Collection: "users", Rights: db.RightAll},
+      {Principal: "admin", Collection: "*", Rights: db.RightAll},
+   })
+   d.Write("orders", "1", map[string]int{"total": 5})
+   
+   if _, err := d.WithPrincipal("app").Repair(); err == nil {
+      t.Fatal("Repair without rights on every collection was allowed")
+   }
+   if err := d.WithPrincipal("app").RemoveFollower("none"); err == nil {
+      t.Fatal("RemoveFollower without rights on the store was allowed")
+   }
+   if err := d.WithPrincipal("admin").RemoveFollower("none"); err != nil {
+      t.Fatalf("RemoveFollower: %v", err)
+   }
+}
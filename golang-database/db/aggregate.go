@@ -0,0 +1,230 @@
+package db
+
+import (
+   "bytes"
+   "context"
+   "encoding/json"
+   "fmt"
+   "math"
+   "sort"
+   "strconv"
+   "strings"
+)
+
+// Group holds the aggregates for every record sharing the same value of the
+// grouping field. Sum, Avg, Min and Max only cover the records where the
+// aggregated field was numeric, Values says how many of those there were.
+type Group struct {
+   Key     string
+   Count   int
+   Values  int
+   Sum     float64
+   Avg     float64
+   Min     float64
+   Max     float64
+}
+
+// Count returns the number of records in a collection.
+func (d *Driver) Count(collection string) (int, error) {
+   return d.CountContext(context.Background(), collection)
+}
+
+func (d *Driver) CountContext(ctx context.Context, collection string) (int, error) {
+   collection, err := d.qualify(collection)
+   if err != nil {
+      return 0, err
+   }
+   if collection == "" {
+      return 0, fmt.Errorf("No collection - unable to count!")
+   }
+   
+   if err := d.authorize(RightRead, collection, ""); err != nil {
+      return 0, err
+   }
+   
+   count := 0
+   err = d.each(ctx, collection, func(resource string, b []byte) error {
+      count++
+      return nil
+   })
+   
+   return count, err
+}
+
+// Distinct returns the sorted set of values a field takes across a
+// collection. Nested fields are addressed with dots, e.g. "Address.City".
+func (d *Driver) Distinct(collection, field string) ([]string, error) {
+   return d.DistinctContext(context.Background(), collection, field)
+}
+
+func (d *Driver) DistinctContext(ctx context.Context, collection, field string) ([]string, error) {
+   collection, err := d.qualify(collection)
+   if err != nil {
+      return nil, err
+   }
+   if collection == "" {
+      return nil, fmt.Errorf("No collection - unable to aggregate!")
+   }
+   
+   if err := d.authorize(RightRead, collection, ""); err != nil {
+      return nil, err
+   }
+   
+   seen := map[string]bool{}
+   err = d.each(ctx, collection, func(resource string, b []byte) error {
+      doc, err := decodeDocument(b)
+      if err != nil {
+         d.logAt(LevelWarn, collection, "Skipping record that is not a JSON object", Field{Key: "resource", Value: resource})
+         return nil
+      }
+      
+      if value, ok := lookupField(doc, field); ok {
+         seen[groupKey(value)] = true
+      }
+      return nil
+   })
+   if err != nil {
+      return nil, err
+   }
+   
+   values := make([]string, 0, len(seen))
+   for value := range seen {
+      values = append(values, value)
+   }
+   sort.Strings(values)
+   
+   return values, nil
+}
+
+// GroupBy buckets the records of a collection by the value of field and
+// aggregates the numeric field over on each bucket. Pass an empty over to
+// only count records per group. Records missing field are grouped under "".
+func (d *Driver) GroupBy(collection, field, over string) ([]Group, error) {
+   return d.GroupByContext(context.Background(), collection, field, over)
+}
+
+func (d *Driver) GroupByContext(ctx context.Context, collection, field, over string) ([]Group, error) {
+   collection, err := d.qualify(collection)
+   if err != nil {
+      return nil, err
+   }
+   if collection == "" {
+      return nil, fmt.Errorf("No collection - unable to aggregate!")
+   }
+   
+   if err := d.authorize(RightRead, collection, ""); err != nil {
+      return nil, err
+   }
+   
+   groups := map[string]*Group{}
+   err = d.each(ctx, collection, func(resource string, b []byte) error {
+      doc, err := decodeDocument(b)
+      if err != nil {
+         d.logAt(LevelWarn, collection, "Skipping record that is not a JSON object", Field{Key: "resource", Value: resource})
+         return nil
+      }
+      
+      key := ""
+      if value, ok := lookupField(doc, field); ok {
+         key = groupKey(value)
+      }
+      
+      group, ok := groups[key]
+      if !ok {
+         group = &Group{Key: key, Min: math.Inf(1), Max: math.Inf(-1)}
+         groups[key] = group
+      }
+      group.Count++
+      
+      if over == "" {
+         return nil
+      }
+      
+      value, ok := lookupField(doc, over)
+      if !ok {
+         return nil
+      }
+      if n, ok := toNumber(value); ok {
+         group.Values++
+         group.Sum += n
+         group.Min = math.Min(group.Min, n)
+         group.Max = math.Max(group.Max, n)
+      }
+      return nil
+   })
+   if err != nil {
+      return nil, err
+   }
+   
+   result := make([]Group, 0, len(groups))
+   for _, group := range groups {
+      if group.Values == 0 {
+         group.Min, group.Max = 0, 0
+      } else {
+         group.Avg = group.Sum / float64(group.Values)
+      }
+      result = append(result, *group)
+   }
+   sort.Slice(result, func(i, j int) bool {
+      return result[i].Key < result[j].Key
+   })
+   
+   return result, nil
+}
+
+func decodeDocument(b []byte) (map[string]interface{}, error) {
+   var doc map[string]interface{}
+   dec := json.NewDecoder(bytes.NewReader(b))
+   dec.UseNumber()
+   if err := dec.Decode(&doc); err != nil {
+      return nil, err
+   }
+   return doc, nil
+}
+
+// lookupField walks a dotted path such as "Address.City" through a decoded
+// document.
+func lookupField(doc map[string]interface{}, field string) (interface{}, bool) {
+   var current interface{} = doc
+   for _, part := range strings.Split(field, ".") {
+      object, ok := current.(map[string]interface{})
+      if !ok {
+         return nil, false
+      }
+      
+      current, ok = object[part]
+      if !ok {
+         return nil, false
+      }
+   }
+   return current, true
+}
+
+func groupKey(value interface{}) string {
+   switch v := value.(type) {
+      case nil:
+         return ""
+      case string:
+         return v
+      case json.Number:
+         return v.String()
+      default:
+         b, _ := json.Marshal(v)
+         return string(b)
+   }
+}
+
+// toNumber accepts JSON numbers as well as numeric strings, since the sample
+// records store values like Age as json.Number.
+func toNumber(value interface{}) (float64, bool) {
+   switch v := value.(type) {
+      case json.Number:
+         n, err := v.Float64()
+         return n, err == nil
+      case string:
+         n, err := strconv.ParseFloat(v, 64)
+         return n, err == nil
+      default:
+         return 0, false
+   }
+}
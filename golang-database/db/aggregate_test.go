@@ -0,0 +1,97 @@
+package db_test
+
+import (
+   "reflect"
+   "testing"
+)
+
+func TestCount(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   count, err := d.Count("users")
+   if err != nil {
+      t.Fatalf("Count: %v", err)
+   }
+   if count != len(sampleUsers) {
+      t.Fatalf("Count = %d, want %d", count, len(sampleUsers))
+   }
+}
+
+func TestDistinct(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   cities, err := d.Distinct("users", "Address.City")
+   if err != nil {
+      t.Fatalf("Distinct: %v", err)
+   }
+   if want := []string{"Kochi", "Mumbai"}; !reflect.DeepEqual(cities, want) {
+      t.Fatalf("Distinct = %v, want %v", cities, want)
+   }
+}
+
+func TestGroupBy(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   groups, err := d.GroupBy("users", "Company", "Age")
+   if err != nil {
+      t.Fatalf("GroupBy: %v", err)
+   }
+   if len(groups) != 2 {
+      t.Fatalf("GroupBy returned %d groups, want 2", len(groups))
+   }
+   
+   dashboard, tricks := groups[0], groups[1]
+   if dashboard.Key != "Dashboard.io" || dashboard.Count != 1 || dashboard.Avg != 20 {
+      t.Errorf("unexpected group %+v", dashboard)
+   }
+   if tricks.Key != "Expansion Tricks" || tricks.Count != 2 || tricks.Sum != 38 || tricks.Avg != 19 || tricks.Min != 19 || tricks.Max != 19 {
+      t.Errorf("unexpected group %+v", tricks)
+   }
+}
+
+func TestGroupByCountOnly(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   groups, err := d.GroupBy("users", "Address.City", "")
+   if err != nil {
+      t.Fatalf("GroupBy: %v", err)
+   }
+   counts := map[string]int{}
+   for _, group := range groups {
+      counts[group.Key] = group.Count
+      if group.Values != 0 {
+         t.Errorf("group %s aggregated %d values without an over field", group.Key, group.Values)
+      }
+   }
+   if want := map[string]int{"Kochi": 1, "Mumbai": 2}; !reflect.DeepEqual(counts, want) {
+      t.Fatalf("users per city = %v, want %v", counts, want)
+   }
+}
+
+func TestAggregationsSkipRecordsThatAreNotObjects(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   if err := d.Write("users", "scalar", 42); err != nil {
+      t.Fatalf("Write: %v", err)
+   }
+   
+   cities, err := d.Distinct("users", "Address.City")
+   if err != nil {
+      t.Fatalf("Distinct: %v", err)
+   }
+   if want := []string{"Kochi", "Mumbai"}; !reflect.DeepEqual(cities, want) {
+      t.Fatalf("Distinct = %v, want %v", cities, want)
+   }
+   
+   groups, err := d.GroupBy("users", "Company", "Age")
+   if err != nil {
+      t.Fatalf("GroupBy: %v", err)
+   }
+   if len(groups) != 2 {
+      t.Fatalf("GroupBy returned %d groups, want 2", len(groups))
+   }
+}
@@ -0,0 +1,391 @@
+package db
+
+import (
+   "context"
+   "crypto/sha256"
+   "encoding/hex"
+   "encoding/json"
+   "fmt"
+   "io"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "sort"
+)
+
+// Attachments live in two hidden directories of their collection, so record
+// scans never see them:
+//
+//    collection/.blobs/<sha256>               the content, stored once
+//    collection/.attachments/<resource>.json  the record's manifest
+//
+// Records that attach identical content share one blob. A blob is removed
+// once no manifest refers to it any more.
+const (
+   blobDir     = ".blobs"
+   manifestDir = ".attachments"
+)
+
+// Attachment describes a blob attached to a record.
+type Attachment struct {
+   Name   string
+   Size   int64
+   SHA256 string
+}
+
+type manifest map[string]Attachment
+
+// PutAttachment stores the content of r as the attachment name of an
+// existing record, replacing any attachment of the same name. The content is
+// streamed to disk, so it can be much larger than memory. Attachments are
+// not replicated to followers.
+func (d *Driver) PutAttachment(collection, resource, name string, r io.Reader) error {
+   return d.PutAttachmentContext(context.Background(), collection, resource, name, r)
+}
+
+func (d *Driver) PutAttachmentContext(ctx context.Context, collection, resource, name string, r io.Reader) (err error) {
+   collection, err = d.qualify(collection)
+   if err != nil {
+      return err
+   }
+   event := d.begin(OpAttach, collection, resource)
+   defer func() { d.finish(event, err) }()
+   
+   if err := d.checkAttachment(RightWrite, collection, resource, name); err != nil {
+      return err
+   }
+   
+   if d.isFollower() {
+      return ErrReadOnly
+   }
+   
+   if err := d.recordExists(collection, resource); err != nil {
+      return err
+   }
+   
+   // The upload happens before taking the lock, so a slow client doesn't
+   // hold up other writers.
+   dir := filepath.Join(d.collectionDir(collection), blobDir)
+   if err := os.MkdirAll(dir, 0755); err != nil {
+      return err
+   }
+   tmp, err := ioutil.TempFile(dir, ".tmp-")
+   if err != nil {
+      return err
+   }
+   defer os.Remove(tmp.Name())
+   
+   hash := sha256.New()
+   size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+   if closeErr := tmp.Close(); err == nil {
+      err = closeErr
+   }
+   if err != nil {
+      return err
+   }
+   event.BytesWritten = size
+   
+   unlock, err := d.lock(ctx, collection, event)
+   if err != nil {
+      return err
+   }
+   defer unlock()
+   
+   if err := ctx.Err(); err != nil {
+      return err
+   }
+   
+   // Checked again now the record can't be deleted underneath us.
+   if err := d.recordExists(collection, resource); err != nil {
+      return err
+   }
+   
+   sum := hex.EncodeToString(hash.Sum(nil))
+   if _, err := os.Stat(filepath.Join(dir, sum)); os.IsNotExist(err) {
+      if err := d.account(collection, 0, size, true); err != nil {
+         return err
+      }
+      if err := os.Rename(tmp.Name(), filepath.Join(dir, sum)); err != nil {
+         d.account(collection, 0, -size, false)
+         return err
+      }
+   }
+   
+   m, err := d.readManifest(collection, resource)
+   if err != nil {
+      return err
+   }
+   replaced, ok := m[name]
+   m[name] = Attachment{Name: name, Size: size, SHA256: sum}
+   if err := d.writeManifest(collection, resource, m); err != nil {
+      return err
+   }
+   
+   if ok && replaced.SHA256 != sum {
+      return d.collectBlobs(collection, replaced.SHA256)
+   }
+   return nil
+}
+
+// OpenAttachment streams an attachment. The caller must close the returned
+// reader.
+func (d *Driver) OpenAttachment(collection, resource, name string) (io.ReadCloser, error) {
+   return d.OpenAttachmentContext(context.Background(), collection, resource, name)
+}
+
+func (d *Driver) OpenAttachmentContext(ctx context.Context, collection, resource, name string) (rc io.ReadCloser, err error) {
+   collection, err = d.qualify(collection)
+   if err != nil {
+      return nil, err
+   }
+   event := d.begin(OpReadAttachment, collection, resource)
+   defer func() { d.finish(event, err) }()
+   
+   if err := d.checkAttachment(RightRead, collection, resource, name); err != nil {
+      return nil, err
+   }
+   
+   // The lock keeps a concurrent replace from collecting the blob between
+   // reading the manifest and opening it; once open, the file stays
+   // readable even if it is removed.
+   unlock, err := d.lock(ctx, collection, event)
+   if err != nil {
+      return nil, err
+   }
+   defer unlock()
+   
+   m, err := d.readManifest(collection, resource)
+   if err != nil {
+      return nil, err
+   }
+   a, ok := m[name]
+   if !ok {
+      return nil, fmt.Errorf("%w: attachment '%s' of '%s/%s'", ErrNotFound, name, collection, resource)
+   }
+   event.BytesRead = a.Size
+   
+   return os.Open(filepath.Join(d.collectionDir(collection), blobDir, a.SHA256))
+}
+
+// Attachments lists the attachments of a record, sorted by name.
+func (d *Driver) Attachments(collection, resource string) ([]Attachment, error) {
+   return d.AttachmentsContext(context.Background(), collection, resource)
+}
+
+func (d *Driver) AttachmentsContext(ctx context.Context, collection, resource string) ([]Attachment, error) {
+   collection, err := d.qualify(collection)
+   if err != nil {
+      return nil, err
+   }
+   if err := d.checkRecord(RightRead, collection, resource); err != nil {
+      return nil, err
+   }
+   
+   if err := ctx.Err(); err != nil {
+      return nil, err
+   }
+   
+   m, err := d.readManifest(collection, resource)
+   if err != nil {
+      return nil, err
+   }
+   
+   attachments := make([]Attachment, 0, len(m))
+   for _, a := range m {
+      attachments = append(attachments, a)
+   }
+   sort.Slice(attachments, func(i, j int) bool {
+      return attachments[i].Name < attachments[j].Name
+   })
+   return attachments, nil
+}
+
+// DeleteAttachment removes an attachment from a record. Deleting the record
+// removes all of its attachments.
+func (d *Driver) DeleteAttachment(collection, resource, name string) error {
+   return d.DeleteAttachmentContext(context.Background(), collection, resource, name)
+}
+
+func (d *Driver) DeleteAttachmentContext(ctx context.Context, collection, resource, name string) (err error) {
+   collection, err = d.qualify(collection)
+   if err != nil {
+      return err
+   }
+   event := d.begin(OpDetach, collection, resource)
+   defer func() { d.finish(event, err) }()
+   
+   if err := d.checkAttachment(RightWrite, collection, resource, name); err != nil {
+      return err
+   }
+   
+   if d.isFollower() {
+      return ErrReadOnly
+   }
+   
+   unlock, err := d.lock(ctx, collection, event)
+   if err != nil {
+      return err
+   }
+   defer unlock()
+   
+   m, err := d.readManifest(collection, resource)
+   if err != nil {
+      return err
+   }
+   a, ok := m[name]
+   if !ok {
+      return fmt.Errorf("%w: attachment '%s' of '%s/%s'", ErrNotFound, name, collection, resource)
+   }
+   
+   delete(m, name)
+   if err := d.writeManifest(collection, resource, m); err != nil {
+      return err
+   }
+   return d.collectBlobs(collection, a.SHA256)
+}
+
+func (d *Driver) checkAttachment(right Right, collection, resource, name string) error {
+   if name == "" {
+      return fmt.Errorf("Missing attachment name!")
+   }
+   return d.checkRecord(right, collection, resource)
+}
+
+func (d *Driver) checkRecord(right Right, collection, resource string) error {
+   if collection == "" {
+      return fmt.Errorf("Collection empty! No place to save record.")
+   }
+   
+   if resource == "" {
+      return fmt.Errorf("Missing resource! unable to save record. (no name)")
+   }
+   if err := validResource(resource); err != nil {
+      return err
+   }
+   
+   return d.authorize(right, collection, resource)
+}
+
+func (d *Driver) recordExists(collection, resource string) error {
+   exists, err := d.exists(collection, resource)
+   if err == nil && !exists {
+      err = fmt.Errorf("%w: '%s/%s'", ErrNotFound, collection, resource)
+   }
+   return err
+}
+
+func (d *Driver) manifestPath(collection, resource string) string {
+   return filepath.Join(d.collectionDir(collection), manifestDir, resource + ".json")
+}
+
+// readManifest returns the attachments of a record, an empty manifest when
+// it has none.
+func (d *Driver) readManifest(collection, resource string) (manifest, error) {
+   m := manifest{}
+   b, err := ioutil.ReadFile(d.manifestPath(collection, resource))
+   if os.IsNotExist(err) {
+      return m, nil
+   }
+   if err != nil {
+      return nil, err
+   }
+   
+   if err := json.Unmarshal(b, &m); err != nil {
+      return nil, err
+   }
+   return m, nil
+}
+
+// writeManifest replaces the manifest of a record, removing it once the last
+// attachment is gone. The caller must hold the collection lock.
+func (d *Driver) writeManifest(collection, resource string, m manifest) error {
+   path := d.manifestPath(collection, resource)
+   if len(m) == 0 {
+      if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+         return err
+      }
+      return nil
+   }
+   
+   b, err := json.MarshalIndent(m, "", "\t")
+   if err != nil {
+      return err
+   }
+   
+   if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+      return err
+   }
+   if err := ioutil.WriteFile(path + ".tmp", b, 0644); err != nil {
+      return err
+   }
+   return os.Rename(path + ".tmp", path)
+}
+
+// dropAttachments removes the manifest of a deleted record and any blobs
+// only it used. The caller must hold the collection lock.
+func (d *Driver) dropAttachments(collection, resource string) error {
+   m, err := d.readManifest(collection, resource)
+   if err != nil || len(m) == 0 {
+      return err
+   }
+   
+   if err := d.writeManifest(collection, resource, nil); err != nil {
+      return err
+   }
+   
+   var sums []string
+   for _, a := range m {
+      sums = append(sums, a.SHA256)
+   }
+   return d.collectBlobs(collection, sums...)
+}
+
+// collectBlobs removes those of sums that no manifest of the collection
+// refers to any more. It reads every manifest, which is fine as long as
+// comparatively few records have attachments. The caller must hold the
+// collection lock.
+func (d *Driver) collectBlobs(collection string, sums ...string) error {
+   dir := filepath.Join(d.collectionDir(collection), manifestDir)
+   entries, err := ioutil.ReadDir(dir)
+   if err != nil && !os.IsNotExist(err) {
+      return err
+   }
+   
+   used := map[string]bool{}
+   for _, entry := range entries {
+      if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+         continue
+      }
+      
+      m := manifest{}
+      b, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+      if err != nil {
+         return err
+      }
+      if err := json.Unmarshal(b, &m); err != nil {
+         return err
+      }
+      for _, a := range m {
+         used[a.SHA256] = true
+      }
+   }
+   
+   for _, sum := range sums {
+      if used[sum] {
+         continue
+      }
+      path := filepath.Join(d.collectionDir(collection), blobDir, sum)
+      info, err := os.Stat(path)
+      if os.IsNotExist(err) {
+         continue
+      }
+      if err == nil {
+         err = os.Remove(path)
+      }
+      if err != nil {
+         return err
+      }
+      d.account(collection, 0, -info.Size(), false)
+   }
+   return nil
+}
@@ -0,0 +1,120 @@
+package db_test
+
+import (
+   "bytes"
+   "errors"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "strings"
+   "testing"
+   "github.com/ayush/golang-database/db"
+)
+
+func readAttachment(t *testing.T, d *db.Driver, resource, name string) string {
+   t.Helper()
+   
+   rc, err := d.OpenAttachment("users", resource, name)
+   if err != nil {
+      t.Fatalf("OpenAttachment(%s, %s): %v", resource, name, err)
+   }
+   defer rc.Close()
+   
+   b, err := ioutil.ReadAll(rc)
+   if err != nil {
+      t.Fatalf("reading %s/%s: %v", resource, name, err)
+   }
+   return string(b)
+}
+
+func blobCount(t *testing.T, dir string) int {
+   t.Helper()
+   
+   entries, err := ioutil.ReadDir(filepath.Join(dir, "users", ".blobs"))
+   if err != nil && !os.IsNotExist(err) {
+      t.Fatalf("ReadDir: %v", err)
+   }
+   return len(entries)
+}
+
+func TestAttachmentRoundTrip(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   if err := d.PutAttachment("users", "Ayush", "avatar.png", strings.NewReader("png bytes")); err != nil {
+      t.Fatalf("PutAttachment: %v", err)
+   }
+   if got := readAttachment(t, d, "Ayush", "avatar.png"); got != "png bytes" {
+      t.Fatalf("OpenAttachment = %q", got)
+   }
+   
+   list, err := d.Attachments("users", "Ayush")
+   if err != nil || len(list) != 1 || list[0].Name != "avatar.png" || list[0].Size != 9 {
+      t.Fatalf("Attachments = %+v, %v", list, err)
+   }
+   
+   if count, err := d.Count("users"); err != nil || count != len(sampleUsers) {
+      t.Fatalf("Count = %d, %v; attachments leaked into the collection", count, err)
+   }
+   if report, err := d.Verify(); err != nil || !report.OK() {
+      t.Fatalf("Verify = %+v, %v", report, err)
+   }
+}
+
+func TestAttachmentsNeedARecord(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   err := d.PutAttachment("users", "Nobody", "cv.pdf", strings.NewReader("%PDF"))
+   if !errors.Is(err, db.ErrNotFound) {
+      t.Fatalf("PutAttachment = %v, want ErrNotFound", err)
+   }
+}
+
+func TestAttachmentsAreDeduplicated(t *testing.T) {
+   d, dir := newTestDriver(t)
+   seedUsers(t, d)
+   
+   logo := bytes.Repeat([]byte("logo"), 1024)
+   for _, u := range sampleUsers {
+      if err := d.PutAttachment("users", u.Name, "logo.png", bytes.NewReader(logo)); err != nil {
+         t.Fatalf("PutAttachment: %v", err)
+      }
+   }
+   if n := blobCount(t, dir); n != 1 {
+      t.Fatalf("%d blobs stored, want 1", n)
+   }
+   
+   // The shared blob must survive until its last record goes.
+   if err := d.Delete("users", "Ayush"); err != nil {
+      t.Fatalf("Delete: %v", err)
+   }
+   if got := readAttachment(t, d, "John", "logo.png"); got != string(logo) {
+      t.Fatal("shared blob lost after deleting one record")
+   }
+   
+   if err := d.DeleteAttachment("users", "John", "logo.png"); err != nil {
+      t.Fatalf("DeleteAttachment: %v", err)
+   }
+   if err := d.Delete("users", "Alkesh"); err != nil {
+      t.Fatalf("Delete: %v", err)
+   }
+   if n := blobCount(t, dir); n != 0 {
+      t.Fatalf("%d blobs left after deleting every reference", n)
+   }
+}
+
+func TestReplacingAnAttachmentCollectsTheOldBlob(t *testing.T) {
+   d, dir := newTestDriver(t)
+   seedUsers(t, d)
+   
+   d.PutAttachment("users", "Ayush", "avatar.png", strings.NewReader("old"))
+   if err := d.PutAttachment("users", "Ayush", "avatar.png", strings.NewReader("new")); err != nil {
+      t.Fatalf("PutAttachment: %v", err)
+   }
+   if got := readAttachment(t, d, "Ayush", "avatar.png"); got != "new" {
+      t.Fatalf("OpenAttachment = %q, want the replacement", got)
+   }
+   if n := blobCount(t, dir); n != 1 {
+      t.Fatalf("%d blobs stored, want 1", n)
+   }
+}
@@ -0,0 +1,593 @@
+// Package db is a small embedded document store that keeps every record as
+// an indented JSON file under dir/collection/resource.json.
+package db
+
+import (
+   "context"
+   "os"
+   "io"
+   "io/ioutil"
+   "fmt"
+   "encoding/json"
+   "sync"
+   "time"
+   "strings"
+   "path/filepath"
+   "github.com/jcelliott/lumber"
+)
+
+const DATABASE_VERSION = "1.0.1"
+
+// Logger is the printf-style logger the Driver reports through. The default
+// is a lumber console logger at INFO level. Loggers that also implement
+// StructuredLogger are given key/value fields instead.
+type Logger interface {
+   Fatal(string, ...interface{})
+   Error(string, ...interface{})
+   Warn(string, ...interface{})
+   Info(string, ...interface{})
+   Debug(string, ...interface{})
+   Trace(string, ...interface{})
+}
+
+// Driver is a handle on a data directory. It is safe for concurrent use;
+// writers to the same collection are serialised by a per-collection lock.
+// Handles returned by WithPrincipal and Namespace share the store with the
+// Driver they came from.
+type Driver struct {
+   *store
+   principal string
+   scoped    bool
+   namespace string
+}
+
+// store is the state behind a data directory, shared by all its handles.
+type store struct {
+   mutex    sync.Mutex
+   mutexes  map[string]*CollectionLock
+   dir      string
+   log      Logger
+   observer Observer
+   repl     replication
+   unique   map[string]*uniqueIndex
+   ids      IDGenerator
+   sharded  map[string]bool
+   shardNew bool
+   policy   Policy
+   audit    io.Writer
+   secret   string
+   quotas   map[string]Quota
+   usage    map[string]*Usage
+   
+   hooks    map[string]*collectionHooks
+   
+   levelMutex sync.RWMutex
+   levels     map[string]Level
+}
+
+type Options struct {
+   Logger
+   
+   // LogLevels sets the level of messages about each collection, "" being
+   // the default for all others. See SetLogLevel.
+   LogLevels map[string]Level
+   
+   // Observer, when set, is told about every Read, ReadAll, Write and
+   // Delete along with its latency, byte counts and lock wait time.
+   Observer Observer
+   
+   // IDGenerator names the records created by Insert. Defaults to UUIDv7.
+   IDGenerator IDGenerator
+   
+   // Sharded makes new collections spread their records over hash-prefix
+   // subdirectories. Existing flat collections are converted with Shard.
+   Sharded bool
+   
+   // Policy decides what handles scoped with WithPrincipal may do. Without
+   // one, scoped handles are denied everything.
+   Policy Policy
+   
+   // AuditLog receives a JSON line for every denied operation. Denials are
+   // logged as warnings when it is nil.
+   AuditLog io.Writer
+   
+   // ReplicationSecret is shared by a follower and its primaries, which
+   // prove they know it before ServeReplication takes changes from them.
+   // ServeReplication refuses to run without one.
+   ReplicationSecret string
+}
+
+// New opens the database at dir, creating the directory if it doesn't exist
+// yet. options may be nil.
+func New(dir string, options *Options) (*Driver, error) {
+   dir = filepath.Clean(dir)
+   
+   opts := Options{}
+   if options != nil {
+      opts = *options
+   }
+   
+   if opts.Logger == nil {
+      opts.Logger = lumber.NewConsoleLogger(lumber.INFO)
+   }
+   
+   if opts.IDGenerator == nil {
+      opts.IDGenerator = UUIDv7()
+   }
+   
+   driver := Driver{store: &store{
+      dir: dir,
+      mutexes: make(map[string]*CollectionLock),
+      log: opts.Logger,
+      observer: opts.Observer,
+      unique: make(map[string]*uniqueIndex),
+      ids: opts.IDGenerator,
+      sharded: make(map[string]bool),
+      shardNew: opts.Sharded,
+      policy: opts.Policy,
+      audit: opts.AuditLog,
+      secret: opts.ReplicationSecret,
+      quotas: make(map[string]Quota),
+      usage: make(map[string]*Usage),
+      hooks: make(map[string]*collectionHooks),
+      levels: map[string]Level{"": LevelInfo},
+   }}
+   for collection, level := range opts.LogLevels {
+      driver.levels[collection] = level
+   }
+   
+   if _, err := os.Stat(dir); err == nil {
+      opts.Logger.Debug("Using '%s' database already exists!\n", dir)
+      return &driver, nil
+   }
+   
+   opts.Logger.Debug("Creating the database at '%s'\n", dir)
+   return &driver, os.MkdirAll(dir, 0755)
+}
+
+// Read decodes the record named resource from collection into v.
+func (d *Driver) Read(collection, resource string, v interface{}) error {
+   return d.ReadContext(context.Background(), collection, resource, v)
+}
+
+// ReadContext is Read, but gives up as soon as ctx is done.
+func (d *Driver) ReadContext(ctx context.Context, collection, resource string, v interface{}) (err error) {
+   collection, err = d.qualify(collection)
+   if err != nil {
+      return err
+   }
+   event := d.begin(OpRead, collection, resource)
+   defer func() { d.finish(event, err) }()
+   
+   if collection == "" {
+      return fmt.Errorf("Collection empty! No place to save record.")
+   }
+   
+   if resource == "" {
+      return fmt.Errorf("Missing resource! unable to save record. (no name)")
+   }
+   if err := validResource(resource); err != nil {
+      return err
+   }
+   
+   if err := d.authorize(RightRead, collection, resource); err != nil {
+      return err
+   }
+   
+   if err := ctx.Err(); err != nil {
+      return err
+   }
+   
+   b, err := d.readRecord(collection, resource)
+   if err != nil {
+      return err
+   }
+   event.BytesRead = int64(len(b))
+   
+   return json.Unmarshal(b, &v)
+}
+
+// ReadAll returns the raw JSON of every record in collection.
+func (d *Driver) ReadAll(collection string) ([]string, error) {
+   return d.ReadAllContext(context.Background(), collection)
+}
+
+// ReadAllContext is ReadAll, but stops scanning the collection as soon as
+// ctx is done.
+func (d *Driver) ReadAllContext(ctx context.Context, collection string) (records []string, err error) {
+   collection, err = d.qualify(collection)
+   if err != nil {
+      return nil, err
+   }
+   event := d.begin(OpReadAll, collection, "")
+   defer func() { d.finish(event, err) }()
+   
+   if collection == "" {
+      return nil, fmt.Errorf("No collection - unable to read!")
+   }
+   
+   if err := d.authorize(RightRead, collection, ""); err != nil {
+      return nil, err
+   }
+   
+   dir := d.collectionDir(collection)
+   if _, err := stat(dir); err != nil {
+      return nil, err
+   }
+   
+   err = d.each(ctx, collection, func(resource string, b []byte) error {
+      event.BytesRead += int64(len(b))
+      records = append(records, string(b))
+      return nil
+   })
+   if err != nil {
+      return nil, err
+   }
+   
+   return records, nil
+}
+
+// each streams every record of a collection to fn, one file at a time, so
+// callers never need to hold the whole collection in memory. The scan is
+// abandoned with ctx.Err() once ctx is done. Records that vanished mid-scan
+// or don't hold valid JSON are skipped, so one corrupt file can't break the
+// whole collection; run Verify to find them.
+func (d *Driver) each(ctx context.Context, collection string, fn func(resource string, b []byte) error) error {
+   dir := d.collectionDir(collection)
+   files, err := d.collectionFiles(collection)
+   if err != nil {
+      return err
+   }
+   
+   for _, file := range files {
+      if err := ctx.Err(); err != nil {
+         return err
+      }
+      
+      if !strings.HasSuffix(file, ".json") {
+         continue
+      }
+      
+      b, err := ioutil.ReadFile(filepath.Join(dir, file))
+      if os.IsNotExist(err) {
+         continue
+      }
+      if err != nil {
+         return err
+      }
+      
+      if !json.Valid(b) {
+         d.logAt(LevelWarn, collection, "Skipping corrupt record", Field{Key: "file", Value: file})
+         continue
+      }
+      
+      if err := fn(strings.TrimSuffix(filepath.Base(file), ".json"), b); err != nil {
+         return err
+      }
+   }
+   
+   return nil
+}
+
+// Write stores v as the record named resource, replacing any previous
+// version.
+func (d *Driver) Write(collection, resource string, v interface{}) error {
+   return d.WriteContext(context.Background(), collection, resource, v)
+}
+
+// WriteContext is Write, but gives up if ctx is done before the collection
+// lock is acquired or the record is written.
+func (d *Driver) WriteContext(ctx context.Context, collection, resource string, v interface{}) error {
+   _, err := d.put(ctx, collection, resource, v, writeUpsert)
+   return err
+}
+
+type writeMode int
+
+const (
+   writeUpsert writeMode = iota
+   writeCreate
+   writeUpdate
+   writeInsert
+)
+
+// put is shared by Write, Create, Update and Insert, which only differ in
+// how they treat an existing record. For writeInsert the resource name is
+// generated once the collection lock is held and returned.
+func (d *Driver) put(ctx context.Context, collection, resource string, v interface{}, mode writeMode) (id string, err error) {
+   collection, err = d.qualify(collection)
+   if err != nil {
+      return "", err
+   }
+   event := d.begin(OpWrite, collection, resource)
+   defer func() { d.finish(event, err) }()
+   
+   if collection == "" {
+      return "", fmt.Errorf("Collection empty! No place to save record.")
+   }
+   
+   if resource == "" && mode != writeInsert {
+      return "", fmt.Errorf("Missing resource! unable to save record. (no name)")
+   }
+   if err := validResource(resource); err != nil {
+      return "", err
+   }
+   
+   // Generated names can't match a prefix rule, so inserting needs write
+   // access to the whole collection.
+   if err := d.authorize(RightWrite, collection, resource); err != nil {
+      return "", err
+   }
+   
+   if d.isFollower() {
+      return "", ErrReadOnly
+   }
+   
+   b, err := json.MarshalIndent(v, "", "\t")
+   if err != nil {
+      return "", err
+   }
+   b = append(b, byte('\n'))
+   
+   unlock, err := d.lock(ctx, collection, event)
+   if err != nil {
+      return "", err
+   }
+   defer unlock()
+   
+   if err := ctx.Err(); err != nil {
+      return "", err
+   }
+   
+   if mode == writeInsert {
+      if resource, err = d.newID(collection); err != nil {
+         return "", err
+      }
+      event.Resource = resource
+   }
+   
+   if mode != writeUpsert {
+      exists, err := d.exists(collection, resource)
+      if err != nil {
+         return "", err
+      }
+      switch {
+         case exists && (mode == writeCreate || mode == writeInsert):
+            return "", fmt.Errorf("%w: '%s/%s'", ErrExists, collection, resource)
+         case !exists && mode == writeUpdate:
+            return "", fmt.Errorf("%w: '%s/%s'", ErrNotFound, collection, resource)
+      }
+   }
+   
+   return resource, d.commit(collection, resource, b, event)
+}
+
+// commit is the single path every document change goes through once the
+// collection lock is held: it writes the record and ships it to followers.
+func (d *Driver) commit(collection, resource string, b []byte, event *Event) error {
+   hooks := d.hooksFor(collection)
+   b, err := hooks.runBeforeWrite(collection, resource, b)
+   if err != nil {
+      return err
+   }
+   
+   old, err := d.previous(hooks, collection, resource)
+   if err != nil {
+      return err
+   }
+   
+   if err := d.checkUnique(collection, resource, b); err != nil {
+      return err
+   }
+   
+   if err := d.writeRecord(collection, resource, b); err != nil {
+      return err
+   }
+   event.BytesWritten = int64(len(b))
+   
+   d.ship(Change{Op: ChangeWrite, Collection: collection, Resource: resource, Data: b})
+   return hooks.runAfterWrite(collection, resource, old, b)
+}
+
+// readRecord returns the raw bytes of a record.
+func (d *Driver) readRecord(collection, resource string) ([]byte, error) {
+   return ioutil.ReadFile(d.locateRecord(collection, resource))
+}
+
+func (d *Driver) exists(collection, resource string) (bool, error) {
+   _, err := os.Stat(d.locateRecord(collection, resource))
+   if os.IsNotExist(err) {
+      return false, nil
+   }
+   return err == nil, err
+}
+
+// writeRecord atomically replaces a record with b through a temporary file.
+// The caller must hold the collection lock.
+func (d *Driver) writeRecord(collection, resource string, b []byte) error {
+   if err := d.prepareCollection(collection); err != nil {
+      return err
+   }
+   
+   finalPath := d.recordPath(collection, resource)
+   tmpPath := finalPath + ".tmp"
+   
+   if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+      return err
+   }
+   
+   records, bytes := int64(1), int64(len(b))
+   if info, err := os.Stat(d.locateRecord(collection, resource)); err == nil {
+      records, bytes = 0, bytes - info.Size()
+   }
+   if err := d.account(collection, records, bytes, true); err != nil {
+      return err
+   }
+   
+   err := ioutil.WriteFile(tmpPath, b, 0644)
+   if err == nil {
+      err = os.Rename(tmpPath, finalPath)
+   }
+   if err != nil {
+      d.account(collection, -records, -bytes, false)
+      return err
+   }
+   
+   if flat := d.flatPath(collection, resource); flat != finalPath {
+      // A copy Shard hasn't moved yet would shadow nothing but waste space.
+      if err := os.Remove(flat); err != nil && !os.IsNotExist(err) {
+         return err
+      }
+   }
+   if err := d.writeChecksum(collection, resource, b); err != nil {
+      return err
+   }
+   
+   d.indexRecord(collection, resource, b)
+   return nil
+}
+
+// Delete removes a single record, or the whole collection when resource is
+// empty.
+func (d *Driver) Delete(collection, resource string) error {
+   return d.DeleteContext(context.Background(), collection, resource)
+}
+
+// DeleteContext is Delete, but gives up if ctx is done before the collection
+// lock is acquired.
+func (d *Driver) DeleteContext(ctx context.Context, collection, resource string) (err error) {
+   collection, err = d.qualify(collection)
+   if err != nil {
+      return err
+   }
+   event := d.begin(OpDelete, collection, resource)
+   defer func() { d.finish(event, err) }()
+   
+   if collection == "" {
+      return fmt.Errorf("No collection - unable to delete!")
+   }
+   if err := validResource(resource); err != nil {
+      return err
+   }
+   
+   if err := d.authorize(RightDelete, collection, resource); err != nil {
+      return err
+   }
+   
+   if d.isFollower() {
+      return ErrReadOnly
+   }
+   
+   unlock, err := d.lock(ctx, collection, event)
+   if err != nil {
+      return err
+   }
+   defer unlock()
+   
+   hooks := d.hooksFor(collection)
+   deleted, err := d.doomed(hooks, collection, resource)
+   if err != nil {
+      return err
+   }
+   
+   if err := d.removeRecord(collection, resource); err != nil {
+      return err
+   }
+   
+   d.ship(Change{Op: ChangeDelete, Collection: collection, Resource: resource})
+   return hooks.runAfterDelete(collection, deleted)
+}
+
+// removeRecord deletes a single record, or the whole collection when
+// resource is empty. The caller must hold the collection lock.
+func (d *Driver) removeRecord(collection, resource string) error {
+   path := filepath.Join(collection, resource)
+   target := d.collectionDir(collection)
+   if resource != "" {
+      target = d.locateRecord(collection, resource)
+   }
+   
+   info, err := os.Stat(target)
+   if err != nil {
+      return fmt.Errorf("Unable to find file or directory named %v: %w", path, ErrNotFound)
+   }
+   if err := os.RemoveAll(target); err != nil {
+      return err
+   }
+   if resource == "" {
+      d.forgetLayout(collection)
+      d.forgetUsage(collection)
+   } else {
+      d.account(collection, -1, -info.Size(), false)
+      if err := d.removeChecksum(collection, resource); err != nil {
+         return err
+      }
+      if err := d.dropAttachments(collection, resource); err != nil {
+         return err
+      }
+   }
+   
+   d.unindexRecord(collection, resource)
+   return nil
+}
+
+// GetOrCreateMutex returns the lock guarding writes to collection.
+func (d *Driver) GetOrCreateMutex(collection string) *CollectionLock {
+   d.mutex.Lock()
+   defer d.mutex.Unlock()
+   
+   mutex, ok := d.mutexes[collection]
+   if !ok {
+      mutex = &CollectionLock{sem: make(chan struct{}, 1)}
+      d.mutexes[collection] = mutex
+   }
+   
+   return mutex
+}
+
+// lock takes the collection mutex on behalf of an operation, recording how
+// long it waited, and returns the matching unlock.
+func (d *Driver) lock(ctx context.Context, collection string, event *Event) (func(), error) {
+   mutex := d.GetOrCreateMutex(collection)
+   waitStart := time.Now()
+   err := mutex.LockContext(ctx)
+   event.LockWait = time.Since(waitStart)
+   if err != nil {
+      return nil, err
+   }
+   return mutex.Unlock, nil
+}
+
+// CollectionLock is the per-collection mutex. Unlike sync.Mutex, waiting on
+// it can be abandoned through LockContext when the caller goes away.
+type CollectionLock struct {
+   sem chan struct{}
+}
+
+func (l *CollectionLock) Lock() {
+   l.sem <- struct{}{}
+}
+
+func (l *CollectionLock) LockContext(ctx context.Context) error {
+   if err := ctx.Err(); err != nil {
+      return err
+   }
+   
+   select {
+      case l.sem <- struct{}{}:
+         return nil
+      case <-ctx.Done():
+         return ctx.Err()
+   }
+}
+
+func (l *CollectionLock) Unlock() {
+   <-l.sem
+}
+
+func stat(path string) (fi os.FileInfo, err error) {
+   if fi, err = os.Stat(path); os.IsNotExist(err) {
+      fi, err = os.Stat(path + ".json")
+   }
+   return
+}
@@ -0,0 +1,267 @@
+package db_test
+
+import (
+   "context"
+   "encoding/json"
+   "os"
+   "path/filepath"
+   "sync"
+   "testing"
+   "time"
+   "github.com/ayush/golang-database/db"
+   "github.com/jcelliott/lumber"
+)
+
+type address struct {
+   City    string
+   Country string
+}
+
+type user struct {
+   Name    string
+   Age     json.Number
+   Contact string
+   Company string
+   Address address
+}
+
+var sampleUsers = []user{
+   {Name: "Ayush", Age: "19", Contact: "9004903289", Company: "Expansion Tricks", Address: address{City: "Mumbai", Country: "India"}},
+   {Name: "Alkesh", Age: "20", Contact: "9004267596", Company: "Dashboard.io", Address: address{City: "Mumbai", Country: "India"}},
+   {Name: "John", Age: "19", Contact: "1234567890", Company: "Expansion Tricks", Address: address{City: "Kochi", Country: "India"}},
+}
+
+func quietOptions() *db.Options {
+   return &db.Options{Logger: lumber.NewConsoleLogger(lumber.FATAL)}
+}
+
+// newTestDriver opens a Driver on a fresh directory and returns both.
+func newTestDriver(t *testing.T) (*db.Driver, string) {
+   t.Helper()
+   
+   dir := t.TempDir()
+   d, err := db.New(dir, quietOptions())
+   if err != nil {
+      t.Fatalf("New: %v", err)
+   }
+   return d, dir
+}
+
+func seedUsers(t *testing.T, d *db.Driver) {
+   t.Helper()
+   
+   for _, u := range sampleUsers {
+      if err := d.Write("users", u.Name, u); err != nil {
+         t.Fatalf("Write %s: %v", u.Name, err)
+      }
+   }
+}
+
+func TestNewCreatesDirectory(t *testing.T) {
+   dir := filepath.Join(t.TempDir(), "nested", "data")
+   if _, err := db.New(dir, quietOptions()); err != nil {
+      t.Fatalf("New: %v", err)
+   }
+   if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+      t.Fatalf("expected %s to be created, got %v", dir, err)
+   }
+}
+
+func TestWriteAndRead(t *testing.T) {
+   d, dir := newTestDriver(t)
+   seedUsers(t, d)
+   
+   if _, err := os.Stat(filepath.Join(dir, "users", "John.json")); err != nil {
+      t.Fatalf("record file missing: %v", err)
+   }
+   
+   var got user
+   if err := d.Read("users", "John", &got); err != nil {
+      t.Fatalf("Read: %v", err)
+   }
+   if got != sampleUsers[2] {
+      t.Fatalf("Read = %+v, want %+v", got, sampleUsers[2])
+   }
+}
+
+func TestWriteOverwrites(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   updated := sampleUsers[0]
+   updated.Company = "Dashboard.io"
+   if err := d.Write("users", updated.Name, updated); err != nil {
+      t.Fatalf("Write: %v", err)
+   }
+   
+   var got user
+   if err := d.Read("users", updated.Name, &got); err != nil {
+      t.Fatalf("Read: %v", err)
+   }
+   if got.Company != "Dashboard.io" {
+      t.Fatalf("Company = %q, want Dashboard.io", got.Company)
+   }
+}
+
+func TestValidation(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   var u user
+   if err := d.Read("", "John", &u); err == nil {
+      t.Error("Read without collection succeeded")
+   }
+   if err := d.Read("users", "", &u); err == nil {
+      t.Error("Read without resource succeeded")
+   }
+   if err := d.Write("", "John", u); err == nil {
+      t.Error("Write without collection succeeded")
+   }
+   if err := d.Write("users", "", u); err == nil {
+      t.Error("Write without resource succeeded")
+   }
+   if _, err := d.ReadAll(""); err == nil {
+      t.Error("ReadAll without collection succeeded")
+   }
+}
+
+func TestReadMissing(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   var u user
+   if err := d.Read("users", "Nobody", &u); err == nil {
+      t.Fatal("Read of a missing record succeeded")
+   }
+   if _, err := d.ReadAll("nothing"); err == nil {
+      t.Fatal("ReadAll of a missing collection succeeded")
+   }
+}
+
+func TestReadAll(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   records, err := d.ReadAll("users")
+   if err != nil {
+      t.Fatalf("ReadAll: %v", err)
+   }
+   if len(records) != len(sampleUsers) {
+      t.Fatalf("ReadAll returned %d records, want %d", len(records), len(sampleUsers))
+   }
+   
+   names := map[string]bool{}
+   for _, record := range records {
+      var u user
+      if err := json.Unmarshal([]byte(record), &u); err != nil {
+         t.Fatalf("record is not valid JSON: %v", err)
+      }
+      names[u.Name] = true
+   }
+   for _, u := range sampleUsers {
+      if !names[u.Name] {
+         t.Errorf("ReadAll is missing %s", u.Name)
+      }
+   }
+}
+
+func TestDelete(t *testing.T) {
+   d, dir := newTestDriver(t)
+   seedUsers(t, d)
+   
+   if err := d.Delete("users", "John"); err != nil {
+      t.Fatalf("Delete: %v", err)
+   }
+   var u user
+   if err := d.Read("users", "John", &u); err == nil {
+      t.Fatal("deleted record is still readable")
+   }
+   if err := d.Delete("users", "John"); err == nil {
+      t.Fatal("deleting a missing record succeeded")
+   }
+   
+   if err := d.Delete("users", ""); err != nil {
+      t.Fatalf("Delete collection: %v", err)
+   }
+   if _, err := os.Stat(filepath.Join(dir, "users")); !os.IsNotExist(err) {
+      t.Fatalf("collection directory still exists: %v", err)
+   }
+}
+
+func TestConcurrentWrites(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   var wg sync.WaitGroup
+   for i := 0; i < 20; i++ {
+      wg.Add(1)
+      go func(i int) {
+         defer wg.Done()
+         u := sampleUsers[i % len(sampleUsers)]
+         if err := d.Write("users", u.Name, u); err != nil {
+            t.Errorf("Write: %v", err)
+         }
+      }(i)
+   }
+   wg.Wait()
+   
+   records, err := d.ReadAll("users")
+   if err != nil {
+      t.Fatalf("ReadAll: %v", err)
+   }
+   if len(records) != len(sampleUsers) {
+      t.Fatalf("ReadAll returned %d records, want %d", len(records), len(sampleUsers))
+   }
+}
+
+func TestContextCancelled(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   ctx, cancel := context.WithCancel(context.Background())
+   cancel()
+   
+   var u user
+   if err := d.ReadContext(ctx, "users", "John", &u); err != context.Canceled {
+      t.Errorf("ReadContext = %v, want context.Canceled", err)
+   }
+   if _, err := d.ReadAllContext(ctx, "users"); err != context.Canceled {
+      t.Errorf("ReadAllContext = %v, want context.Canceled", err)
+   }
+   if err := d.WriteContext(ctx, "users", "John", u); err != context.Canceled {
+      t.Errorf("WriteContext = %v, want context.Canceled", err)
+   }
+   if err := d.DeleteContext(ctx, "users", "John"); err != context.Canceled {
+      t.Errorf("DeleteContext = %v, want context.Canceled", err)
+   }
+}
+
+func TestWriteContextGivesUpWaitingForLock(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   mutex := d.GetOrCreateMutex("users")
+   mutex.Lock()
+   defer mutex.Unlock()
+   
+   ctx, cancel := context.WithTimeout(context.Background(), 20 * time.Millisecond)
+   defer cancel()
+   
+   if err := d.WriteContext(ctx, "users", "John", sampleUsers[2]); err != context.DeadlineExceeded {
+      t.Fatalf("WriteContext = %v, want context.DeadlineExceeded", err)
+   }
+}
+
+func TestCollectionLock(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   if d.GetOrCreateMutex("users") != d.GetOrCreateMutex("users") {
+      t.Fatal("GetOrCreateMutex returned different locks for the same collection")
+   }
+   if d.GetOrCreateMutex("users") == d.GetOrCreateMutex("orders") {
+      t.Fatal("GetOrCreateMutex shared a lock between collections")
+   }
+   
+   mutex := d.GetOrCreateMutex("users")
+   if err := mutex.LockContext(context.Background()); err != nil {
+      t.Fatalf("LockContext: %v", err)
+   }
+   mutex.Unlock()
+}
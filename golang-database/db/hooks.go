@@ -0,0 +1,206 @@
+package db
+
+import (
+   "context"
+   "encoding/json"
+   "fmt"
+   "os"
+)
+
+// BeforeWriteHook sees a record on its way to disk, decoded as a JSON
+// object, and may change it in place, e.g. to set timestamps or normalise
+// fields. Returning an error rejects the write.
+type BeforeWriteHook func(collection, resource string, doc map[string]interface{}) error
+
+// AfterWriteHook is told about a record once it has been written. old is
+// nil when the record was created.
+type AfterWriteHook func(collection, resource string, old, new json.RawMessage) error
+
+// AfterDeleteHook is told about a record once it has been deleted.
+type AfterDeleteHook func(collection, resource string, old json.RawMessage) error
+
+// Hooks of a collection run in the order they were registered, while the
+// collection lock is held: they must not write to the same collection, and
+// hooks writing to each other's collections can deadlock. They run for
+// Write, Insert, Create, Update, Patch and Delete, but not for changes
+// applied by a follower, which already went through the primary's hooks.
+type collectionHooks struct {
+   beforeWrite []BeforeWriteHook
+   afterWrite  []AfterWriteHook
+   afterDelete []AfterDeleteHook
+}
+
+// HookError is returned when a hook fails. If a BeforeWrite hook failed
+// nothing was written. If an AfterWrite or AfterDelete hook failed the
+// change was made, Committed is set and the remaining after hooks were
+// skipped.
+type HookError struct {
+   Hook       string
+   Collection string
+   Resource   string
+   Committed  bool
+   Err        error
+}
+
+func (e *HookError) Error() string {
+   msg := fmt.Sprintf("%s hook on '%s/%s' failed: %v", e.Hook, e.Collection, e.Resource, e.Err)
+   if e.Committed {
+      msg += " (the change was committed)"
+   }
+   return msg
+}
+
+func (e *HookError) Unwrap() error {
+   return e.Err
+}
+
+// BeforeWrite registers a hook that runs before every write to collection.
+func (d *Driver) BeforeWrite(collection string, hook BeforeWriteHook) error {
+   return d.addHook(collection, func(h *collectionHooks) { h.beforeWrite = append(h.beforeWrite, hook) })
+}
+
+// AfterWrite registers a hook that runs after every write to collection.
+func (d *Driver) AfterWrite(collection string, hook AfterWriteHook) error {
+   return d.addHook(collection, func(h *collectionHooks) { h.afterWrite = append(h.afterWrite, hook) })
+}
+
+// AfterDelete registers a hook that runs after every record deleted from
+// collection, including each record of a collection deleted as a whole.
+func (d *Driver) AfterDelete(collection string, hook AfterDeleteHook) error {
+   return d.addHook(collection, func(h *collectionHooks) { h.afterDelete = append(h.afterDelete, hook) })
+}
+
+// addHook replaces the hooks of a collection with an updated copy, so
+// operations already running keep the set they started with.
+func (d *Driver) addHook(collection string, add func(*collectionHooks)) error {
+   collection, err := d.qualify(collection)
+   if err != nil {
+      return err
+   }
+   
+   d.mutex.Lock()
+   defer d.mutex.Unlock()
+   
+   hooks := &collectionHooks{}
+   if current, ok := d.hooks[collection]; ok {
+      *hooks = collectionHooks{
+         beforeWrite: append([]BeforeWriteHook(nil), current.beforeWrite...),
+         afterWrite: append([]AfterWriteHook(nil), current.afterWrite...),
+         afterDelete: append([]AfterDeleteHook(nil), current.afterDelete...),
+      }
+   }
+   add(hooks)
+   d.hooks[collection] = hooks
+   return nil
+}
+
+func (d *Driver) hooksFor(collection string) *collectionHooks {
+   d.mutex.Lock()
+   defer d.mutex.Unlock()
+   return d.hooks[collection]
+}
+
+// runBeforeWrite passes b through the BeforeWrite hooks and returns the
+// record to write.
+func (h *collectionHooks) runBeforeWrite(collection, resource string, b []byte) ([]byte, error) {
+   if h == nil || len(h.beforeWrite) == 0 {
+      return b, nil
+   }
+   
+   fail := func(err error) error {
+      return &HookError{Hook: "BeforeWrite", Collection: collection, Resource: resource, Err: err}
+   }
+   
+   v, err := decodeValue(b)
+   if err != nil {
+      return nil, err
+   }
+   doc, ok := v.(map[string]interface{})
+   if !ok {
+      return nil, fail(fmt.Errorf("record is not a JSON object"))
+   }
+   
+   for _, hook := range h.beforeWrite {
+      if err := hook(collection, resource, doc); err != nil {
+         return nil, fail(err)
+      }
+   }
+   
+   b, err = json.MarshalIndent(doc, "", "\t")
+   if err != nil {
+      return nil, fail(err)
+   }
+   return append(b, byte('\n')), nil
+}
+
+func (h *collectionHooks) runAfterWrite(collection, resource string, old, new []byte) error {
+   if h == nil {
+      return nil
+   }
+   
+   for _, hook := range h.afterWrite {
+      if err := hook(collection, resource, old, new); err != nil {
+         return &HookError{Hook: "AfterWrite", Collection: collection, Resource: resource, Committed: true, Err: err}
+      }
+   }
+   return nil
+}
+
+func (h *collectionHooks) runAfterDelete(collection string, deleted map[string][]byte) error {
+   if h == nil {
+      return nil
+   }
+   
+   for resource, old := range deleted {
+      for _, hook := range h.afterDelete {
+         if err := hook(collection, resource, old); err != nil {
+            return &HookError{Hook: "AfterDelete", Collection: collection, Resource: resource, Committed: true, Err: err}
+         }
+      }
+   }
+   return nil
+}
+
+// previous returns the current version of a record for the after hooks, nil
+// if there are none or the record doesn't exist yet.
+func (d *Driver) previous(hooks *collectionHooks, collection, resource string) ([]byte, error) {
+   if hooks == nil || len(hooks.afterWrite) == 0 {
+      return nil, nil
+   }
+   
+   old, err := d.readRecord(collection, resource)
+   if os.IsNotExist(err) {
+      return nil, nil
+   }
+   return old, err
+}
+
+// doomed collects the records a delete is about to remove for the
+// AfterDelete hooks: the record, or every record of the collection when
+// resource is empty.
+func (d *Driver) doomed(hooks *collectionHooks, collection, resource string) (map[string][]byte, error) {
+   if hooks == nil || len(hooks.afterDelete) == 0 {
+      return nil, nil
+   }
+   
+   deleted := map[string][]byte{}
+   if resource != "" {
+      old, err := d.readRecord(collection, resource)
+      if err != nil && !os.IsNotExist(err) {
+         return nil, err
+      }
+      if err == nil {
+         deleted[resource] = old
+      }
+      return deleted, nil
+   }
+   
+   err := d.each(context.Background(), collection, func(resource string, b []byte) error {
+      deleted[resource] = b
+      return nil
+   })
+   if err != nil && !os.IsNotExist(err) {
+      return nil, err
+   }
+   return deleted, nil
+}
@@ -0,0 +1,95 @@
+package db_test
+
+import (
+   "encoding/json"
+   "errors"
+   "sort"
+   "strings"
+   "testing"
+   "github.com/ayush/golang-database/db"
+)
+
+func TestBeforeWriteMutatesAndRejects(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   d.BeforeWrite("users", func(collection, resource string, doc map[string]interface{}) error {
+      contact, _ := doc["Contact"].(string)
+      if contact == "" {
+         return errors.New("contact is required")
+      }
+      doc["Contact"] = "+91" + strings.TrimPrefix(contact, "+91")
+      return nil
+   })
+   
+   if err := d.Write("users", "Ayush", sampleUsers[0]); err != nil {
+      t.Fatalf("Write: %v", err)
+   }
+   var got user
+   d.Read("users", "Ayush", &got)
+   if got.Contact != "+91" + sampleUsers[0].Contact {
+      t.Fatalf("Contact = %q, want it normalised", got.Contact)
+   }
+   
+   invalid := sampleUsers[1]
+   invalid.Contact = ""
+   err := d.Write("users", "Alkesh", invalid)
+   var hookErr *db.HookError
+   if !errors.As(err, &hookErr) || hookErr.Hook != "BeforeWrite" || hookErr.Committed {
+      t.Fatalf("Write = %v, want an uncommitted BeforeWrite *HookError", err)
+   }
+   if err := d.Read("users", "Alkesh", &got); err == nil {
+      t.Fatal("rejected record was written")
+   }
+}
+
+func TestAfterHooksSeeOldAndNewValues(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   var writes []string
+   d.AfterWrite("users", func(collection, resource string, old, new json.RawMessage) error {
+      var before, after user
+      json.Unmarshal(old, &before)
+      json.Unmarshal(new, &after)
+      writes = append(writes, resource + ":" + before.Company + "->" + after.Company)
+      return nil
+   })
+   var deletes []string
+   d.AfterDelete("users", func(collection, resource string, old json.RawMessage) error {
+      deletes = append(deletes, resource)
+      return nil
+   })
+   
+   seedUsers(t, d)
+   if err := d.Patch("users", "Ayush", []byte(`{"Company": "Acme"}`)); err != nil {
+      t.Fatalf("Patch: %v", err)
+   }
+   want := "Ayush:->Expansion Tricks Alkesh:->Dashboard.io John:->Expansion Tricks Ayush:Expansion Tricks->Acme"
+   if got := strings.Join(writes, " "); got != want {
+      t.Fatalf("AfterWrite saw %q, want %q", got, want)
+   }
+   
+   d.Delete("users", "John")
+   d.Delete("users", "")
+   sort.Strings(deletes)
+   if got := strings.Join(deletes, " "); got != "Alkesh Ayush John" {
+      t.Fatalf("AfterDelete saw %q", got)
+   }
+}
+
+func TestAfterHookErrorsLeaveTheChangeCommitted(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   d.AfterWrite("users", func(collection, resource string, old, new json.RawMessage) error {
+      return errors.New("search index unavailable")
+   })
+   
+   err := d.Write("users", "Ayush", sampleUsers[0])
+   var hookErr *db.HookError
+   if !errors.As(err, &hookErr) || !hookErr.Committed {
+      t.Fatalf("Write = %v, want a committed *HookError", err)
+   }
+   var got user
+   if err := d.Read("users", "Ayush", &got); err != nil || got != sampleUsers[0] {
+      t.Fatalf("Read = %+v, %v; the write should have stuck", got, err)
+   }
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+   "crypto/rand"
+   "encoding/binary"
+   "encoding/hex"
+   "fmt"
+   "os"
+   "path/filepath"
+   "strconv"
+   "strings"
+   "sync"
+   "time"
+)
+
+// IDGenerator hands out resource names for Insert. NewID is called with
+// the collection lock held, so generators may keep per-collection state.
+type IDGenerator interface {
+   NewID(collection string) (string, error)
+}
+
+// seeder is implemented by generators that must learn the names already in
+// a collection before their first NewID, like Counter.
+type seeder interface {
+   seeded(collection string) bool
+   seed(collection string, existing []string)
+}
+
+func (d *Driver) newID(collection string) (string, error) {
+   if s, ok := d.ids.(seeder); ok && !s.seeded(collection) {
+      existing, err := d.resources(collection)
+      if err != nil {
+         return "", err
+      }
+      s.seed(collection, existing)
+   }
+   return d.ids.NewID(collection)
+}
+
+// resources lists the record names of a collection without reading them.
+func (d *Driver) resources(collection string) ([]string, error) {
+   files, err := d.collectionFiles(collection)
+   if os.IsNotExist(err) {
+      return nil, nil
+   }
+   if err != nil {
+      return nil, err
+   }
+   
+   var names []string
+   for _, file := range files {
+      if strings.HasSuffix(file, ".json") {
+         names = append(names, strings.TrimSuffix(filepath.Base(file), ".json"))
+      }
+   }
+   return names, nil
+}
+
+type uuidV7 struct{}
+
+// UUIDv7 generates RFC 9562 version 7 UUIDs: a millisecond timestamp
+// followed by random bits, so names sort roughly by creation time.
+func UUIDv7() IDGenerator {
+   return uuidV7{}
+}
+
+func (uuidV7) NewID(collection string) (string, error) {
+   var b [16]byte
+   if _, err := rand.Read(b[6:]); err != nil {
+      return "", err
+   }
+   
+   ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+   b[0], b[1], b[2] = byte(ms >> 40), byte(ms >> 32), byte(ms >> 24)
+   b[3], b[4], b[5] = byte(ms >> 16), byte(ms >> 8), byte(ms)
+   b[6] = (b[6] & 0x0f) | 0x70
+   b[8] = (b[8] & 0x3f) | 0x80
+   
+   h := hex.EncodeToString(b[:])
+   return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidGenerator keeps the last timestamp and entropy so ids minted within
+// the same millisecond still sort in creation order.
+type ulidGenerator struct {
+   mutex   sync.Mutex
+   lastMs  uint64
+   entropy [10]byte
+}
+
+// ULID generates 26 character, lexicographically sortable ULIDs.
+func ULID() IDGenerator {
+   return &ulidGenerator{}
+}
+
+func (g *ulidGenerator) NewID(collection string) (string, error) {
+   g.mutex.Lock()
+   defer g.mutex.Unlock()
+   
+   ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+   if ms <= g.lastMs {
+      ms = g.lastMs
+      if !increment(g.entropy[:]) {
+         return "", fmt.Errorf("ULID entropy exhausted for this millisecond")
+      }
+   } else {
+      if _, err := rand.Read(g.entropy[:]); err != nil {
+         return "", err
+      }
+      g.lastMs = ms
+   }
+   
+   var b [16]byte
+   b[0], b[1], b[2] = byte(ms >> 40), byte(ms >> 32), byte(ms >> 24)
+   b[3], b[4], b[5] = byte(ms >> 16), byte(ms >> 8), byte(ms)
+   copy(b[6:], g.entropy[:])
+   
+   // 128 bits as 26 base32 digits, most significant first; the leading
+   // digit only carries the top 3 bits.
+   hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+   var out [26]byte
+   for i := 25; i >= 0; i-- {
+      out[i] = crockford[lo & 0x1f]
+      lo = lo >> 5 | hi << 59
+      hi >>= 5
+   }
+   return string(out[:]), nil
+}
+
+func increment(b []byte) bool {
+   for i := len(b) - 1; i >= 0; i-- {
+      b[i]++
+      if b[i] != 0 {
+         return true
+      }
+   }
+   return false
+}
+
+// Counter numbers records 1, 2, 3... per collection, zero-padded to 20
+// digits so they sort numerically. On first use in a collection it resumes
+// after the highest number already stored there.
+type Counter struct {
+   mutex sync.Mutex
+   next  map[string]uint64
+}
+
+func NewCounter() *Counter {
+   return &Counter{next: make(map[string]uint64)}
+}
+
+func (c *Counter) NewID(collection string) (string, error) {
+   c.mutex.Lock()
+   defer c.mutex.Unlock()
+   
+   n := c.next[collection] + 1
+   c.next[collection] = n
+   return fmt.Sprintf("%020d", n), nil
+}
+
+func (c *Counter) seeded(collection string) bool {
+   c.mutex.Lock()
+   defer c.mutex.Unlock()
+   
+   _, ok := c.next[collection]
+   return ok
+}
+
+func (c *Counter) seed(collection string, existing []string) {
+   c.mutex.Lock()
+   defer c.mutex.Unlock()
+   
+   var max uint64
+   for _, name := range existing {
+      if n, err := strconv.ParseUint(name, 10, 64); err == nil && n > max {
+         max = n
+      }
+   }
+   c.next[collection] = max
+}
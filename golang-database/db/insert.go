@@ -0,0 +1,43 @@
+package db
+
+import (
+   "context"
+   "errors"
+)
+
+var (
+   ErrExists   = errors.New("Record already exists")
+   ErrNotFound = errors.New("Record not found")
+)
+
+// Insert stores v under a freshly generated resource name and returns it.
+// Names come from Options.IDGenerator.
+func (d *Driver) Insert(collection string, v interface{}) (string, error) {
+   return d.InsertContext(context.Background(), collection, v)
+}
+
+func (d *Driver) InsertContext(ctx context.Context, collection string, v interface{}) (string, error) {
+   return d.put(ctx, collection, "", v, writeInsert)
+}
+
+// Create is Write for records that must not exist yet. It fails with an
+// error wrapping ErrExists otherwise.
+func (d *Driver) Create(collection, resource string, v interface{}) error {
+   return d.CreateContext(context.Background(), collection, resource, v)
+}
+
+func (d *Driver) CreateContext(ctx context.Context, collection, resource string, v interface{}) error {
+   _, err := d.put(ctx, collection, resource, v, writeCreate)
+   return err
+}
+
+// Update is Write for records that must already exist. It fails with an
+// error wrapping ErrNotFound otherwise.
+func (d *Driver) Update(collection, resource string, v interface{}) error {
+   return d.UpdateContext(context.Background(), collection, resource, v)
+}
+
+func (d *Driver) UpdateContext(ctx context.Context, collection, resource string, v interface{}) error {
+   _, err := d.put(ctx, collection, resource, v, writeUpdate)
+   return err
+}
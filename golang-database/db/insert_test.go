@@ -0,0 +1,102 @@
+package db_test
+
+import (
+   "errors"
+   "regexp"
+   "sort"
+   "testing"
+   "github.com/ayush/golang-database/db"
+)
+
+func TestInsertGeneratesIDs(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   seen := map[string]bool{}
+   for _, u := range sampleUsers {
+      id, err := d.Insert("users", u)
+      if err != nil {
+         t.Fatalf("Insert: %v", err)
+      }
+      if seen[id] {
+         t.Fatalf("Insert reused id %s", id)
+      }
+      seen[id] = true
+      
+      var got user
+      if err := d.Read("users", id, &got); err != nil || got != u {
+         t.Fatalf("Read(%s) = %+v, %v", id, got, err)
+      }
+   }
+}
+
+func TestIDGenerators(t *testing.T) {
+   generators := map[string]struct {
+      gen     db.IDGenerator
+      pattern string
+   }{
+      "uuidv7": {db.UUIDv7(), `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+      "ulid": {db.ULID(), `^[0-9A-HJKMNP-TV-Z]{26}$`},
+      "counter": {db.NewCounter(), `^[0-9]{20}$`},
+   }
+   
+   for name, g := range generators {
+      var ids []string
+      for i := 0; i < 100; i++ {
+         id, err := g.gen.NewID("users")
+         if err != nil {
+            t.Fatalf("%s: NewID: %v", name, err)
+         }
+         if !regexp.MustCompile(g.pattern).MatchString(id) {
+            t.Fatalf("%s: malformed id %q", name, id)
+         }
+         ids = append(ids, id)
+      }
+      
+      if name != "uuidv7" && !sort.StringsAreSorted(ids) {
+         t.Errorf("%s: ids are not monotonic: %v", name, ids)
+      }
+   }
+}
+
+func TestCounterResumesAfterExistingRecords(t *testing.T) {
+   options := quietOptions()
+   options.IDGenerator = db.NewCounter()
+   d, err := db.New(t.TempDir(), options)
+   if err != nil {
+      t.Fatalf("New: %v", err)
+   }
+   
+   d.Write("users", "00000000000000000041", sampleUsers[0])
+   id, err := d.Insert("users", sampleUsers[1])
+   if err != nil {
+      t.Fatalf("Insert: %v", err)
+   }
+   if id != "00000000000000000042" {
+      t.Fatalf("Insert = %s, want 00000000000000000042", id)
+   }
+}
+
+func TestCreateAndUpdate(t *testing.T) {
+   d, _ := newTestDriver(t)
+   
+   if err := d.Update("users", "John", sampleUsers[2]); !errors.Is(err, db.ErrNotFound) {
+      t.Fatalf("Update of a missing record = %v, want ErrNotFound", err)
+   }
+   if err := d.Create("users", "John", sampleUsers[2]); err != nil {
+      t.Fatalf("Create: %v", err)
+   }
+   if err := d.Create("users", "John", sampleUsers[2]); !errors.Is(err, db.ErrExists) {
+      t.Fatalf("second Create = %v, want ErrExists", err)
+   }
+   
+   changed := sampleUsers[2]
+   changed.Company = "Dashboard.io"
+   if err := d.Update("users", "John", changed); err != nil {
+      t.Fatalf("Update: %v", err)
+   }
+   var got user
+   d.Read("users", "John", &got)
+   if got != changed {
+      t.Fatalf("Read after Update = %+v, want %+v", got, changed)
+   }
+}
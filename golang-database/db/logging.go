@@ -0,0 +1,127 @@
+package db
+
+import (
+   "fmt"
+   "strings"
+)
+
+// Level is the severity of a log message.
+type Level int8
+
+const (
+   LevelTrace Level = iota - 2
+   LevelDebug
+   LevelInfo
+   LevelWarn
+   LevelError
+   LevelOff
+)
+
+func (l Level) String() string {
+   switch l {
+      case LevelTrace:
+         return "trace"
+      case LevelDebug:
+         return "debug"
+      case LevelInfo:
+         return "info"
+      case LevelWarn:
+         return "warn"
+      case LevelError:
+         return "error"
+      case LevelOff:
+         return "off"
+   }
+   return fmt.Sprintf("level(%d)", int8(l))
+}
+
+// Field is a key/value pair attached to a log message.
+type Field struct {
+   Key   string
+   Value interface{}
+}
+
+// StructuredLogger is a Logger that also takes key/value fields, such as the
+// adapters in the slogadapter, zapadapter and zerologadapter packages. When
+// Options.Logger implements it, messages about collections and operations
+// carry their collection, resource, duration and byte counts as fields.
+type StructuredLogger interface {
+   Logger
+   Log(level Level, msg string, fields ...Field)
+}
+
+// SetLogLevel sets the level below which messages about collection are
+// dropped, so a single collection can be debugged without flooding the log
+// with the others. An empty collection sets the default, which starts out
+// as Options.LogLevels[""] or LevelInfo. Every operation is logged at
+// LevelDebug. The Logger still applies its own level on top.
+func (d *Driver) SetLogLevel(collection string, level Level) error {
+   collection, err := d.qualify(collection)
+   if err != nil {
+      return err
+   }
+   
+   d.levelMutex.Lock()
+   d.levels[collection] = level
+   d.levelMutex.Unlock()
+   return nil
+}
+
+func (d *Driver) logLevel(collection string) Level {
+   d.levelMutex.RLock()
+   defer d.levelMutex.RUnlock()
+   
+   if level, ok := d.levels[collection]; ok {
+      return level
+   }
+   return d.levels[""]
+}
+
+// logAt logs msg about collection at level, unless the level set for the
+// collection filters it out. Loggers that aren't structured get the fields
+// appended to the message as key=value pairs.
+func (d *Driver) logAt(level Level, collection, msg string, fields ...Field) {
+   if level < d.logLevel(collection) {
+      return
+   }
+   
+   fields = append([]Field{{Key: "collection", Value: collection}}, fields...)
+   if structured, ok := d.log.(StructuredLogger); ok {
+      structured.Log(level, msg, fields...)
+      return
+   }
+   
+   var b strings.Builder
+   b.WriteString(msg)
+   for _, field := range fields {
+      fmt.Fprintf(&b, " %s=%v", field.Key, field.Value)
+   }
+   
+   switch level {
+      case LevelTrace:
+         d.log.Trace("%s\n", b.String())
+      case LevelDebug:
+         d.log.Debug("%s\n", b.String())
+      case LevelInfo:
+         d.log.Info("%s\n", b.String())
+      case LevelWarn:
+         d.log.Warn("%s\n", b.String())
+      default:
+         d.log.Error("%s\n", b.String())
+   }
+}
+
+func (d *Driver) logEvent(event Event) {
+   fields := []Field{
+      {Key: "op", Value: string(event.Op)},
+      {Key: "resource", Value: event.Resource},
+      {Key: "duration", Value: event.Duration},
+      {Key: "lock_wait", Value: event.LockWait},
+      {Key: "bytes_read", Value: event.BytesRead},
+      {Key: "bytes_written", Value: event.BytesWritten},
+   }
+   if event.Err != nil {
+      fields = append(fields, Field{Key: "error", Value: event.Err})
+   }
+   d.logAt(LevelDebug, event.Collection, "Operation finished", fields...)
+}
@@ -0,0 +1,91 @@
+package db_test
+
+import (
+   "strings"
+   "sync"
+   "testing"
+   "github.com/ayush/golang-database/db"
+   "github.com/jcelliott/lumber"
+)
+
+type entry struct {
+   level  db.Level
+   msg    string
+   fields map[string]interface{}
+}
+
+// captureLogger records structured messages and drops printf-style ones.
+type captureLogger struct {
+   *lumber.ConsoleLogger
+   mutex   sync.Mutex
+   entries []entry
+}
+
+func (c *captureLogger) Log(level db.Level, msg string, fields ...db.Field) {
+   e := entry{level: level, msg: msg, fields: map[string]interface{}{}}
+   for _, field := range fields {
+      e.fields[field.Key] = field.Value
+   }
+   
+   c.mutex.Lock()
+   defer c.mutex.Unlock()
+   c.entries = append(c.entries, e)
+}
+
+func (c *captureLogger) collections() []string {
+   c.mutex.Lock()
+   defer c.mutex.Unlock()
+   
+   var names []string
+   for _, e := range c.entries {
+      names = append(names, e.fields["collection"].(string))
+   }
+   return names
+}
+
+func newLoggedDriver(t *testing.T, levels map[string]db.Level) (*db.Driver, *captureLogger) {
+   t.Helper()
+   
+   logger := &captureLogger{ConsoleLogger: lumber.NewConsoleLogger(lumber.FATAL)}
+   d, err := db.New(t.TempDir(), &db.Options{Logger: logger, LogLevels: levels})
+   if err != nil {
+      t.Fatalf("New: %v", err)
+   }
+   return d, logger
+}
+
+func TestOperationsAreLoggedPerCollection(t *testing.T) {
+   d, logger := newLoggedDriver(t, map[string]db.Level{"users": db.LevelDebug})
+   
+   seedUsers(t, d)
+   d.Write("orders", "1", map[string]int{"total": 5})
+   
+   if got := logger.collections(); len(got) != len(sampleUsers) || strings.Join(got, ",") != "users,users,users" {
+      t.Fatalf("logged collections = %v, want only users", got)
+   }
+   
+   e := logger.entries[0]
+   if e.level != db.LevelDebug || e.fields["op"] != "write" || e.fields["resource"] != "Ayush" {
+      t.Fatalf("entry = %+v", e)
+   }
+   if written, ok := e.fields["bytes_written"].(int64); !ok || written == 0 {
+      t.Fatalf("bytes_written = %v", e.fields["bytes_written"])
+   }
+}
+
+func TestSetLogLevel(t *testing.T) {
+   d, logger := newLoggedDriver(t, nil)
+   
+   seedUsers(t, d)
+   if got := logger.collections(); len(got) != 0 {
+      t.Fatalf("logged %v at the default level", got)
+   }
+   
+   d.SetLogLevel("", db.LevelDebug)
+   d.SetLogLevel("users", db.LevelOff)
+   d.Write("orders", "1", map[string]int{"total": 5})
+   seedUsers(t, d)
+   if got := logger.collections(); strings.Join(got, ",") != "orders" {
+      t.Fatalf("logged collections = %v, want only orders", got)
+   }
+}
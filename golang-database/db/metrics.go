@@ -0,0 +1,173 @@
+package db
+
+import (
+   "fmt"
+   "net/http"
+   "sort"
+   "strings"
+   "sync"
+   "time"
+)
+
+type Operation string
+
+const (
+   OpRead    Operation = "read"
+   OpReadAll Operation = "read_all"
+   OpWrite   Operation = "write"
+   OpDelete  Operation = "delete"
+   OpPatch   Operation = "patch"
+   
+   OpAttach         Operation = "attach"
+   OpDetach         Operation = "detach"
+   OpReadAttachment Operation = "read_attachment"
+)
+
+// Event describes a single finished Driver operation. LockWait is the time
+// spent waiting on the collection mutex and is zero for lock-free reads.
+type Event struct {
+   Op           Operation
+   Collection   string
+   Resource     string
+   Start        time.Time
+   Duration     time.Duration
+   LockWait     time.Duration
+   BytesRead    int64
+   BytesWritten int64
+   Err          error
+}
+
+// Observer receives an Event after every Read, ReadAll, Write, Patch and
+// Delete, and after every attachment operation.
+// Observe is called synchronously, so implementations should be cheap and
+// safe for concurrent use.
+type Observer interface {
+   Observe(Event)
+}
+
+// ObserverFunc adapts a plain function into an Observer, handy for wiring
+// the Driver into a tracing library.
+type ObserverFunc func(Event)
+
+func (f ObserverFunc) Observe(e Event) {
+   f(e)
+}
+
+func (d *Driver) begin(op Operation, collection, resource string) *Event {
+   return &Event{
+      Op: op,
+      Collection: collection,
+      Resource: resource,
+      Start: time.Now(),
+   }
+}
+
+func (d *Driver) finish(event *Event, err error) {
+   event.Duration = time.Since(event.Start)
+   event.Err = err
+   d.logEvent(*event)
+   
+   if d.observer != nil {
+      d.observer.Observe(*event)
+   }
+}
+
+type metricKey struct {
+   op         Operation
+   collection string
+}
+
+type metricValues struct {
+   count        uint64
+   errors       uint64
+   seconds      float64
+   lockSeconds  float64
+   bytesRead    int64
+   bytesWritten int64
+}
+
+// PrometheusExporter is an Observer that keeps per-operation, per-collection
+// counters and serves them in the Prometheus text exposition format. Mount
+// it on your metrics endpoint, e.g. http.Handle("/metrics", exporter).
+type PrometheusExporter struct {
+   mutex   sync.Mutex
+   metrics map[metricKey]*metricValues
+}
+
+func NewPrometheusExporter() *PrometheusExporter {
+   return &PrometheusExporter{
+      metrics: make(map[metricKey]*metricValues),
+   }
+}
+
+func (p *PrometheusExporter) Observe(e Event) {
+   p.mutex.Lock()
+   defer p.mutex.Unlock()
+   
+   key := metricKey{op: e.Op, collection: e.Collection}
+   values, ok := p.metrics[key]
+   if !ok {
+      values = &metricValues{}
+      p.metrics[key] = values
+   }
+   
+   values.count++
+   if e.Err != nil {
+      values.errors++
+   }
+   values.seconds += e.Duration.Seconds()
+   values.lockSeconds += e.LockWait.Seconds()
+   values.bytesRead += e.BytesRead
+   values.bytesWritten += e.BytesWritten
+}
+
+func (p *PrometheusExporter) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+   res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+   fmt.Fprint(res, p.String())
+}
+
+// String renders the collected metrics in the text exposition format.
+func (p *PrometheusExporter) String() string {
+   p.mutex.Lock()
+   defer p.mutex.Unlock()
+   
+   keys := make([]metricKey, 0, len(p.metrics))
+   for key := range p.metrics {
+      keys = append(keys, key)
+   }
+   sort.Slice(keys, func(i, j int) bool {
+      if keys[i].collection != keys[j].collection {
+         return keys[i].collection < keys[j].collection
+      }
+      return keys[i].op < keys[j].op
+   })
+   
+   var sb strings.Builder
+   family := func(name, kind, help string, value func(*metricValues) string) {
+      fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+      for _, key := range keys {
+         fmt.Fprintf(&sb, "%s{op=%q,collection=%q} %s\n", name, key.op, key.collection, value(p.metrics[key]))
+      }
+   }
+   
+   family("golangdb_operations_total", "counter", "Driver operations by type and collection.", func(v *metricValues) string {
+      return fmt.Sprint(v.count)
+   })
+   family("golangdb_operation_errors_total", "counter", "Driver operations that returned an error.", func(v *metricValues) string {
+      return fmt.Sprint(v.errors)
+   })
+   family("golangdb_operation_duration_seconds_total", "counter", "Total time spent in Driver operations.", func(v *metricValues) string {
+      return fmt.Sprint(v.seconds)
+   })
+   family("golangdb_lock_wait_seconds_total", "counter", "Total time spent waiting on collection locks.", func(v *metricValues) string {
+      return fmt.Sprint(v.lockSeconds)
+   })
+   family("golangdb_bytes_read_total", "counter", "Bytes read from record files.", func(v *metricValues) string {
+      return fmt.Sprint(v.bytesRead)
+   })
+   family("golangdb_bytes_written_total", "counter", "Bytes written to record files.", func(v *metricValues) string {
+      return fmt.Sprint(v.bytesWritten)
+   })
+   
+   return sb.String()
+}
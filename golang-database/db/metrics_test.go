@@ -0,0 +1,76 @@
+package db_test
+
+import (
+   "net/http/httptest"
+   "strings"
+   "sync"
+   "testing"
+   "github.com/ayush/golang-database/db"
+)
+
+func TestObserverSeesEveryOperation(t *testing.T) {
+   var mutex sync.Mutex
+   var events []db.Event
+   
+   options := quietOptions()
+   options.Observer = db.ObserverFunc(func(e db.Event) {
+      mutex.Lock()
+      events = append(events, e)
+      mutex.Unlock()
+   })
+   d, err := db.New(t.TempDir(), options)
+   if err != nil {
+      t.Fatalf("New: %v", err)
+   }
+   
+   var u user
+   d.Write("users", "John", sampleUsers[2])
+   d.Read("users", "John", &u)
+   d.ReadAll("users")
+   d.Delete("users", "John")
+   d.Read("users", "John", &u)
+   
+   want := []db.Operation{db.OpWrite, db.OpRead, db.OpReadAll, db.OpDelete, db.OpRead}
+   if len(events) != len(want) {
+      t.Fatalf("observed %d events, want %d", len(events), len(want))
+   }
+   for i, e := range events {
+      if e.Op != want[i] || e.Collection != "users" {
+         t.Errorf("event %d = %s on %q, want %s on users", i, e.Op, e.Collection, want[i])
+      }
+   }
+   if events[0].BytesWritten == 0 || events[1].BytesRead != events[0].BytesWritten {
+      t.Errorf("byte counts don't match: wrote %d, read %d", events[0].BytesWritten, events[1].BytesRead)
+   }
+   if events[4].Err == nil {
+      t.Error("reading a deleted record reported no error")
+   }
+}
+
+func TestPrometheusExporter(t *testing.T) {
+   exporter := db.NewPrometheusExporter()
+   options := quietOptions()
+   options.Observer = exporter
+   d, err := db.New(t.TempDir(), options)
+   if err != nil {
+      t.Fatalf("New: %v", err)
+   }
+   
+   var u user
+   d.Write("users", "John", sampleUsers[2])
+   d.Read("users", "Nobody", &u)
+   
+   res := httptest.NewRecorder()
+   exporter.ServeHTTP(res, httptest.NewRequest("GET", "/metrics", nil))
+   body := res.Body.String()
+   
+   for _, line := range []string{
+      `golangdb_operations_total{op="write",collection="users"} 1`,
+      `golangdb_operation_errors_total{op="read",collection="users"} 1`,
+      `# TYPE golangdb_lock_wait_seconds_total counter`,
+   } {
+      if !strings.Contains(body, line) {
+         t.Errorf("metrics output is missing %q:\n%s", line, body)
+      }
+   }
+}
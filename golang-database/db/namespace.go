@@ -0,0 +1,444 @@
+package db
+
+import (
+   "archive/tar"
+   "context"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "io"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "strings"
+)
+
+// Namespaces keep the collections of each tenant apart, under
+// dir/.namespaces/<name>/. A namespace handle uses plain collection names;
+// everywhere else - on the root Driver, in events, policies and replicated
+// changes - they are called "<name>/<collection>".
+const (
+   namespaceDir = ".namespaces"
+   quotaFile    = ".quota.json"
+)
+
+// ErrQuota is returned, wrapped, when a write would take a namespace over
+// its Quota.
+var ErrQuota = errors.New("Quota exceeded")
+
+// Quota limits what a namespace may store. Zero means no limit.
+type Quota struct {
+   MaxRecords int64
+   MaxBytes   int64
+}
+
+// Usage is what a namespace stores: its records, and the bytes taken by
+// records and attachments.
+type Usage struct {
+   Records int64
+   Bytes   int64
+}
+
+// Namespace returns a handle whose collections are private to the tenant
+// name. It shares locks, options and replication with d.
+func (d *Driver) Namespace(name string) (*Driver, error) {
+   if d.namespace != "" {
+      return nil, fmt.Errorf("'%s' is a namespace already! Namespaces don't nest.", d.namespace)
+   }
+   if err := validNamespace(name); err != nil {
+      return nil, err
+   }
+   return &Driver{store: d.store, principal: d.principal, scoped: d.scoped, namespace: name}, nil
+}
+
+func validNamespace(name string) error {
+   if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+      return fmt.Errorf("Invalid namespace name '%s'!", name)
+   }
+   return nil
+}
+
+// qualify turns a collection name as the handle sees it into the store-wide
+// one. Names that could lead out of the namespace, or out of the data
+// directory, are refused: a namespace handle takes a plain name, the root
+// Driver at most "<namespace>/<collection>".
+func (d *Driver) qualify(collection string) (string, error) {
+   if collection == "" {
+      return "", nil
+   }
+   
+   if err := validCollection(collection, d.namespace == ""); err != nil {
+      return "", err
+   }
+   
+   if d.namespace == "" {
+      return collection, nil
+   }
+   return d.namespace + "/" + collection, nil
+}
+
+// validCollection refuses collection names that could lead out of the data
+// directory. qualified names may be "<namespace>/<collection>".
+func validCollection(collection string, qualified bool) error {
+   parts := strings.Split(collection, "/")
+   if len(parts) > 2 || !qualified && len(parts) > 1 {
+      return fmt.Errorf("Invalid collection name '%s'!", collection)
+   }
+   for _, part := range parts {
+      if part == "" || strings.HasPrefix(part, ".") || strings.Contains(part, "..") || strings.Contains(part, `\`) {
+         return fmt.Errorf("Invalid collection name '%s'!", collection)
+      }
+   }
+   return nil
+}
+
+// validResource refuses record names that aren't a plain file name, so a
+// resource can't lead into another collection or namespace. Empty names are
+// left to the callers, some of which take them for the whole collection.
+func validResource(resource string) error {
+   if strings.HasPrefix(resource, ".") || strings.Contains(resource, "..") || strings.ContainsAny(resource, `/\`) {
+      return fmt.Errorf("Invalid resource name '%s'!", resource)
+   }
+   return nil
+}
+
+func (d *Driver) unqualify(collection string) string {
+   if d.namespace == "" {
+      return collection
+   }
+   return strings.TrimPrefix(collection, d.namespace + "/")
+}
+
+func splitNamespace(collection string) (namespace, name string, ok bool) {
+   i := strings.Index(collection, "/")
+   if i < 0 {
+      return "", collection, false
+   }
+   return collection[:i], collection[i + 1:], true
+}
+
+func (d *Driver) collectionDir(collection string) string {
+   if namespace, name, ok := splitNamespace(collection); ok {
+      return filepath.Join(d.dir, namespaceDir, namespace, name)
+   }
+   return filepath.Join(d.dir, collection)
+}
+
+// collections lists the collections the handle can see by their store-wide
+// names. The root Driver sees those of every namespace too.
+func (d *Driver) collections() ([]string, error) {
+   if d.namespace != "" {
+      return d.namespaceCollections(d.namespace)
+   }
+   
+   collections, err := subdirectories(d.dir)
+   if err != nil {
+      return nil, err
+   }
+   
+   namespaces, err := subdirectories(filepath.Join(d.dir, namespaceDir))
+   if err != nil && !os.IsNotExist(err) {
+      return nil, err
+   }
+   for _, namespace := range namespaces {
+      names, err := d.namespaceCollections(namespace)
+      if err != nil {
+         return nil, err
+      }
+      collections = append(collections, names...)
+   }
+   return collections, nil
+}
+
+func (d *Driver) namespaceCollections(namespace string) ([]string, error) {
+   names, err := subdirectories(filepath.Join(d.dir, namespaceDir, namespace))
+   if os.IsNotExist(err) {
+      return nil, nil
+   }
+   for i, name := range names {
+      names[i] = namespace + "/" + name
+   }
+   return names, err
+}
+
+// subdirectories lists the directories in dir that aren't hidden.
+func subdirectories(dir string) ([]string, error) {
+   entries, err := ioutil.ReadDir(dir)
+   if err != nil {
+      return nil, err
+   }
+   
+   var names []string
+   for _, entry := range entries {
+      if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+         names = append(names, entry.Name())
+      }
+   }
+   return names, nil
+}
+
+// SetQuota limits what the namespace of the handle may store. The quota is
+// saved with the namespace, so it survives restarts. Writes that would
+// exceed it fail with ErrQuota; data already stored is never removed.
+func (d *Driver) SetQuota(q Quota) error {
+   if d.namespace == "" {
+      return fmt.Errorf("Quotas apply to namespaces, not the root of the store!")
+   }
+   if err := d.authorizeAdmin(); err != nil {
+      return err
+   }
+   
+   b, err := json.Marshal(q)
+   if err != nil {
+      return err
+   }
+   dir := filepath.Join(d.dir, namespaceDir, d.namespace)
+   if err := os.MkdirAll(dir, 0755); err != nil {
+      return err
+   }
+   if err := ioutil.WriteFile(filepath.Join(dir, quotaFile), b, 0644); err != nil {
+      return err
+   }
+   
+   d.mutex.Lock()
+   d.quotas[d.namespace] = q
+   delete(d.usage, d.namespace)
+   d.mutex.Unlock()
+   return nil
+}
+
+// Quota returns the quota of the namespace of the handle.
+func (d *Driver) Quota() (Quota, error) {
+   if d.namespace == "" {
+      return Quota{}, nil
+   }
+   return d.quota(d.namespace)
+}
+
+func (d *Driver) quota(namespace string) (Quota, error) {
+   d.mutex.Lock()
+   q, ok := d.quotas[namespace]
+   d.mutex.Unlock()
+   if ok {
+      return q, nil
+   }
+   
+   b, err := ioutil.ReadFile(filepath.Join(d.dir, namespaceDir, namespace, quotaFile))
+   if err == nil {
+      err = json.Unmarshal(b, &q)
+   } else if os.IsNotExist(err) {
+      err = nil
+   }
+   if err != nil {
+      return q, err
+   }
+   
+   d.mutex.Lock()
+   d.quotas[namespace] = q
+   d.mutex.Unlock()
+   return q, nil
+}
+
+// Usage measures what the namespace of the handle stores.
+func (d *Driver) Usage() (Usage, error) {
+   if d.namespace == "" {
+      return Usage{}, fmt.Errorf("Usage is tracked for namespaces, not the root of the store!")
+   }
+   return d.measure(d.namespace)
+}
+
+func (d *Driver) measure(namespace string) (Usage, error) {
+   var usage Usage
+   collections, err := d.namespaceCollections(namespace)
+   if err != nil {
+      return usage, err
+   }
+   
+   for _, collection := range collections {
+      dir := d.collectionDir(collection)
+      files, err := d.collectionFiles(collection)
+      if err != nil && !os.IsNotExist(err) {
+         return usage, err
+      }
+      for _, file := range files {
+         if !strings.HasSuffix(file, ".json") {
+            continue
+         }
+         if info, err := os.Stat(filepath.Join(dir, file)); err == nil {
+            usage.Records++
+            usage.Bytes += info.Size()
+         }
+      }
+      
+      blobs, err := ioutil.ReadDir(filepath.Join(dir, blobDir))
+      if err != nil && !os.IsNotExist(err) {
+         return usage, err
+      }
+      for _, blob := range blobs {
+         if !strings.HasPrefix(blob.Name(), ".") {
+            usage.Bytes += blob.Size()
+         }
+      }
+   }
+   return usage, nil
+}
+
+// account adds records and bytes to the usage of the namespace collection
+// belongs to. With enforce set, growing beyond the quota fails with
+// ErrQuota instead. Usage is only tracked for namespaces with a quota; it
+// is measured on first use and kept up to date from then on.
+func (d *Driver) account(collection string, records, bytes int64, enforce bool) error {
+   namespace, _, ok := splitNamespace(collection)
+   if !ok {
+      return nil
+   }
+   
+   q, err := d.quota(namespace)
+   if err != nil {
+      return err
+   }
+   
+   d.mutex.Lock()
+   usage, tracked := d.usage[namespace]
+   d.mutex.Unlock()
+   if !tracked {
+      if q == (Quota{}) {
+         return nil
+      }
+      measured, err := d.measure(namespace)
+      if err != nil {
+         return err
+      }
+      
+      d.mutex.Lock()
+      if usage, tracked = d.usage[namespace]; !tracked {
+         usage = &measured
+         d.usage[namespace] = usage
+      }
+      d.mutex.Unlock()
+   }
+   
+   d.mutex.Lock()
+   defer d.mutex.Unlock()
+   if enforce {
+      if q.MaxRecords > 0 && records > 0 && usage.Records + records > q.MaxRecords {
+         return fmt.Errorf("%w: namespace '%s' is limited to %d records", ErrQuota, namespace, q.MaxRecords)
+      }
+      if q.MaxBytes > 0 && bytes > 0 && usage.Bytes + bytes > q.MaxBytes {
+         return fmt.Errorf("%w: namespace '%s' is limited to %d bytes", ErrQuota, namespace, q.MaxBytes)
+      }
+   }
+   usage.Records += records
+   usage.Bytes += bytes
+   return nil
+}
+
+func (d *Driver) forgetUsage(collection string) {
+   if namespace, _, ok := splitNamespace(collection); ok {
+      d.mutex.Lock()
+      delete(d.usage, namespace)
+      d.mutex.Unlock()
+   }
+}
+
+// DropNamespace deletes a namespace with all its collections, attachments
+// and its quota. Followers drop its collections as well.
+func (d *Driver) DropNamespace(name string) error {
+   if d.namespace != "" {
+      return fmt.Errorf("Namespaces can only be dropped from the root of the store!")
+   }
+   if err := validNamespace(name); err != nil {
+      return err
+   }
+   if err := d.authorizeAdmin(); err != nil {
+      return err
+   }
+   
+   collections, err := d.namespaceCollections(name)
+   if err != nil {
+      return err
+   }
+   for _, collection := range collections {
+      if err := d.Delete(collection, ""); err != nil {
+         return err
+      }
+   }
+   
+   if err := os.RemoveAll(filepath.Join(d.dir, namespaceDir, name)); err != nil {
+      return err
+   }
+   
+   d.mutex.Lock()
+   delete(d.quotas, name)
+   delete(d.usage, name)
+   d.mutex.Unlock()
+   return nil
+}
+
+// Export writes every collection the handle can see, attachments included,
+// to w as a tar archive with one directory per collection. Each collection
+// is copied under its lock, so it is consistent in itself, though others may
+// change in the meantime.
+func (d *Driver) Export(w io.Writer) error {
+   return d.ExportContext(context.Background(), w)
+}
+
+func (d *Driver) ExportContext(ctx context.Context, w io.Writer) error {
+   collections, err := d.collections()
+   if err != nil {
+      return err
+   }
+   
+   tw := tar.NewWriter(w)
+   for _, collection := range collections {
+      if err := d.authorize(RightRead, collection, ""); err != nil {
+         return err
+      }
+      if err := d.exportCollection(ctx, tw, collection); err != nil {
+         return err
+      }
+   }
+   return tw.Close()
+}
+
+func (d *Driver) exportCollection(ctx context.Context, tw *tar.Writer, collection string) error {
+   mutex := d.GetOrCreateMutex(collection)
+   if err := mutex.LockContext(ctx); err != nil {
+      return err
+   }
+   defer mutex.Unlock()
+   
+   dir := d.collectionDir(collection)
+   return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+      if err != nil {
+         return err
+      }
+      if err := ctx.Err(); err != nil {
+         return err
+      }
+      if info.IsDir() || strings.HasSuffix(path, ".tmp") || strings.HasPrefix(info.Name(), ".tmp-") {
+         return nil
+      }
+      
+      rel, err := filepath.Rel(dir, path)
+      if err != nil {
+         return err
+      }
+      header, err := tar.FileInfoHeader(info, "")
+      if err != nil {
+         return err
+      }
+      header.Name = filepath.ToSlash(filepath.Join(d.unqualify(collection), rel))
+      if err := tw.WriteHeader(header); err != nil {
+         return err
+      }
+      
+      f, err := os.Open(path)
+      if err != nil {
+         return err
+      }
+      defer f.Close()
+      _, err = io.Copy(tw, f)
+      return err
+   })
+}
@@ -0,0 +1,217 @@
+package db_test
+
+import (
+   "archive/tar"
+   "bytes"
+   "encoding/json"
+   "errors"
+   "io"
+   "os"
+   "path/filepath"
+   "sort"
+   "strings"
+   "testing"
+   "github.com/ayush/golang-database/db"
+)
+
+func newTenant(t *testing.T, d *db.Driver, name string) *db.Driver {
+   t.Helper()
+   
+   tenant, err := d.Namespace(name)
+   if err != nil {
+      t.Fatalf("Namespace(%s): %v", name, err)
+   }
+   return tenant
+}
+
+func TestNamespacesAreIsolated(t *testing.T) {
+   d, dir := newTestDriver(t)
+   acme, globex := newTenant(t, d, "acme"), newTenant(t, d, "globex")
+   seedUsers(t, acme)
+   globex.Write("users", "Hank", sampleUsers[0])
+   
+   if count, err := acme.Count("users"); err != nil || count != len(sampleUsers) {
+      t.Fatalf("acme Count = %d, %v", count, err)
+   }
+   if count, err := globex.Count("users"); err != nil || count != 1 {
+      t.Fatalf("globex Count = %d, %v", count, err)
+   }
+   if _, err := d.ReadAll("users"); !os.IsNotExist(err) {
+      t.Fatalf("root ReadAll = %v, want the collection to be missing", err)
+   }
+   
+   // The root handle reaches tenants through qualified names.
+   var got user
+   if err := d.Read("acme/users", "Ayush", &got); err != nil || got != sampleUsers[0] {
+      t.Fatalf("Read(acme/users) = %+v, %v", got, err)
+   }
+   if _, err := os.Stat(filepath.Join(dir, ".namespaces", "acme", "users", "Ayush.json")); err != nil {
+      t.Fatalf("record not stored under the namespace: %v", err)
+   }
+   
+   if _, err := d.Namespace("../etc"); err == nil {
+      t.Fatal("Namespace accepted a path")
+   }
+}
+
+func TestNamespacesCantBeEscaped(t *testing.T) {
+   d, dir := newTestDriver(t)
+   acme, globex := newTenant(t, d, "acme"), newTenant(t, d, "globex")
+   globex.Write("users", "Hank", sampleUsers[0])
+   
+   for _, collection := range []string{"../globex/users", "globex/users", `..\globex\users`, ".quota.json", ".."} {
+      var got user
+      if err := acme.Read(collection, "Hank", &got); err == nil {
+         t.Errorf("acme read %s/Hank: %+v", collection, got)
+      }
+      if err := acme.Write(collection, "Mallory", sampleUsers[1]); err == nil {
+         t.Errorf("acme wrote to %s", collection)
+      }
+      if err := acme.Delete(collection, "Hank"); err == nil {
+         t.Errorf("acme deleted from %s", collection)
+      }
+      if err := acme.AfterWrite(collection, func(string, string, json.RawMessage, json.RawMessage) error { return nil }); err == nil {
+         t.Errorf("acme hooked into %s", collection)
+      }
+   }
+   
+   if _, err := os.Stat(filepath.Join(dir, ".namespaces", "globex", "users", "Mallory.json")); !os.IsNotExist(err) {
+      t.Fatal("a write from acme ended up in globex")
+   }
+   var got user
+   if err := globex.Read("users", "Hank", &got); err != nil {
+      t.Fatalf("globex lost its record: %v", err)
+   }
+   if err := d.Read("../outside", "x", &got); err == nil {
+      t.Fatal("the root handle read outside the data directory")
+   }
+}
+
+func TestResourcesCantEscapeTheirCollection(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   acme := newTenant(t, d, "acme")
+   acme.Write("users", "Hank", sampleUsers[0])
+   
+   for _, resource := range []string{"../../../users/Ayush", "../users/Ayush", `..\..\..\users\Ayush`, "x/../Hank", ".hidden", ".."} {
+      var got user
+      if err := acme.Read("c", resource, &got); err == nil {
+         t.Errorf("acme read c/%s: %+v", resource, got)
+      }
+      if err := acme.Write("c", resource, sampleUsers[1]); err == nil {
+         t.Errorf("acme wrote c/%s", resource)
+      }
+      if err := acme.Create("c", resource, sampleUsers[1]); err == nil {
+         t.Errorf("acme created c/%s", resource)
+      }
+      if err := acme.Update("users", resource, sampleUsers[1]); err == nil {
+         t.Errorf("acme updated users/%s", resource)
+      }
+      if err := acme.Patch("users", resource, []byte(`{"Name": "Mallory"}`)); err == nil {
+         t.Errorf("acme patched users/%s", resource)
+      }
+      if err := acme.PutAttachment("users", resource, "a.txt", strings.NewReader("x")); err == nil {
+         t.Errorf("acme attached to users/%s", resource)
+      }
+      if _, err := acme.Attachments("users", resource); err == nil {
+         t.Errorf("acme listed the attachments of users/%s", resource)
+      }
+      if err := acme.Delete("c", resource); err == nil {
+         t.Errorf("acme deleted c/%s", resource)
+      }
+   }
+   
+   var got user
+   if err := d.Read("users", "Ayush", &got); err != nil || got.Name != sampleUsers[0].Name {
+      t.Fatalf("root record after the attempts = %+v, %v", got, err)
+   }
+   if err := acme.Read("users", "Hank", &got); err != nil {
+      t.Fatalf("acme lost its record: %v", err)
+   }
+}
+
+func TestNamespaceQuotas(t *testing.T) {
+   d, dir := newTestDriver(t)
+   acme := newTenant(t, d, "acme")
+   seedUsers(t, acme)
+   
+   if err := acme.SetQuota(db.Quota{MaxRecords: int64(len(sampleUsers))}); err != nil {
+      t.Fatalf("SetQuota: %v", err)
+   }
+   if err := acme.Write("orders", "1", map[string]int{"total": 5}); !errors.Is(err, db.ErrQuota) {
+      t.Fatalf("Write over the record quota = %v, want ErrQuota", err)
+   }
+   if err := acme.Write("users", "Ayush", sampleUsers[1]); err != nil {
+      t.Fatalf("rewriting a record within the quota: %v", err)
+   }
+   if err := acme.Delete("users", "John"); err != nil {
+      t.Fatalf("Delete: %v", err)
+   }
+   if err := acme.Write("orders", "1", map[string]int{"total": 5}); err != nil {
+      t.Fatalf("Write after freeing a record: %v", err)
+   }
+   
+   usage, err := acme.Usage()
+   if err != nil {
+      t.Fatalf("Usage: %v", err)
+   }
+   if err := acme.SetQuota(db.Quota{MaxBytes: usage.Bytes + 10}); err != nil {
+      t.Fatalf("SetQuota: %v", err)
+   }
+   err = acme.PutAttachment("users", "Ayush", "cv.pdf", strings.NewReader(strings.Repeat("x", 100)))
+   if !errors.Is(err, db.ErrQuota) {
+      t.Fatalf("PutAttachment over the byte quota = %v, want ErrQuota", err)
+   }
+   
+   // Quotas are kept with the namespace.
+   reopened, err := db.New(dir, quietOptions())
+   if err != nil {
+      t.Fatalf("New: %v", err)
+   }
+   if q, err := newTenant(t, reopened, "acme").Quota(); err != nil || q.MaxBytes != usage.Bytes + 10 {
+      t.Fatalf("Quota after reopening = %+v, %v", q, err)
+   }
+}
+
+func TestNamespaceExportAndDrop(t *testing.T) {
+   d, dir := newTestDriver(t)
+   acme := newTenant(t, d, "acme")
+   seedUsers(t, acme)
+   acme.PutAttachment("users", "Ayush", "avatar.png", strings.NewReader("png"))
+   d.Write("users", "Root", sampleUsers[0])
+   
+   var buf bytes.Buffer
+   if err := acme.Export(&buf); err != nil {
+      t.Fatalf("Export: %v", err)
+   }
+   
+   var names []string
+   archive := tar.NewReader(&buf)
+   for {
+      header, err := archive.Next()
+      if err == io.EOF {
+         break
+      }
+      if err != nil {
+         t.Fatalf("reading the export: %v", err)
+      }
+      if !strings.HasPrefix(header.Name, ".") && !strings.Contains(header.Name, "/.") {
+         names = append(names, header.Name)
+      }
+   }
+   sort.Strings(names)
+   want := []string{"users/Alkesh.json", "users/Ayush.json", "users/John.json"}
+   if strings.Join(names, " ") != strings.Join(want, " ") {
+      t.Fatalf("exported %v, want %v", names, want)
+   }
+   
+   if err := d.DropNamespace("acme"); err != nil {
+      t.Fatalf("DropNamespace: %v", err)
+   }
+   if _, err := os.Stat(filepath.Join(dir, ".namespaces", "acme")); !os.IsNotExist(err) {
+      t.Fatalf("namespace left behind: %v", err)
+   }
+   if count, err := d.Count("users"); err != nil || count != 1 {
+      t.Fatalf("root Count after dropping a namespace = %d, %v", count, err)
+   }
+}
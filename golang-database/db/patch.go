@@ -0,0 +1,428 @@
+package db
+
+import (
+   "bytes"
+   "context"
+   "encoding/json"
+   "fmt"
+   "os"
+   "strconv"
+   "strings"
+)
+
+// Patch applies a partial update to an existing record while holding the
+// collection lock, so it can't race with other writers. A patch that is a
+// JSON array is treated as an RFC 6902 JSON Patch, a JSON object as an
+// RFC 7386 merge patch. Either the whole patch applies or nothing is
+// written.
+func (d *Driver) Patch(collection, resource string, patch []byte) error {
+   return d.PatchContext(context.Background(), collection, resource, patch)
+}
+
+func (d *Driver) PatchContext(ctx context.Context, collection, resource string, patch []byte) (err error) {
+   collection, err = d.qualify(collection)
+   if err != nil {
+      return err
+   }
+   event := d.begin(OpPatch, collection, resource)
+   defer func() { d.finish(event, err) }()
+   
+   if collection == "" {
+      return fmt.Errorf("Collection empty! No place to save record.")
+   }
+   
+   if resource == "" {
+      return fmt.Errorf("Missing resource! unable to save record. (no name)")
+   }
+   if err := validResource(resource); err != nil {
+      return err
+   }
+   
+   if err := d.authorize(RightWrite, collection, resource); err != nil {
+      return err
+   }
+   
+   if d.isFollower() {
+      return ErrReadOnly
+   }
+   
+   unlock, err := d.lock(ctx, collection, event)
+   if err != nil {
+      return err
+   }
+   defer unlock()
+   
+   current, err := d.readRecord(collection, resource)
+   if os.IsNotExist(err) {
+      return fmt.Errorf("Unable to patch '%s/%s', no such record", collection, resource)
+   }
+   if err != nil {
+      return err
+   }
+   event.BytesRead = int64(len(current))
+   
+   doc, err := decodeValue(current)
+   if err != nil {
+      return err
+   }
+   
+   switch trimmed := bytes.TrimSpace(patch); {
+      case len(trimmed) > 0 && trimmed[0] == '[':
+         doc, err = applyJSONPatch(doc, trimmed)
+      case len(trimmed) > 0 && trimmed[0] == '{':
+         var p interface{}
+         if p, err = decodeValue(trimmed); err == nil {
+            doc = mergePatch(doc, p)
+         }
+      default:
+         err = fmt.Errorf("Patch must be a JSON object (merge patch) or array (JSON Patch)")
+   }
+   if err != nil {
+      return err
+   }
+   
+   b, err := json.MarshalIndent(doc, "", "\t")
+   if err != nil {
+      return err
+   }
+   b = append(b, byte('\n'))
+   
+   if err := ctx.Err(); err != nil {
+      return err
+   }
+   
+   return d.commit(collection, resource, b, event)
+}
+
+func decodeValue(b []byte) (interface{}, error) {
+   var v interface{}
+   dec := json.NewDecoder(bytes.NewReader(b))
+   dec.UseNumber()
+   if err := dec.Decode(&v); err != nil {
+      return nil, err
+   }
+   return v, nil
+}
+
+// mergePatch implements RFC 7386: objects merge recursively, null removes a
+// member and anything else replaces the target outright.
+func mergePatch(target, patch interface{}) interface{} {
+   p, ok := patch.(map[string]interface{})
+   if !ok {
+      return patch
+   }
+   
+   t, ok := target.(map[string]interface{})
+   if !ok {
+      t = map[string]interface{}{}
+   }
+   
+   for key, value := range p {
+      if value == nil {
+         delete(t, key)
+      } else {
+         t[key] = mergePatch(t[key], value)
+      }
+   }
+   return t
+}
+
+type patchOperation struct {
+   Op    string          `json:"op"`
+   Path  string          `json:"path"`
+   From  string          `json:"from"`
+   Value json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch implements RFC 6902. The document is modified in place, so
+// callers must not keep using it when an error is returned.
+func applyJSONPatch(doc interface{}, patch []byte) (interface{}, error) {
+   var ops []patchOperation
+   if err := json.Unmarshal(patch, &ops); err != nil {
+      return nil, err
+   }
+   
+   for i, op := range ops {
+      var err error
+      doc, err = applyOperation(doc, op)
+      if err != nil {
+         return nil, fmt.Errorf("JSON Patch operation %d (%s %s): %v", i, op.Op, op.Path, err)
+      }
+   }
+   return doc, nil
+}
+
+func applyOperation(doc interface{}, op patchOperation) (interface{}, error) {
+   path, err := parsePointer(op.Path)
+   if err != nil {
+      return nil, err
+   }
+   
+   var value interface{}
+   switch op.Op {
+      case "add", "replace", "test":
+         if op.Value == nil {
+            return nil, fmt.Errorf("missing value")
+         }
+         if value, err = decodeValue(op.Value); err != nil {
+            return nil, err
+         }
+   }
+   
+   switch op.Op {
+      case "add":
+         return addAt(doc, path, value)
+      case "remove":
+         doc, _, err = removeAt(doc, path)
+         return doc, err
+      case "replace":
+         if len(path) == 0 {
+            return value, nil
+         }
+         if _, err := getAt(doc, path); err != nil {
+            return nil, err
+         }
+         if doc, _, err = removeAt(doc, path); err != nil {
+            return nil, err
+         }
+         return addAt(doc, path, value)
+      case "move":
+         from, err := parsePointer(op.From)
+         if err != nil {
+            return nil, err
+         }
+         if op.Path != op.From && strings.HasPrefix(op.Path, op.From + "/") {
+            return nil, fmt.Errorf("can't move %s into one of its children", op.From)
+         }
+         doc, moved, err := removeAt(doc, from)
+         if err != nil {
+            return nil, err
+         }
+         return addAt(doc, path, moved)
+      case "copy":
+         from, err := parsePointer(op.From)
+         if err != nil {
+            return nil, err
+         }
+         copied, err := getAt(doc, from)
+         if err != nil {
+            return nil, err
+         }
+         return addAt(doc, path, deepCopy(copied))
+      case "test":
+         actual, err := getAt(doc, path)
+         if err != nil {
+            return nil, err
+         }
+         if !jsonEqual(actual, value) {
+            return nil, fmt.Errorf("test failed")
+         }
+         return doc, nil
+      default:
+         return nil, fmt.Errorf("unknown operation")
+   }
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+   if pointer == "" {
+      return nil, nil
+   }
+   if !strings.HasPrefix(pointer, "/") {
+      return nil, fmt.Errorf("invalid JSON pointer '%s'", pointer)
+   }
+   
+   tokens := strings.Split(pointer[1:], "/")
+   for i, token := range tokens {
+      tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+   }
+   return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+   if token == "-" && allowEnd {
+      return length, nil
+   }
+   
+   i, err := strconv.Atoi(token)
+   if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+      return 0, fmt.Errorf("invalid array index '%s'", token)
+   }
+   if i > length || (i == length && !allowEnd) {
+      return 0, fmt.Errorf("array index %d out of range", i)
+   }
+   return i, nil
+}
+
+func getAt(doc interface{}, path []string) (interface{}, error) {
+   current := doc
+   for _, token := range path {
+      switch node := current.(type) {
+         case map[string]interface{}:
+            value, ok := node[token]
+            if !ok {
+               return nil, fmt.Errorf("path not found")
+            }
+            current = value
+         case []interface{}:
+            i, err := arrayIndex(token, len(node), false)
+            if err != nil {
+               return nil, err
+            }
+            current = node[i]
+         default:
+            return nil, fmt.Errorf("path not found")
+      }
+   }
+   return current, nil
+}
+
+// mutate walks to the parent of the last token of path and hands it to
+// leaf, storing whatever leaf returns back into the tree. Arrays have to be
+// re-stored because inserting or removing elements reallocates them.
+func mutate(doc interface{}, path []string, leaf func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+   if len(path) == 1 {
+      return leaf(doc, path[0])
+   }
+   
+   switch node := doc.(type) {
+      case map[string]interface{}:
+         child, ok := node[path[0]]
+         if !ok {
+            return nil, fmt.Errorf("path not found")
+         }
+         child, err := mutate(child, path[1:], leaf)
+         if err != nil {
+            return nil, err
+         }
+         node[path[0]] = child
+         return node, nil
+      case []interface{}:
+         i, err := arrayIndex(path[0], len(node), false)
+         if err != nil {
+            return nil, err
+         }
+         child, err := mutate(node[i], path[1:], leaf)
+         if err != nil {
+            return nil, err
+         }
+         node[i] = child
+         return node, nil
+      default:
+         return nil, fmt.Errorf("path not found")
+   }
+}
+
+func addAt(doc interface{}, path []string, value interface{}) (interface{}, error) {
+   if len(path) == 0 {
+      return value, nil
+   }
+   
+   return mutate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+      switch node := parent.(type) {
+         case map[string]interface{}:
+            node[token] = value
+            return node, nil
+         case []interface{}:
+            i, err := arrayIndex(token, len(node), true)
+            if err != nil {
+               return nil, err
+            }
+            node = append(node, nil)
+            copy(node[i + 1:], node[i:])
+            node[i] = value
+            return node, nil
+         default:
+            return nil, fmt.Errorf("parent is not an object or array")
+      }
+   })
+}
+
+func removeAt(doc interface{}, path []string) (interface{}, interface{}, error) {
+   if len(path) == 0 {
+      return nil, nil, fmt.Errorf("can't remove the whole document")
+   }
+   
+   var removed interface{}
+   doc, err := mutate(doc, path, func(parent interface{}, token string) (interface{}, error) {
+      switch node := parent.(type) {
+         case map[string]interface{}:
+            value, ok := node[token]
+            if !ok {
+               return nil, fmt.Errorf("path not found")
+            }
+            removed = value
+            delete(node, token)
+            return node, nil
+         case []interface{}:
+            i, err := arrayIndex(token, len(node), false)
+            if err != nil {
+               return nil, err
+            }
+            removed = node[i]
+            return append(node[:i], node[i + 1:]...), nil
+         default:
+            return nil, fmt.Errorf("path not found")
+      }
+   })
+   return doc, removed, err
+}
+
+func deepCopy(value interface{}) interface{} {
+   switch v := value.(type) {
+      case map[string]interface{}:
+         c := make(map[string]interface{}, len(v))
+         for key, child := range v {
+            c[key] = deepCopy(child)
+         }
+         return c
+      case []interface{}:
+         c := make([]interface{}, len(v))
+         for i, child := range v {
+            c[i] = deepCopy(child)
+         }
+         return c
+      default:
+         return v
+   }
+}
+
+// jsonEqual compares decoded JSON values, treating numbers as equal when
+// their values are, so 1 and 1.0 pass a "test" operation.
+func jsonEqual(a, b interface{}) bool {
+   switch x := a.(type) {
+      case map[string]interface{}:
+         y, ok := b.(map[string]interface{})
+         if !ok || len(x) != len(y) {
+            return false
+         }
+         for key, value := range x {
+            other, ok := y[key]
+            if !ok || !jsonEqual(value, other) {
+               return false
+            }
+         }
+         return true
+      case []interface{}:
+         y, ok := b.([]interface{})
+         if !ok || len(x) != len(y) {
+            return false
+         }
+         for i := range x {
+            if !jsonEqual(x[i], y[i]) {
+               return false
+            }
+         }
+         return true
+      case json.Number:
+         y, ok := b.(json.Number)
+         if !ok {
+            return false
+         }
+         fx, errX := x.Float64()
+         fy, errY := y.Float64()
+         return errX == nil && errY == nil && fx == fy
+      default:
+         return a == b
+   }
+}
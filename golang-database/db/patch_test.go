@@ -0,0 +1,115 @@
+package db_test
+
+import (
+   "testing"
+)
+
+func TestMergePatch(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   patch := `{"Address": {"City": "Pune", "Country": null}, "Contact": "5550100"}`
+   if err := d.Patch("users", "John", []byte(patch)); err != nil {
+      t.Fatalf("Patch: %v", err)
+   }
+   
+   var got user
+   if err := d.Read("users", "John", &got); err != nil {
+      t.Fatalf("Read: %v", err)
+   }
+   want := sampleUsers[2]
+   want.Address = address{City: "Pune"}
+   want.Contact = "5550100"
+   if got != want {
+      t.Fatalf("after merge patch got %+v, want %+v", got, want)
+   }
+}
+
+func TestJSONPatch(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   patch := `[
+      {"op": "test", "path": "/Age", "value": 19.0},
+      {"op": "replace", "path": "/Address/City", "value": "Pune"},
+      {"op": "copy", "from": "/Company", "path": "/Contact"},
+      {"op": "add", "path": "/Tags", "value": ["a"]},
+      {"op": "add", "path": "/Tags/-", "value": "c"},
+      {"op": "add", "path": "/Tags/1", "value": "b"},
+      {"op": "move", "from": "/Tags", "path": "/Labels"},
+      {"op": "remove", "path": "/Labels"}
+   ]`
+   if err := d.Patch("users", "John", []byte(patch)); err != nil {
+      t.Fatalf("Patch: %v", err)
+   }
+   
+   var got user
+   if err := d.Read("users", "John", &got); err != nil {
+      t.Fatalf("Read: %v", err)
+   }
+   if got.Address.City != "Pune" || got.Contact != "Expansion Tricks" {
+      t.Fatalf("unexpected record after JSON Patch: %+v", got)
+   }
+   
+   var raw map[string]interface{}
+   d.Read("users", "John", &raw)
+   if _, ok := raw["Labels"]; ok {
+      t.Fatal("removed member is still present")
+   }
+}
+
+func TestJSONPatchArrayOperations(t *testing.T) {
+   d, _ := newTestDriver(t)
+   d.Write("lists", "l", map[string]interface{}{"items": []string{"a", "c"}})
+   
+   patch := `[
+      {"op": "add", "path": "/items/1", "value": "b"},
+      {"op": "add", "path": "/items/-", "value": "d"},
+      {"op": "remove", "path": "/items/0"},
+      {"op": "test", "path": "/items", "value": ["b", "c", "d"]}
+   ]`
+   if err := d.Patch("lists", "l", []byte(patch)); err != nil {
+      t.Fatalf("Patch: %v", err)
+   }
+}
+
+func TestFailedPatchIsAtomic(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   patch := `[
+      {"op": "replace", "path": "/Company", "value": "Changed"},
+      {"op": "test", "path": "/Name", "value": "Somebody else"}
+   ]`
+   if err := d.Patch("users", "John", []byte(patch)); err == nil {
+      t.Fatal("Patch with a failing test succeeded")
+   }
+   
+   var got user
+   d.Read("users", "John", &got)
+   if got != sampleUsers[2] {
+      t.Fatalf("failed patch modified the record: %+v", got)
+   }
+}
+
+func TestPatchErrors(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   cases := map[string]string{
+      "missing path": `[{"op": "remove", "path": "/Nope"}]`,
+      "bad pointer": `[{"op": "add", "path": "Name", "value": 1}]`,
+      "unknown op": `[{"op": "frobnicate", "path": "/Name"}]`,
+      "missing value": `[{"op": "add", "path": "/Name"}]`,
+      "not a patch": `"hello"`,
+   }
+   for name, patch := range cases {
+      if err := d.Patch("users", "John", []byte(patch)); err == nil {
+         t.Errorf("%s: Patch succeeded", name)
+      }
+   }
+   
+   if err := d.Patch("users", "Nobody", []byte(`{"Name": "x"}`)); err == nil {
+      t.Error("patching a missing record succeeded")
+   }
+}
@@ -0,0 +1,563 @@
+package db
+
+import (
+   "bufio"
+   "context"
+   "crypto/hmac"
+   "crypto/rand"
+   "crypto/sha256"
+   "encoding/hex"
+   "encoding/json"
+   "errors"
+   "fmt"
+   "net"
+   "sync"
+   "time"
+)
+
+var ErrReadOnly = errors.New("Driver is a read-only follower! Promote it before writing.")
+
+type ChangeOp string
+
+const (
+   ChangeWrite  ChangeOp = "write"
+   ChangeDelete ChangeOp = "delete"
+)
+
+// Change is one entry of the replication log. Seq numbers are assigned by
+// the primary in commit order and never reused. Data holds the record
+// exactly as written to disk; a delete with an empty Resource drops the
+// whole collection. A Snapshot change copies a record to a new follower; it
+// takes no Seq of its own but carries the one its collection was at.
+type Change struct {
+   Seq        uint64          `json:"seq"`
+   Op         ChangeOp        `json:"op"`
+   Collection string          `json:"collection"`
+   Resource   string          `json:"resource,omitempty"`
+   Data       json.RawMessage `json:"data,omitempty"`
+   Time       time.Time       `json:"time"`
+   Snapshot   bool            `json:"snapshot,omitempty"`
+}
+
+// Replica is the receiving end of replication. A follower *Driver is one,
+// and DialReplica returns one that forwards to a follower over TCP.
+type Replica interface {
+   Apply(Change) error
+}
+
+// FollowerStatus is what the primary knows about one of its followers.
+// Lag is the number of committed changes the follower has not acked yet,
+// plus the snapshot records it still has to get.
+type FollowerStatus struct {
+   Name        string
+   Acked       uint64
+   Lag         uint64
+   LastContact time.Time
+   LastError   error
+}
+
+type replication struct {
+   mutex     sync.Mutex
+   cond      *sync.Cond
+   follower  bool
+   seq       uint64
+   appliedAt time.Time
+   backlog   []Change
+   followers map[string]*follower
+}
+
+type follower struct {
+   name        string
+   replica     Replica
+   acked       uint64
+   lastContact time.Time
+   lastErr     error
+   stopped     bool
+   // snapshot holds the records copied for this follower alone when it
+   // was added, in the order they were taken.
+   snapshot    []Change
+}
+
+const (
+   minRetryDelay = 500 * time.Millisecond
+   maxRetryDelay = 30 * time.Second
+)
+
+// NewFollower opens dir as a read-only replica. Write and Delete fail with
+// ErrReadOnly until Promote is called; changes arrive through Apply, either
+// directly from a primary in the same process or via ServeReplication.
+func NewFollower(dir string, options *Options) (*Driver, error) {
+   d, err := New(dir, options)
+   if err != nil {
+      return nil, err
+   }
+   
+   d.repl.follower = true
+   return d, nil
+}
+
+func (d *Driver) isFollower() bool {
+   d.repl.mutex.Lock()
+   defer d.repl.mutex.Unlock()
+   return d.repl.follower
+}
+
+// Promote turns a follower into a primary, e.g. after the old primary
+// failed. It keeps numbering changes after the last one it applied, so its
+// own followers can pick up where the old primary stopped.
+func (d *Driver) Promote() error {
+   if err := d.authorizeAdmin(); err != nil {
+      return err
+   }
+   
+   d.repl.mutex.Lock()
+   defer d.repl.mutex.Unlock()
+   
+   d.repl.follower = false
+   d.log.Info("Promoted '%s' to primary at change %d\n", d.dir, d.repl.seq)
+   return nil
+}
+
+// Applied returns the last change a follower applied and when the primary
+// committed it. Comparing it with the primary's Followers gives the lag.
+func (d *Driver) Applied() (uint64, time.Time) {
+   d.repl.mutex.Lock()
+   defer d.repl.mutex.Unlock()
+   return d.repl.seq, d.repl.appliedAt
+}
+
+// Apply makes a follower replay a change from its primary. Changes at or
+// below the last applied sequence are ignored, so redelivery after a
+// reconnect is harmless. Snapshot changes are always applied.
+func (d *Driver) Apply(c Change) error {
+   if c.Collection == "" {
+      return fmt.Errorf("Change %d has no collection!", c.Seq)
+   }
+   if err := validCollection(c.Collection, true); err != nil {
+      return err
+   }
+   if err := validResource(c.Resource); err != nil {
+      return err
+   }
+   
+   if err := d.authorizeAdmin(); err != nil {
+      return err
+   }
+   
+   mutex := d.GetOrCreateMutex(c.Collection)
+   mutex.Lock()
+   defer mutex.Unlock()
+   
+   d.repl.mutex.Lock()
+   follower, applied := d.repl.follower, d.repl.seq
+   d.repl.mutex.Unlock()
+   
+   if !follower {
+      return fmt.Errorf("'%s' is a primary and refuses replicated changes!", d.dir)
+   }
+   if c.Seq <= applied && !c.Snapshot {
+      return nil
+   }
+   
+   var err error
+   switch c.Op {
+      case ChangeWrite:
+         err = d.writeRecord(c.Collection, c.Resource, c.Data)
+      case ChangeDelete:
+         // A record deleted before its collection was snapshotted never
+         // reached us; it's gone either way.
+         if err = d.removeRecord(c.Collection, c.Resource); errors.Is(err, ErrNotFound) {
+            err = nil
+         }
+      default:
+         err = fmt.Errorf("Unknown change operation '%s'", c.Op)
+   }
+   if err != nil {
+      return err
+   }
+   
+   d.repl.mutex.Lock()
+   if c.Seq > d.repl.seq {
+      d.repl.seq = c.Seq
+      d.repl.appliedAt = c.Time
+   }
+   d.repl.mutex.Unlock()
+   
+   return nil
+}
+
+// AddFollower starts shipping changes to replica in commit order. The
+// current contents of the store are sent to it first, so an empty follower
+// directory catches up on its own; other followers don't get them again.
+// Delivery is asynchronous and retried with backoff; use Followers to watch
+// the lag.
+func (d *Driver) AddFollower(name string, replica Replica) error {
+   if err := d.authorizeAdmin(); err != nil {
+      return err
+   }
+   
+   d.repl.mutex.Lock()
+   if d.repl.follower {
+      d.repl.mutex.Unlock()
+      return ErrReadOnly
+   }
+   if d.repl.followers == nil {
+      d.repl.followers = make(map[string]*follower)
+      d.repl.cond = sync.NewCond(&d.repl.mutex)
+   }
+   if _, ok := d.repl.followers[name]; ok {
+      d.repl.mutex.Unlock()
+      return fmt.Errorf("Follower '%s' already exists!", name)
+   }
+   
+   f := &follower{name: name, replica: replica, acked: d.repl.seq}
+   d.repl.followers[name] = f
+   d.repl.mutex.Unlock()
+   
+   go d.runFollower(f)
+   
+   return d.snapshot(context.Background(), f)
+}
+
+// RemoveFollower stops shipping changes to the named follower.
+func (d *Driver) RemoveFollower(name string) error {
+   if err := d.authorizeAdmin(); err != nil {
+      return err
+   }
+   
+   d.repl.mutex.Lock()
+   defer d.repl.mutex.Unlock()
+   
+   if f, ok := d.repl.followers[name]; ok {
+      f.stopped = true
+      delete(d.repl.followers, name)
+      d.trimBacklog()
+      d.repl.cond.Broadcast()
+   }
+   return nil
+}
+
+// Followers reports the replication state of every follower.
+func (d *Driver) Followers() []FollowerStatus {
+   d.repl.mutex.Lock()
+   defer d.repl.mutex.Unlock()
+   
+   var statuses []FollowerStatus
+   for _, f := range d.repl.followers {
+      statuses = append(statuses, FollowerStatus{
+         Name: f.name,
+         Acked: f.acked,
+         Lag: d.repl.seq - f.acked + uint64(len(f.snapshot)),
+         LastContact: f.lastContact,
+         LastError: f.lastErr,
+      })
+   }
+   return statuses
+}
+
+// ship assigns the next sequence number to a committed change and queues it
+// for every follower. It is called with the collection lock held, which
+// keeps changes to the same record in order.
+func (d *Driver) ship(c Change) {
+   d.repl.mutex.Lock()
+   defer d.repl.mutex.Unlock()
+   
+   d.repl.seq++
+   c.Seq = d.repl.seq
+   c.Time = time.Now()
+   
+   if len(d.repl.followers) == 0 {
+      return
+   }
+   d.repl.backlog = append(d.repl.backlog, c)
+   d.repl.cond.Broadcast()
+}
+
+// snapshot queues a write of every existing record for f alone, each
+// collection under its own lock so the copy of a collection is consistent.
+// A record is tagged with the Seq of the last change its collection could
+// have seen, and runFollower sends it once f has acked that far, so later
+// changes to the collection always arrive after it.
+func (d *Driver) snapshot(ctx context.Context, f *follower) error {
+   collections, err := d.collections()
+   if err != nil {
+      return err
+   }
+   
+   for _, collection := range collections {
+      mutex := d.GetOrCreateMutex(collection)
+      if err := mutex.LockContext(ctx); err != nil {
+         return err
+      }
+      err := d.each(ctx, collection, func(resource string, b []byte) error {
+         d.repl.mutex.Lock()
+         f.snapshot = append(f.snapshot, Change{Seq: d.repl.seq, Op: ChangeWrite, Collection: collection, Resource: resource, Data: b, Time: time.Now(), Snapshot: true})
+         d.repl.cond.Broadcast()
+         d.repl.mutex.Unlock()
+         return nil
+      })
+      mutex.Unlock()
+      if err != nil {
+         return err
+      }
+   }
+   
+   return nil
+}
+
+func (d *Driver) runFollower(f *follower) {
+   delay := minRetryDelay
+   for {
+      d.repl.mutex.Lock()
+      c, ok := d.nextChangeFor(f)
+      for !f.stopped && !ok {
+         d.repl.cond.Wait()
+         c, ok = d.nextChangeFor(f)
+      }
+      if f.stopped {
+         d.repl.mutex.Unlock()
+         return
+      }
+      d.repl.mutex.Unlock()
+      
+      err := f.replica.Apply(c)
+      
+      d.repl.mutex.Lock()
+      f.lastContact = time.Now()
+      f.lastErr = err
+      if err == nil && c.Snapshot {
+         f.snapshot = f.snapshot[1:]
+      } else if err == nil {
+         f.acked = c.Seq
+         d.trimBacklog()
+      }
+      d.repl.mutex.Unlock()
+      
+      if err == nil {
+         delay = minRetryDelay
+         continue
+      }
+      
+      d.log.Warn("Replication to '%s' failed at change %d: %v\n", f.name, c.Seq, err)
+      time.Sleep(delay)
+      if delay *= 2; delay > maxRetryDelay {
+         delay = maxRetryDelay
+      }
+   }
+}
+
+// nextChangeFor picks what to send f next: a snapshot record once f has
+// every change up to it, else the next change of the log. The caller must
+// hold the replication mutex.
+func (d *Driver) nextChangeFor(f *follower) (Change, bool) {
+   if len(f.snapshot) > 0 && f.snapshot[0].Seq <= f.acked {
+      return f.snapshot[0], true
+   }
+   if d.hasChangeFor(f) {
+      return d.repl.backlog[f.acked + 1 - d.repl.backlog[0].Seq], true
+   }
+   return Change{}, false
+}
+
+func (d *Driver) hasChangeFor(f *follower) bool {
+   backlog := d.repl.backlog
+   return len(backlog) > 0 && backlog[len(backlog) - 1].Seq > f.acked
+}
+
+// trimBacklog drops changes every follower has acked. The caller must hold
+// the replication mutex.
+func (d *Driver) trimBacklog() {
+   if len(d.repl.backlog) == 0 {
+      return
+   }
+   
+   min := d.repl.seq
+   for _, f := range d.repl.followers {
+      if f.acked < min {
+         min = f.acked
+      }
+   }
+   
+   drop := 0
+   for drop < len(d.repl.backlog) && d.repl.backlog[drop].Seq <= min {
+      drop++
+   }
+   d.repl.backlog = append([]Change(nil), d.repl.backlog[drop:]...)
+}
+
+type replicationAck struct {
+   Seq   uint64 `json:"seq"`
+   Error string `json:"error,omitempty"`
+}
+
+// replicationHello opens every replication connection: the follower sends
+// a Nonce, the primary answers with its MAC under the shared secret.
+type replicationHello struct {
+   Nonce string `json:"nonce,omitempty"`
+   MAC   string `json:"mac,omitempty"`
+}
+
+func replicationMAC(secret, nonce string) string {
+   mac := hmac.New(sha256.New, []byte(secret))
+   mac.Write([]byte(nonce))
+   return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeReplication accepts connections from primaries on l and applies the
+// changes they send, acknowledging each one. It blocks until l is closed.
+// Only primaries that know Options.ReplicationSecret get to send changes.
+// The secret never crosses the wire, but the changes do, unencrypted: l
+// must only listen on a trusted network, or be a TLS listener.
+func (d *Driver) ServeReplication(l net.Listener) error {
+   if err := d.authorizeAdmin(); err != nil {
+      return err
+   }
+   if d.secret == "" {
+      return errors.New("No replication secret! Set Options.ReplicationSecret to serve replication.")
+   }
+   
+   for {
+      conn, err := l.Accept()
+      if err != nil {
+         return err
+      }
+      
+      go d.serveReplicationConn(conn)
+   }
+}
+
+func (d *Driver) serveReplicationConn(conn net.Conn) {
+   defer conn.Close()
+   
+   dec := json.NewDecoder(bufio.NewReader(conn))
+   enc := json.NewEncoder(conn)
+   if !d.authenticatePrimary(conn, dec, enc) {
+      d.log.Warn("Refused replication from %s: it doesn't know the secret\n", conn.RemoteAddr())
+      return
+   }
+   
+   for {
+      var c Change
+      if err := dec.Decode(&c); err != nil {
+         return
+      }
+      
+      ack := replicationAck{Seq: c.Seq}
+      if err := d.Apply(c); err != nil {
+         ack.Error = err.Error()
+      }
+      if err := enc.Encode(ack); err != nil {
+         return
+      }
+   }
+}
+
+// authenticatePrimary challenges the primary on the other end of conn to
+// prove it knows the replication secret.
+func (d *Driver) authenticatePrimary(conn net.Conn, dec *json.Decoder, enc *json.Encoder) bool {
+   nonce := make([]byte, 32)
+   if _, err := rand.Read(nonce); err != nil {
+      return false
+   }
+   hello := replicationHello{Nonce: hex.EncodeToString(nonce)}
+   
+   conn.SetDeadline(time.Now().Add(10 * time.Second))
+   defer conn.SetDeadline(time.Time{})
+   if err := enc.Encode(hello); err != nil {
+      return false
+   }
+   var answer replicationHello
+   if err := dec.Decode(&answer); err != nil {
+      return false
+   }
+   return hmac.Equal([]byte(answer.MAC), []byte(replicationMAC(d.secret, hello.Nonce)))
+}
+
+// RemoteReplica ships changes to a follower running ServeReplication on
+// another node. The connection is opened lazily and re-dialled after any
+// failure.
+type RemoteReplica struct {
+   mutex   sync.Mutex
+   addr    string
+   secret  string
+   timeout time.Duration
+   conn    net.Conn
+   dec     *json.Decoder
+}
+
+// DialReplica returns a Replica for the follower at addr, which has to
+// share secret with us.
+func DialReplica(addr, secret string) *RemoteReplica {
+   return &RemoteReplica{addr: addr, secret: secret, timeout: 10 * time.Second}
+}
+
+func (r *RemoteReplica) Apply(c Change) error {
+   r.mutex.Lock()
+   defer r.mutex.Unlock()
+   
+   if r.conn == nil {
+      conn, err := net.DialTimeout("tcp", r.addr, r.timeout)
+      if err != nil {
+         return err
+      }
+      r.conn = conn
+      r.dec = json.NewDecoder(bufio.NewReader(conn))
+      if err := r.answerHello(); err != nil {
+         r.conn.Close()
+         r.conn = nil
+         return err
+      }
+   }
+   
+   err := r.roundTrip(c)
+   if err != nil {
+      r.conn.Close()
+      r.conn = nil
+   }
+   return err
+}
+
+func (r *RemoteReplica) roundTrip(c Change) error {
+   r.conn.SetDeadline(time.Now().Add(r.timeout))
+   if err := json.NewEncoder(r.conn).Encode(c); err != nil {
+      return err
+   }
+   
+   var ack replicationAck
+   if err := r.dec.Decode(&ack); err != nil {
+      return err
+   }
+   if ack.Error != "" {
+      return fmt.Errorf("Follower %s: %s", r.addr, ack.Error)
+   }
+   if ack.Seq != c.Seq {
+      return fmt.Errorf("Follower %s acked change %d, expected %d", r.addr, ack.Seq, c.Seq)
+   }
+   return nil
+}
+
+// answerHello proves to the follower that we know the secret.
+func (r *RemoteReplica) answerHello() error {
+   r.conn.SetDeadline(time.Now().Add(r.timeout))
+   var hello replicationHello
+   if err := r.dec.Decode(&hello); err != nil {
+      return err
+   }
+   return json.NewEncoder(r.conn).Encode(replicationHello{MAC: replicationMAC(r.secret, hello.Nonce)})
+}
+
+// Close drops the connection to the follower, if any.
+func (r *RemoteReplica) Close() error {
+   r.mutex.Lock()
+   defer r.mutex.Unlock()
+   
+   if r.conn == nil {
+      return nil
+   }
+   err := r.conn.Close()
+   r.conn = nil
+   return err
+}
+
+var _ Replica = (*Driver)(nil)
+var _ Replica = (*RemoteReplica)(nil)
@@ -0,0 +1,243 @@
+package db_test
+
+import (
+   "net"
+   "sync"
+   "testing"
+   "time"
+   "github.com/ayush/golang-database/db"
+)
+
+const testSecret = "replication secret"
+
+func newTestFollower(t *testing.T) *db.Driver {
+   t.Helper()
+   
+   options := quietOptions()
+   options.ReplicationSecret = testSecret
+   f, err := db.NewFollower(t.TempDir(), options)
+   if err != nil {
+      t.Fatalf("NewFollower: %v", err)
+   }
+   return f
+}
+
+// serveReplication has follower listen for primaries on a local port.
+func serveReplication(t *testing.T, follower *db.Driver) string {
+   t.Helper()
+   
+   l, err := net.Listen("tcp", "127.0.0.1:0")
+   if err != nil {
+      t.Fatal(err)
+   }
+   t.Cleanup(func() { l.Close() })
+   go follower.ServeReplication(l)
+   return l.Addr().String()
+}
+
+// waitForFollowers polls until every follower has acked all changes.
+func waitForFollowers(t *testing.T, d *db.Driver) {
+   t.Helper()
+   
+   deadline := time.Now().Add(5 * time.Second)
+   for time.Now().Before(deadline) {
+      caughtUp := true
+      for _, status := range d.Followers() {
+         if status.Lag != 0 {
+            caughtUp = false
+         }
+      }
+      if caughtUp {
+         return
+      }
+      time.Sleep(10 * time.Millisecond)
+   }
+   t.Fatalf("followers did not catch up: %+v", d.Followers())
+}
+
+func TestReplicationToLocalFollower(t *testing.T) {
+   primary, _ := newTestDriver(t)
+   seedUsers(t, primary)
+   
+   follower := newTestFollower(t)
+   if err := primary.AddFollower("local", follower); err != nil {
+      t.Fatalf("AddFollower: %v", err)
+   }
+   
+   if err := primary.Delete("users", "John"); err != nil {
+      t.Fatalf("Delete: %v", err)
+   }
+   waitForFollowers(t, primary)
+   
+   count, err := follower.Count("users")
+   if err != nil {
+      t.Fatalf("Count: %v", err)
+   }
+   if count != len(sampleUsers) - 1 {
+      t.Fatalf("follower has %d users, want %d", count, len(sampleUsers) - 1)
+   }
+   
+   if err := follower.Write("users", "John", sampleUsers[2]); err != db.ErrReadOnly {
+      t.Fatalf("Write on follower = %v, want ErrReadOnly", err)
+   }
+}
+
+func TestReplicationOverTCP(t *testing.T) {
+   primary, _ := newTestDriver(t)
+   follower := newTestFollower(t)
+   
+   replica := db.DialReplica(serveReplication(t, follower), testSecret)
+   defer replica.Close()
+   if err := primary.AddFollower("remote", replica); err != nil {
+      t.Fatalf("AddFollower: %v", err)
+   }
+   seedUsers(t, primary)
+   waitForFollowers(t, primary)
+   
+   var u user
+   if err := follower.Read("users", "Ayush", &u); err != nil {
+      t.Fatalf("Read on follower: %v", err)
+   }
+   if u != sampleUsers[0] {
+      t.Fatalf("follower has %+v, want %+v", u, sampleUsers[0])
+   }
+   
+   applied, _ := follower.Applied()
+   if applied != uint64(len(sampleUsers)) {
+      t.Fatalf("follower applied %d changes, want %d", applied, len(sampleUsers))
+   }
+}
+
+func TestReplicationNeedsTheSecret(t *testing.T) {
+   follower := newTestFollower(t)
+   
+   replica := db.DialReplica(serveReplication(t, follower), "guessed")
+   defer replica.Close()
+   err := replica.Apply(db.Change{Seq: 1, Op: db.ChangeWrite, Collection: "users", Resource: "Ayush", Data: []byte("{}")})
+   if err == nil {
+      t.Fatal("a primary without the secret got a change applied")
+   }
+   if applied, _ := follower.Applied(); applied != 0 {
+      t.Fatalf("follower applied %d changes from an unknown primary", applied)
+   }
+   
+   unguarded, err := db.NewFollower(t.TempDir(), quietOptions())
+   if err != nil {
+      t.Fatalf("NewFollower: %v", err)
+   }
+   l, err := net.Listen("tcp", "127.0.0.1:0")
+   if err != nil {
+      t.Fatal(err)
+   }
+   defer l.Close()
+   if err := unguarded.ServeReplication(l); err == nil {
+      t.Fatal("ServeReplication ran without a secret")
+   }
+}
+
+func TestApplyRefusesNamesOutsideTheStore(t *testing.T) {
+   follower := newTestFollower(t)
+   
+   for _, c := range []db.Change{
+      {Seq: 1, Op: db.ChangeWrite, Collection: "../outside", Resource: "x", Data: []byte("{}")},
+      {Seq: 1, Op: db.ChangeWrite, Collection: "users", Resource: "../../outside", Data: []byte("{}")},
+      {Seq: 1, Op: db.ChangeDelete, Collection: ".."},
+      {Seq: 1, Op: db.ChangeDelete, Collection: "a/b/c"},
+   } {
+      if err := follower.Apply(c); err == nil {
+         t.Errorf("Apply(%+v) was accepted", c)
+      }
+   }
+}
+
+func TestPromote(t *testing.T) {
+   primary, _ := newTestDriver(t)
+   follower := newTestFollower(t)
+   if err := primary.AddFollower("local", follower); err != nil {
+      t.Fatalf("AddFollower: %v", err)
+   }
+   seedUsers(t, primary)
+   waitForFollowers(t, primary)
+   if err := primary.RemoveFollower("local"); err != nil {
+      t.Fatalf("RemoveFollower: %v", err)
+   }
+   
+   if err := follower.Promote(); err != nil {
+      t.Fatalf("Promote: %v", err)
+   }
+   if err := follower.Write("users", "Nina", sampleUsers[0]); err != nil {
+      t.Fatalf("Write after Promote: %v", err)
+   }
+   if err := follower.Apply(db.Change{Seq: 100, Op: db.ChangeDelete, Collection: "users"}); err == nil {
+      t.Fatal("promoted follower accepted a replicated change")
+   }
+   
+   applied, _ := follower.Applied()
+   if applied != uint64(len(sampleUsers)) + 1 {
+      t.Fatalf("promoted follower is at change %d, want %d", applied, len(sampleUsers) + 1)
+   }
+}
+
+func TestFollowerDeletesOfMissingRecordsSucceed(t *testing.T) {
+   follower := newTestFollower(t)
+   
+   changes := []db.Change{
+      {Seq: 1, Op: db.ChangeDelete, Collection: "users", Resource: "Ghost"},
+      {Seq: 2, Op: db.ChangeDelete, Collection: "orders"},
+      {Seq: 3, Op: db.ChangeWrite, Collection: "users", Resource: "Ayush", Data: []byte(`{"Name": "Ayush"}`)},
+   }
+   for _, c := range changes {
+      if err := follower.Apply(c); err != nil {
+         t.Fatalf("Apply(%+v): %v", c, err)
+      }
+   }
+   if applied, _ := follower.Applied(); applied != 3 {
+      t.Fatalf("follower is at change %d, want 3", applied)
+   }
+}
+
+// countingReplica counts the changes it passes on to a follower.
+type countingReplica struct {
+   mutex    sync.Mutex
+   follower *db.Driver
+   applied  int
+}
+
+func (r *countingReplica) Apply(c db.Change) error {
+   r.mutex.Lock()
+   r.applied++
+   r.mutex.Unlock()
+   return r.follower.Apply(c)
+}
+
+func TestAddFollowerSnapshotsOnlyTheNewOne(t *testing.T) {
+   primary, _ := newTestDriver(t)
+   seedUsers(t, primary)
+   
+   first := &countingReplica{follower: newTestFollower(t)}
+   if err := primary.AddFollower("first", first); err != nil {
+      t.Fatalf("AddFollower: %v", err)
+   }
+   waitForFollowers(t, primary)
+   
+   second := &countingReplica{follower: newTestFollower(t)}
+   if err := primary.AddFollower("second", second); err != nil {
+      t.Fatalf("AddFollower: %v", err)
+   }
+   if err := primary.Delete("users", "John"); err != nil {
+      t.Fatalf("Delete: %v", err)
+   }
+   waitForFollowers(t, primary)
+   
+   if first.applied != len(sampleUsers) + 1 || second.applied != len(sampleUsers) + 1 {
+      t.Fatalf("followers got %d and %d changes, want %d each", first.applied, second.applied, len(sampleUsers) + 1)
+   }
+   for _, status := range primary.Followers() {
+      if status.Acked != uint64(len(sampleUsers)) + 1 {
+         t.Fatalf("%s acked change %d; snapshots must not take sequence numbers", status.Name, status.Acked)
+      }
+   }
+   if count, err := second.follower.Count("users"); err != nil || count != len(sampleUsers) - 1 {
+      t.Fatalf("second follower has %d users, %v; want %d", count, err, len(sampleUsers) - 1)
+   }
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+   "context"
+   "crypto/sha1"
+   "encoding/hex"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "strings"
+)
+
+// shardMarker marks a collection that uses the sharded layout,
+// dir/collection/ab/resource.json, where ab are the first two hex digits of
+// the SHA-1 of the resource name. Each directory then only holds about a
+// 256th of the collection.
+const shardMarker = ".sharded"
+
+// shardBatch is how many records Shard moves per lock acquisition, so
+// writers are only held up briefly while a collection is migrated.
+const shardBatch = 500
+
+func shardName(resource string) string {
+   sum := sha1.Sum([]byte(resource))
+   return hex.EncodeToString(sum[:1])
+}
+
+func isShardDir(name string) bool {
+   if len(name) != 2 {
+      return false
+   }
+   _, err := hex.DecodeString(name)
+   return err == nil && strings.ToLower(name) == name
+}
+
+// isSharded reports whether collection uses the sharded layout. The answer
+// is cached; it only changes through Shard or when the collection is
+// dropped.
+func (d *Driver) isSharded(collection string) bool {
+   d.mutex.Lock()
+   sharded, ok := d.sharded[collection]
+   d.mutex.Unlock()
+   if ok {
+      return sharded
+   }
+   
+   _, err := os.Stat(filepath.Join(d.collectionDir(collection), shardMarker))
+   sharded = err == nil
+   
+   d.mutex.Lock()
+   d.sharded[collection] = sharded
+   d.mutex.Unlock()
+   return sharded
+}
+
+func (d *Driver) forgetLayout(collection string) {
+   d.mutex.Lock()
+   delete(d.sharded, collection)
+   d.mutex.Unlock()
+}
+
+func (d *Driver) flatPath(collection, resource string) string {
+   return filepath.Join(d.collectionDir(collection), resource + ".json")
+}
+
+// recordPath is where a record is written under the collection's layout.
+func (d *Driver) recordPath(collection, resource string) string {
+   if d.isSharded(collection) {
+      return filepath.Join(d.collectionDir(collection), shardName(resource), resource + ".json")
+   }
+   return d.flatPath(collection, resource)
+}
+
+// locateRecord is where a record can be read from. While Shard is still
+// migrating a collection a record may not have moved yet, so the flat
+// location is tried as well.
+func (d *Driver) locateRecord(collection, resource string) string {
+   path := d.recordPath(collection, resource)
+   if !d.isSharded(collection) {
+      return path
+   }
+   
+   if _, err := os.Stat(path); os.IsNotExist(err) {
+      if _, err := os.Stat(d.flatPath(collection, resource)); err == nil {
+         return d.flatPath(collection, resource)
+      }
+   }
+   return path
+}
+
+// prepareCollection creates the directory of a new collection, sharded if
+// Options.Sharded is set.
+func (d *Driver) prepareCollection(collection string) error {
+   dir := d.collectionDir(collection)
+   if _, err := os.Stat(dir); err == nil {
+      return nil
+   }
+   
+   if err := os.MkdirAll(dir, 0755); err != nil {
+      return err
+   }
+   d.forgetLayout(collection)
+   
+   if !d.shardNew {
+      return nil
+   }
+   return ioutil.WriteFile(filepath.Join(dir, shardMarker), nil, 0644)
+}
+
+// collectionFiles lists every file of a collection relative to its
+// directory, descending into shard directories. Hidden files and
+// directories belong to the driver and are left out.
+func (d *Driver) collectionFiles(collection string) ([]string, error) {
+   dir := d.collectionDir(collection)
+   entries, err := ioutil.ReadDir(dir)
+   if err != nil {
+      return nil, err
+   }
+   
+   var names []string
+   for _, entry := range entries {
+      name := entry.Name()
+      switch {
+         case strings.HasPrefix(name, "."):
+            continue
+         case entry.IsDir() && isShardDir(name):
+            files, err := ioutil.ReadDir(filepath.Join(dir, name))
+            if err != nil {
+               return nil, err
+            }
+            for _, file := range files {
+               if !file.IsDir() && !strings.HasPrefix(file.Name(), ".") {
+                  names = append(names, filepath.Join(name, file.Name()))
+               }
+            }
+         case !entry.IsDir():
+            names = append(names, name)
+      }
+   }
+   return names, nil
+}
+
+// Shard migrates a flat collection to the sharded layout while it stays
+// online. New writes go to the sharded layout as soon as Shard starts, and
+// reads fall back to the flat location for records that haven't moved yet.
+// Records are moved in small batches under the collection lock.
+func (d *Driver) Shard(collection string) error {
+   return d.ShardContext(context.Background(), collection)
+}
+
+func (d *Driver) ShardContext(ctx context.Context, collection string) error {
+   collection, err := d.qualify(collection)
+   if err != nil {
+      return err
+   }
+   if err := d.authorize(RightAll, collection, ""); err != nil {
+      return err
+   }
+   
+   if d.isFollower() {
+      return ErrReadOnly
+   }
+   
+   mutex := d.GetOrCreateMutex(collection)
+   if err := mutex.LockContext(ctx); err != nil {
+      return err
+   }
+   dir := d.collectionDir(collection)
+   if _, err := os.Stat(dir); err != nil {
+      mutex.Unlock()
+      return err
+   }
+   err = ioutil.WriteFile(filepath.Join(dir, shardMarker), nil, 0644)
+   d.forgetLayout(collection)
+   mutex.Unlock()
+   if err != nil {
+      return err
+   }
+   
+   for {
+      moved, err := d.shardBatch(ctx, collection)
+      if err != nil || moved == 0 {
+         return err
+      }
+      d.logAt(LevelDebug, collection, "Moved records to the sharded layout", Field{Key: "records", Value: moved})
+   }
+}
+
+func (d *Driver) shardBatch(ctx context.Context, collection string) (int, error) {
+   mutex := d.GetOrCreateMutex(collection)
+   if err := mutex.LockContext(ctx); err != nil {
+      return 0, err
+   }
+   defer mutex.Unlock()
+   
+   dir := d.collectionDir(collection)
+   entries, err := ioutil.ReadDir(dir)
+   if err != nil {
+      return 0, err
+   }
+   
+   moved := 0
+   for _, entry := range entries {
+      name := entry.Name()
+      if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+         continue
+      }
+      if moved == shardBatch {
+         break
+      }
+      
+      resource := strings.TrimSuffix(name, ".json")
+      source, target := filepath.Join(dir, name), d.recordPath(collection, resource)
+      if err := moveToShard(source, target); err != nil {
+         return moved, err
+      }
+      moved++
+   }
+   
+   return moved, nil
+}
+
+func moveToShard(source, target string) error {
+   if _, err := os.Stat(target); err == nil {
+      // Rewritten since the migration started; the flat copy is stale.
+      return os.Remove(source)
+   }
+   
+   if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+      return err
+   }
+   return os.Rename(source, target)
+}
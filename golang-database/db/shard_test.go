@@ -0,0 +1,148 @@
+package db_test
+
+import (
+   "fmt"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "sync"
+   "testing"
+   "github.com/ayush/golang-database/db"
+)
+
+func TestShardedLayout(t *testing.T) {
+   options := quietOptions()
+   options.Sharded = true
+   dir := t.TempDir()
+   d, err := db.New(dir, options)
+   if err != nil {
+      t.Fatalf("New: %v", err)
+   }
+   seedUsers(t, d)
+   
+   if _, err := os.Stat(filepath.Join(dir, "users", "John.json")); !os.IsNotExist(err) {
+      t.Fatal("record was written to the flat layout")
+   }
+   matches, _ := filepath.Glob(filepath.Join(dir, "users", "*", "John.json"))
+   if len(matches) != 1 {
+      t.Fatalf("expected John.json in exactly one shard, found %v", matches)
+   }
+   
+   var got user
+   if err := d.Read("users", "John", &got); err != nil || got != sampleUsers[2] {
+      t.Fatalf("Read = %+v, %v", got, err)
+   }
+   if count, _ := d.Count("users"); count != len(sampleUsers) {
+      t.Fatalf("Count = %d, want %d", count, len(sampleUsers))
+   }
+   if report, _ := d.Verify(); !report.OK() {
+      t.Fatalf("Verify found problems in a sharded collection: %v", report.Problems)
+   }
+   
+   if err := d.Delete("users", "John"); err != nil {
+      t.Fatalf("Delete: %v", err)
+   }
+   if err := d.Read("users", "John", &got); err == nil {
+      t.Fatal("deleted record is still readable")
+   }
+}
+
+func TestShardMigratesFlatCollection(t *testing.T) {
+   d, dir := newTestDriver(t)
+   for i := 0; i < 50; i++ {
+      d.Write("users", fmt.Sprintf("user%02d", i), sampleUsers[i % len(sampleUsers)])
+   }
+   
+   var wg sync.WaitGroup
+   wg.Add(1)
+   go func() {
+      defer wg.Done()
+      for i := 0; i < 50; i++ {
+         if err := d.Write("users", fmt.Sprintf("user%02d", i), sampleUsers[0]); err != nil {
+            t.Errorf("Write during migration: %v", err)
+         }
+      }
+   }()
+   if err := d.Shard("users"); err != nil {
+      t.Fatalf("Shard: %v", err)
+   }
+   wg.Wait()
+   
+   flat, _ := filepath.Glob(filepath.Join(dir, "users", "*.json"))
+   if len(flat) != 0 {
+      t.Fatalf("flat records left after Shard: %v", flat)
+   }
+   if count, _ := d.Count("users"); count != 50 {
+      t.Fatalf("Count after Shard = %d, want 50", count)
+   }
+   
+   var got user
+   if err := d.Read("users", "user07", &got); err != nil || got != sampleUsers[0] {
+      t.Fatalf("Read after Shard = %+v, %v", got, err)
+   }
+}
+
+func TestVerifyFindsMisplacedShardRecords(t *testing.T) {
+   options := quietOptions()
+   options.Sharded = true
+   dir := t.TempDir()
+   d, _ := db.New(dir, options)
+   seedUsers(t, d)
+   
+   os.MkdirAll(filepath.Join(dir, "users", "zz"), 0755)
+   misplaced := filepath.Join(dir, "users", "00", "Stray.json")
+   os.MkdirAll(filepath.Dir(misplaced), 0755)
+   ioutil.WriteFile(misplaced, []byte("{}"), 0644)
+   
+   report, err := d.Verify()
+   if err != nil {
+      t.Fatalf("Verify: %v", err)
+   }
+   if len(report.Problems) != 1 || report.Problems[0].Kind != db.ProblemMisplaced {
+      t.Fatalf("Verify = %v, want a single misplaced record", report.Problems)
+   }
+}
+
+func TestRepairMovesMisplacedShardRecords(t *testing.T) {
+   options := quietOptions()
+   options.Sharded = true
+   dir := t.TempDir()
+   d, _ := db.New(dir, options)
+   seedUsers(t, d)
+   
+   // Stray belongs in another shard, which is free. The copy of Ayush
+   // clashes with the real one.
+   stray := filepath.Join(dir, "users", "00", "Stray.json")
+   clash := filepath.Join(dir, "users", "00", "Ayush.json")
+   os.MkdirAll(filepath.Dir(stray), 0755)
+   ioutil.WriteFile(stray, []byte(`{"Name": "Stray"}`), 0644)
+   ioutil.WriteFile(clash, []byte(`{"Name": "Impostor"}`), 0644)
+   
+   report, err := d.Repair()
+   if err != nil {
+      t.Fatalf("Repair: %v", err)
+   }
+   for _, p := range report.Problems {
+      switch p.File {
+         case filepath.Join("00", "Stray.json"):
+            if p.Moved == "" || p.Quarantined != "" {
+               t.Errorf("Stray should have been moved: %v", p)
+            }
+         case filepath.Join("00", "Ayush.json"):
+            if p.Quarantined == "" {
+               t.Errorf("the clashing copy should have been quarantined: %v", p)
+            }
+      }
+   }
+   
+   var got user
+   if err := d.Read("users", "Stray", &got); err != nil || got.Name != "Stray" {
+      t.Fatalf("Read of the moved record = %+v, %v", got, err)
+   }
+   if err := d.Read("users", "Ayush", &got); err != nil || got != sampleUsers[0] {
+      t.Fatalf("Read of the real record = %+v, %v", got, err)
+   }
+   if report, err := d.Verify(); err != nil || !report.OK() {
+      t.Fatalf("Verify after Repair = %v, %v", report.Problems, err)
+   }
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+   "context"
+   "fmt"
+   "os"
+)
+
+// UniqueError is returned when a write would give a unique field a value
+// that another record of the collection already holds.
+type UniqueError struct {
+   Collection string
+   Field      string
+   Value      string
+   Resource   string
+}
+
+func (e *UniqueError) Error() string {
+   return fmt.Sprintf("Unique constraint on '%s.%s' violated: '%s' is already used by '%s'", e.Collection, e.Field, e.Value, e.Resource)
+}
+
+// uniqueIndex maps every constrained value back to the record holding it.
+// It is only read or changed with the collection lock held.
+type uniqueIndex struct {
+   fields     []string
+   owners     map[string]map[string]string
+   byResource map[string]map[string]string
+}
+
+func newUniqueIndex(fields []string) *uniqueIndex {
+   index := &uniqueIndex{
+      fields: fields,
+      owners: make(map[string]map[string]string),
+      byResource: make(map[string]map[string]string),
+   }
+   for _, field := range fields {
+      index.owners[field] = make(map[string]string)
+   }
+   return index
+}
+
+// values extracts the constrained values of a record. Records that aren't
+// JSON objects, and fields that are missing or null, aren't constrained.
+func (index *uniqueIndex) values(b []byte) map[string]string {
+   doc, err := decodeDocument(b)
+   if err != nil {
+      return nil
+   }
+   
+   values := map[string]string{}
+   for _, field := range index.fields {
+      if value, ok := lookupField(doc, field); ok && value != nil {
+         values[field] = groupKey(value)
+      }
+   }
+   return values
+}
+
+func (index *uniqueIndex) conflict(collection, resource string, values map[string]string) error {
+   for field, value := range values {
+      if owner, ok := index.owners[field][value]; ok && owner != resource {
+         return &UniqueError{Collection: collection, Field: field, Value: value, Resource: owner}
+      }
+   }
+   return nil
+}
+
+func (index *uniqueIndex) add(resource string, values map[string]string) {
+   index.remove(resource)
+   for field, value := range values {
+      index.owners[field][value] = resource
+   }
+   index.byResource[resource] = values
+}
+
+func (index *uniqueIndex) remove(resource string) {
+   for field, value := range index.byResource[resource] {
+      delete(index.owners[field], value)
+   }
+   delete(index.byResource, resource)
+}
+
+// Unique declares fields of collection, such as "Contact" or
+// "Address.Email", whose values must not repeat across records. Existing
+// records are indexed right away; if they already clash, the constraint is
+// not installed and the *UniqueError is returned. Calling Unique again adds
+// to the fields already declared.
+func (d *Driver) Unique(collection string, fields ...string) error {
+   return d.UniqueContext(context.Background(), collection, fields...)
+}
+
+func (d *Driver) UniqueContext(ctx context.Context, collection string, fields ...string) error {
+   collection, err := d.qualify(collection)
+   if err != nil {
+      return err
+   }
+   if collection == "" {
+      return fmt.Errorf("No collection - unable to add a constraint!")
+   }
+   
+   if err := d.authorize(RightAll, collection, ""); err != nil {
+      return err
+   }
+   
+   mutex := d.GetOrCreateMutex(collection)
+   if err := mutex.LockContext(ctx); err != nil {
+      return err
+   }
+   defer mutex.Unlock()
+   
+   if existing := d.uniqueIndex(collection); existing != nil {
+      fields = append(append([]string(nil), existing.fields...), fields...)
+   }
+   
+   index := newUniqueIndex(fields)
+   err = d.each(ctx, collection, func(resource string, b []byte) error {
+      values := index.values(b)
+      if err := index.conflict(collection, resource, values); err != nil {
+         return err
+      }
+      index.add(resource, values)
+      return nil
+   })
+   if err != nil && !os.IsNotExist(err) {
+      return err
+   }
+   
+   d.mutex.Lock()
+   d.unique[collection] = index
+   d.mutex.Unlock()
+   
+   return nil
+}
+
+func (d *Driver) uniqueIndex(collection string) *uniqueIndex {
+   d.mutex.Lock()
+   defer d.mutex.Unlock()
+   return d.unique[collection]
+}
+
+// checkUnique rejects b if it would break a unique constraint. The caller
+// must hold the collection lock.
+func (d *Driver) checkUnique(collection, resource string, b []byte) error {
+   index := d.uniqueIndex(collection)
+   if index == nil {
+      return nil
+   }
+   return index.conflict(collection, resource, index.values(b))
+}
+
+// indexRecord and unindexRecord keep the unique index in step with the
+// files on disk. The caller must hold the collection lock.
+func (d *Driver) indexRecord(collection, resource string, b []byte) {
+   if index := d.uniqueIndex(collection); index != nil {
+      index.add(resource, index.values(b))
+   }
+}
+
+func (d *Driver) unindexRecord(collection, resource string) {
+   index := d.uniqueIndex(collection)
+   if index == nil {
+      return
+   }
+   
+   if resource == "" {
+      d.mutex.Lock()
+      d.unique[collection] = newUniqueIndex(index.fields)
+      d.mutex.Unlock()
+      return
+   }
+   index.remove(resource)
+}
@@ -0,0 +1,100 @@
+package db_test
+
+import (
+   "fmt"
+   "sync"
+   "testing"
+   "github.com/ayush/golang-database/db"
+)
+
+func TestUniqueRejectsDuplicates(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   if err := d.Unique("users", "Contact"); err != nil {
+      t.Fatalf("Unique: %v", err)
+   }
+   
+   impostor := sampleUsers[0]
+   impostor.Name = "Impostor"
+   err := d.Write("users", impostor.Name, impostor)
+   uniqueErr, ok := err.(*db.UniqueError)
+   if !ok {
+      t.Fatalf("Write = %v, want *UniqueError", err)
+   }
+   if uniqueErr.Field != "Contact" || uniqueErr.Value != impostor.Contact || uniqueErr.Resource != "Ayush" {
+      t.Fatalf("unexpected conflict %+v", uniqueErr)
+   }
+   
+   if err := d.Write("users", "Ayush", sampleUsers[0]); err != nil {
+      t.Fatalf("rewriting the owner of a value failed: %v", err)
+   }
+}
+
+func TestUniqueFollowsUpdatesAndDeletes(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   if err := d.Unique("users", "Contact"); err != nil {
+      t.Fatalf("Unique: %v", err)
+   }
+   
+   if err := d.Patch("users", "John", []byte(`{"Contact": "5550100"}`)); err != nil {
+      t.Fatalf("Patch: %v", err)
+   }
+   reused := sampleUsers[2]
+   reused.Name = "Johnny"
+   if err := d.Write("users", reused.Name, reused); err != nil {
+      t.Fatalf("reusing a released value failed: %v", err)
+   }
+   
+   if err := d.Delete("users", "Ayush"); err != nil {
+      t.Fatalf("Delete: %v", err)
+   }
+   reused = sampleUsers[0]
+   reused.Name = "Nina"
+   if err := d.Write("users", reused.Name, reused); err != nil {
+      t.Fatalf("reusing a deleted record's value failed: %v", err)
+   }
+}
+
+func TestUniqueOnExistingDuplicates(t *testing.T) {
+   d, _ := newTestDriver(t)
+   seedUsers(t, d)
+   
+   if _, ok := d.Unique("users", "Company").(*db.UniqueError); !ok {
+      t.Fatal("Unique accepted a field that already repeats")
+   }
+   twin := sampleUsers[1]
+   twin.Name = "Twin"
+   if err := d.Write("users", twin.Name, twin); err != nil {
+      t.Fatalf("rejected constraint is still enforced: %v", err)
+   }
+}
+
+func TestUniqueUnderConcurrentWrites(t *testing.T) {
+   d, _ := newTestDriver(t)
+   if err := d.Unique("users", "Contact"); err != nil {
+      t.Fatalf("Unique: %v", err)
+   }
+   
+   var wg sync.WaitGroup
+   var mutex sync.Mutex
+   succeeded := 0
+   for i := 0; i < 20; i++ {
+      wg.Add(1)
+      go func(i int) {
+         defer wg.Done()
+         u := user{Name: fmt.Sprintf("user%d", i), Contact: "5550100"}
+         if err := d.Write("users", u.Name, u); err == nil {
+            mutex.Lock()
+            succeeded++
+            mutex.Unlock()
+         }
+      }(i)
+   }
+   wg.Wait()
+   
+   if succeeded != 1 {
+      t.Fatalf("%d writes with the same contact succeeded, want 1", succeeded)
+   }
+}
@@ -0,0 +1,287 @@
+package db
+
+import (
+   "context"
+   "crypto/sha256"
+   "encoding/hex"
+   "encoding/json"
+   "fmt"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "strings"
+   "time"
+)
+
+// quarantineDir is where Repair moves files it can't trust. Being hidden,
+// it is never mistaken for a collection.
+const quarantineDir = ".quarantine"
+
+// checksumDir holds the SHA-256 of every record of a collection, one file
+// per record. It sits outside the shard directories, so Shard moving a
+// record leaves its checksum valid.
+const checksumDir = ".checksums"
+
+type ProblemKind string
+
+const (
+   ProblemUnreadable  ProblemKind = "unreadable"
+   ProblemInvalidJSON ProblemKind = "invalid_json"
+   ProblemOrphanedTmp ProblemKind = "orphaned_tmp"
+   ProblemNotJSON     ProblemKind = "not_json"
+   ProblemMisplaced   ProblemKind = "misplaced"
+   ProblemChecksum    ProblemKind = "checksum_mismatch"
+)
+
+// Problem is a single finding of Verify. Quarantined holds the new location
+// of the file when it was moved away by Repair, Moved where Repair put a
+// misplaced record back.
+type Problem struct {
+   Kind        ProblemKind
+   Collection  string
+   File        string
+   Err         error
+   Quarantined string
+   Moved       string
+}
+
+func (p Problem) String() string {
+   msg := fmt.Sprintf("%s: %s/%s", p.Kind, p.Collection, p.File)
+   if p.Err != nil {
+      msg += fmt.Sprintf(" (%v)", p.Err)
+   }
+   if p.Quarantined != "" {
+      msg += " -> " + p.Quarantined
+   }
+   if p.Moved != "" {
+      msg += " -> " + p.Moved
+   }
+   return msg
+}
+
+type Report struct {
+   Collections int
+   Records     int
+   Problems    []Problem
+}
+
+// OK reports whether the data directory came out clean.
+func (r *Report) OK() bool {
+   return len(r.Problems) == 0
+}
+
+// Verify walks every collection and reports records that can't be read or
+// parsed, records that no longer match their checksum, leftover .tmp files
+// from interrupted writes, stray files without the .json suffix and, in
+// sharded collections, records sitting in the wrong shard directory.
+// Nothing on disk is changed.
+func (d *Driver) Verify() (*Report, error) {
+   return d.VerifyContext(context.Background(), false)
+}
+
+// Repair runs the same checks as Verify and moves every offending file into
+// the .quarantine directory, mirroring its collection. Misplaced records
+// are valid, so they are moved to their shard instead, unless a record of
+// the same name is there already.
+func (d *Driver) Repair() (*Report, error) {
+   return d.VerifyContext(context.Background(), true)
+}
+
+// VerifyContext is Verify, or Repair when repair is set. Each collection is
+// checked while holding its lock, so a .tmp file seen there can't belong to
+// a write that is still in flight.
+func (d *Driver) VerifyContext(ctx context.Context, repair bool) (*Report, error) {
+   collections, err := d.collections()
+   if err != nil {
+      return nil, err
+   }
+   
+   report := &Report{}
+   for _, collection := range collections {
+      right := RightRead
+      if repair {
+         right = RightAll
+      }
+      if err := d.authorize(right, collection, ""); err != nil {
+         return report, err
+      }
+      
+      report.Collections++
+      if err := d.verifyCollection(ctx, collection, repair, report); err != nil {
+         return report, err
+      }
+   }
+   
+   return report, nil
+}
+
+func (d *Driver) verifyCollection(ctx context.Context, collection string, repair bool, report *Report) error {
+   mutex := d.GetOrCreateMutex(collection)
+   if err := mutex.LockContext(ctx); err != nil {
+      return err
+   }
+   defer mutex.Unlock()
+   
+   dir := d.collectionDir(collection)
+   files, err := d.collectionFiles(collection)
+   if err != nil {
+      return err
+   }
+   
+   for _, name := range files {
+      if err := ctx.Err(); err != nil {
+         return err
+      }
+      
+      resource := strings.TrimSuffix(filepath.Base(name), ".json")
+      shard := filepath.Dir(name)
+      
+      problem := Problem{Collection: collection, File: name}
+      var b []byte
+      switch {
+         case strings.HasSuffix(name, ".json.tmp"):
+            problem.Kind = ProblemOrphanedTmp
+         case !strings.HasSuffix(name, ".json"):
+            problem.Kind = ProblemNotJSON
+         default:
+            report.Records++
+            b, err = ioutil.ReadFile(filepath.Join(dir, name))
+            if err != nil {
+               problem.Kind, problem.Err = ProblemUnreadable, err
+               break
+            }
+            var v interface{}
+            if err := json.Unmarshal(b, &v); err != nil {
+               problem.Kind, problem.Err = ProblemInvalidJSON, err
+               break
+            }
+            if err := d.checkChecksum(collection, resource, b); err != nil {
+               problem.Kind, problem.Err = ProblemChecksum, err
+               break
+            }
+            if shard != "." && shard != shardName(resource) {
+               problem.Kind = ProblemMisplaced
+            }
+      }
+      
+      if problem.Kind == "" {
+         continue
+      }
+      
+      if repair && problem.Kind == ProblemMisplaced {
+         dest, err := d.moveMisplaced(collection, resource, name, b)
+         if err != nil {
+            return err
+         }
+         if dest != "" {
+            problem.Moved = dest
+            d.logAt(LevelWarn, collection, "Moved misplaced record", Field{Key: "file", Value: name}, Field{Key: "destination", Value: dest})
+            report.Problems = append(report.Problems, problem)
+            continue
+         }
+      }
+      
+      if repair {
+         dest, err := d.quarantine(collection, name)
+         if err != nil {
+            return err
+         }
+         problem.Quarantined = dest
+         // A misplaced record only gets here when another record of its
+         // name is where it belongs, and that one stays indexed.
+         if strings.HasSuffix(name, ".json") && problem.Kind != ProblemMisplaced {
+            d.unindexRecord(collection, resource)
+            if err := d.removeChecksum(collection, resource); err != nil {
+               return err
+            }
+         }
+         d.logAt(LevelWarn, collection, "Quarantined file", Field{Key: "file", Value: name}, Field{Key: "destination", Value: dest})
+      }
+      report.Problems = append(report.Problems, problem)
+   }
+   
+   return nil
+}
+
+// moveMisplaced puts a record found in the wrong shard directory where it
+// belongs and returns its new path. If a record of the same name is there
+// already, it returns "" and leaves the file alone.
+func (d *Driver) moveMisplaced(collection, resource, name string, b []byte) (string, error) {
+   exists, err := d.exists(collection, resource)
+   if err != nil || exists {
+      return "", err
+   }
+   
+   dest := d.recordPath(collection, resource)
+   if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+      return "", err
+   }
+   if err := os.Rename(filepath.Join(d.collectionDir(collection), name), dest); err != nil {
+      return "", err
+   }
+   d.indexRecord(collection, resource, b)
+   return dest, nil
+}
+
+func (d *Driver) quarantine(collection, name string) (string, error) {
+   dest := filepath.Join(d.dir, quarantineDir, collection, name)
+   if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+      return "", err
+   }
+   
+   if _, err := os.Stat(dest); err == nil {
+      dest = fmt.Sprintf("%s.%d", dest, time.Now().UnixNano())
+   }
+   
+   return dest, os.Rename(filepath.Join(d.collectionDir(collection), name), dest)
+}
+
+func (d *Driver) checksumPath(collection, resource string) string {
+   return filepath.Join(d.collectionDir(collection), checksumDir, resource + ".sha256")
+}
+
+func checksum(b []byte) string {
+   sum := sha256.Sum256(b)
+   return hex.EncodeToString(sum[:])
+}
+
+// writeChecksum records the checksum of a record that was just written. A
+// crash between the two leaves a mismatch for Verify to report. The caller
+// must hold the collection lock.
+func (d *Driver) writeChecksum(collection, resource string, b []byte) error {
+   path := d.checksumPath(collection, resource)
+   if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+      return err
+   }
+   
+   tmpPath := path + ".tmp"
+   if err := ioutil.WriteFile(tmpPath, []byte(checksum(b)), 0644); err != nil {
+      return err
+   }
+   return os.Rename(tmpPath, path)
+}
+
+func (d *Driver) removeChecksum(collection, resource string) error {
+   err := os.Remove(d.checksumPath(collection, resource))
+   if os.IsNotExist(err) {
+      return nil
+   }
+   return err
+}
+
+// checkChecksum compares a record with its recorded checksum. Records
+// written before checksums were kept have none, and pass.
+func (d *Driver) checkChecksum(collection, resource string, b []byte) error {
+   want, err := ioutil.ReadFile(d.checksumPath(collection, resource))
+   if os.IsNotExist(err) {
+      return nil
+   }
+   if err != nil {
+      return err
+   }
+   
+   if got := checksum(b); got != strings.TrimSpace(string(want)) {
+      return fmt.Errorf("recorded checksum %s, content has %s", strings.TrimSpace(string(want)), got)
+   }
+   return nil
+}
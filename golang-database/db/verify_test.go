@@ -0,0 +1,133 @@
+package db_test
+
+import (
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "testing"
+   "github.com/ayush/golang-database/db"
+)
+
+func corruptUsers(t *testing.T, dir string) {
+   t.Helper()
+   
+   files := map[string]string{
+      "broken.json": "{not json",
+      "Ayush.json.tmp": "{}",
+      "notes.txt": "hello",
+   }
+   for name, content := range files {
+      if err := ioutil.WriteFile(filepath.Join(dir, "users", name), []byte(content), 0644); err != nil {
+         t.Fatal(err)
+      }
+   }
+}
+
+func TestVerifyFindsProblems(t *testing.T) {
+   d, dir := newTestDriver(t)
+   seedUsers(t, d)
+   corruptUsers(t, dir)
+   
+   report, err := d.Verify()
+   if err != nil {
+      t.Fatalf("Verify: %v", err)
+   }
+   if report.OK() {
+      t.Fatal("Verify reported a clean store")
+   }
+   
+   kinds := map[string]db.ProblemKind{}
+   for _, p := range report.Problems {
+      kinds[p.File] = p.Kind
+   }
+   want := map[string]db.ProblemKind{
+      "broken.json": db.ProblemInvalidJSON,
+      "Ayush.json.tmp": db.ProblemOrphanedTmp,
+      "notes.txt": db.ProblemNotJSON,
+   }
+   for file, kind := range want {
+      if kinds[file] != kind {
+         t.Errorf("%s reported as %q, want %q", file, kinds[file], kind)
+      }
+   }
+   
+   if _, err := os.Stat(filepath.Join(dir, "users", "broken.json")); err != nil {
+      t.Error("Verify must not touch files")
+   }
+}
+
+func TestReadAllSkipsCorruptRecords(t *testing.T) {
+   d, dir := newTestDriver(t)
+   seedUsers(t, d)
+   corruptUsers(t, dir)
+   
+   records, err := d.ReadAll("users")
+   if err != nil {
+      t.Fatalf("ReadAll: %v", err)
+   }
+   if len(records) != len(sampleUsers) {
+      t.Fatalf("ReadAll returned %d records, want %d", len(records), len(sampleUsers))
+   }
+}
+
+func TestRepairQuarantines(t *testing.T) {
+   d, dir := newTestDriver(t)
+   seedUsers(t, d)
+   corruptUsers(t, dir)
+   
+   report, err := d.Repair()
+   if err != nil {
+      t.Fatalf("Repair: %v", err)
+   }
+   for _, p := range report.Problems {
+      if p.Quarantined == "" {
+         t.Errorf("%s was not quarantined", p.File)
+         continue
+      }
+      if _, err := os.Stat(p.Quarantined); err != nil {
+         t.Errorf("quarantined copy of %s is missing: %v", p.File, err)
+      }
+   }
+   
+   report, err = d.Verify()
+   if err != nil {
+      t.Fatalf("Verify: %v", err)
+   }
+   if !report.OK() || report.Records != len(sampleUsers) {
+      t.Fatalf("store not clean after Repair: %v", report.Problems)
+   }
+}
+
+func TestVerifyFindsChecksumMismatch(t *testing.T) {
+   d, dir := newTestDriver(t)
+   seedUsers(t, d)
+   
+   // Still valid JSON, but not what was written.
+   tampered := filepath.Join(dir, "users", "Ayush.json")
+   if err := ioutil.WriteFile(tampered, []byte(`{"Name": "Mallory"}`), 0644); err != nil {
+      t.Fatal(err)
+   }
+   
+   report, err := d.Verify()
+   if err != nil {
+      t.Fatalf("Verify: %v", err)
+   }
+   if len(report.Problems) != 1 || report.Problems[0].Kind != db.ProblemChecksum || report.Problems[0].File != "Ayush.json" {
+      t.Fatalf("Verify = %v, want a checksum mismatch of Ayush.json", report.Problems)
+   }
+   
+   if _, err := d.Repair(); err != nil {
+      t.Fatalf("Repair: %v", err)
+   }
+   if _, err := os.Stat(tampered); !os.IsNotExist(err) {
+      t.Fatal("the tampered record was not quarantined")
+   }
+   
+   // Writing the record again gives it a fresh checksum.
+   if err := d.Write("users", "Ayush", sampleUsers[0]); err != nil {
+      t.Fatalf("Write: %v", err)
+   }
+   if report, err := d.Verify(); err != nil || !report.OK() {
+      t.Fatalf("Verify after rewriting = %v, %v", report.Problems, err)
+   }
+}
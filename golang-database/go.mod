@@ -5,4 +5,11 @@ go 1.17
 require (
 	github.com/blend/go-sdk v1.20210918.2
 	github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25
+	github.com/rs/zerolog v1.15.0
+	go.uber.org/zap v1.10.0
+)
+
+require (
+	go.uber.org/atomic v1.6.0 // indirect
+	go.uber.org/multierr v1.5.0 // indirect
 )
@@ -0,0 +1,9 @@
+module github.com/ayush/golang-database/slogadapter
+
+go 1.21
+
+require github.com/ayush/golang-database v0.0.0-00010101000000-000000000000
+
+require github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25 // indirect
+
+replace github.com/ayush/golang-database => ../
@@ -0,0 +1,65 @@
+// Package slogadapter lets the Driver log through a log/slog Logger:
+//
+//    database, err := db.New("./data", &db.Options{Logger: slogadapter.New(slog.Default())})
+package slogadapter
+
+import (
+   "context"
+   "fmt"
+   "log/slog"
+   "strings"
+   "github.com/ayush/golang-database/db"
+)
+
+// Levels slog has no name for.
+const (
+   LevelTrace = slog.LevelDebug - 4
+   LevelFatal = slog.LevelError + 4
+)
+
+// Logger adapts a *slog.Logger to db.StructuredLogger. Fatal logs at
+// LevelFatal but, like the default lumber logger, doesn't exit.
+type Logger struct {
+   l *slog.Logger
+}
+
+func New(l *slog.Logger) *Logger {
+   return &Logger{l: l}
+}
+
+func (a *Logger) Log(level db.Level, msg string, fields ...db.Field) {
+   attrs := make([]slog.Attr, len(fields))
+   for i, field := range fields {
+      attrs[i] = slog.Any(field.Key, field.Value)
+   }
+   a.l.LogAttrs(context.Background(), toSlog(level), msg, attrs...)
+}
+
+func toSlog(level db.Level) slog.Level {
+   switch {
+      case level <= db.LevelTrace:
+         return LevelTrace
+      case level == db.LevelDebug:
+         return slog.LevelDebug
+      case level == db.LevelInfo:
+         return slog.LevelInfo
+      case level == db.LevelWarn:
+         return slog.LevelWarn
+      default:
+         return slog.LevelError
+   }
+}
+
+func (a *Logger) logf(level slog.Level, format string, v ...interface{}) {
+   ctx := context.Background()
+   if a.l.Enabled(ctx, level) {
+      a.l.Log(ctx, level, strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"))
+   }
+}
+
+func (a *Logger) Fatal(format string, v ...interface{}) { a.logf(LevelFatal, format, v...) }
+func (a *Logger) Error(format string, v ...interface{}) { a.logf(slog.LevelError, format, v...) }
+func (a *Logger) Warn(format string, v ...interface{})  { a.logf(slog.LevelWarn, format, v...) }
+func (a *Logger) Info(format string, v ...interface{})  { a.logf(slog.LevelInfo, format, v...) }
+func (a *Logger) Debug(format string, v ...interface{}) { a.logf(slog.LevelDebug, format, v...) }
+func (a *Logger) Trace(format string, v ...interface{}) { a.logf(LevelTrace, format, v...) }
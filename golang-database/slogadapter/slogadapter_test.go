@@ -0,0 +1,38 @@
+package slogadapter_test
+
+import (
+   "bytes"
+   "encoding/json"
+   "log/slog"
+   "testing"
+   "time"
+   "github.com/ayush/golang-database/db"
+   "github.com/ayush/golang-database/slogadapter"
+)
+
+func TestLogger(t *testing.T) {
+   var buf bytes.Buffer
+   logger := slogadapter.New(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+   
+   logger.Log(db.LevelDebug, "Operation finished", db.Field{Key: "collection", Value: "users"}, db.Field{Key: "duration", Value: time.Millisecond})
+   logger.Trace("dropped below debug\n")
+   logger.Warn("Skipping '%s'\n", "x")
+   
+   dec := json.NewDecoder(&buf)
+   var first, second map[string]interface{}
+   if err := dec.Decode(&first); err != nil {
+      t.Fatalf("Decode: %v", err)
+   }
+   if first["level"] != "DEBUG" || first["collection"] != "users" || first["duration"] != float64(time.Millisecond) {
+      t.Fatalf("structured record = %v", first)
+   }
+   if err := dec.Decode(&second); err != nil {
+      t.Fatalf("Decode: %v", err)
+   }
+   if second["level"] != "WARN" || second["msg"] != "Skipping 'x'" {
+      t.Fatalf("printf record = %v", second)
+   }
+   if dec.More() {
+      t.Fatal("Trace was logged above its level")
+   }
+}
@@ -0,0 +1,15 @@
+module github.com/ayush/golang-database/zapadapter
+
+go 1.17
+
+require (
+	github.com/ayush/golang-database v0.0.0-00010101000000-000000000000
+	go.uber.org/zap v1.28.0
+)
+
+require (
+	github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25 // indirect
+	go.uber.org/multierr v1.10.0 // indirect
+)
+
+replace github.com/ayush/golang-database => ../
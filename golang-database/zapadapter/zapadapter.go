@@ -0,0 +1,60 @@
+// Package zapadapter lets the Driver log through a zap Logger:
+//
+//    database, err := db.New("./data", &db.Options{Logger: zapadapter.New(zap.NewExample())})
+package zapadapter
+
+import (
+   "fmt"
+   "strings"
+   "github.com/ayush/golang-database/db"
+   "go.uber.org/zap"
+   "go.uber.org/zap/zapcore"
+)
+
+// Logger adapts a *zap.Logger to db.StructuredLogger. zap has no trace
+// level, so Trace logs at debug. Fatal logs at error and, like the default
+// lumber logger, doesn't exit.
+type Logger struct {
+   l *zap.Logger
+}
+
+func New(l *zap.Logger) *Logger {
+   return &Logger{l: l}
+}
+
+func (a *Logger) Log(level db.Level, msg string, fields ...db.Field) {
+   if ce := a.l.Check(toZap(level), msg); ce != nil {
+      zapFields := make([]zap.Field, len(fields))
+      for i, field := range fields {
+         zapFields[i] = zap.Any(field.Key, field.Value)
+      }
+      ce.Write(zapFields...)
+   }
+}
+
+func toZap(level db.Level) zapcore.Level {
+   switch {
+      case level <= db.LevelDebug:
+         return zapcore.DebugLevel
+      case level == db.LevelInfo:
+         return zapcore.InfoLevel
+      case level == db.LevelWarn:
+         return zapcore.WarnLevel
+      default:
+         return zapcore.ErrorLevel
+   }
+}
+
+func (a *Logger) logf(level zapcore.Level, format string, v ...interface{}) {
+   if ce := a.l.Check(level, ""); ce != nil {
+      ce.Message = strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+      ce.Write()
+   }
+}
+
+func (a *Logger) Fatal(format string, v ...interface{}) { a.logf(zapcore.ErrorLevel, format, v...) }
+func (a *Logger) Error(format string, v ...interface{}) { a.logf(zapcore.ErrorLevel, format, v...) }
+func (a *Logger) Warn(format string, v ...interface{})  { a.logf(zapcore.WarnLevel, format, v...) }
+func (a *Logger) Info(format string, v ...interface{})  { a.logf(zapcore.InfoLevel, format, v...) }
+func (a *Logger) Debug(format string, v ...interface{}) { a.logf(zapcore.DebugLevel, format, v...) }
+func (a *Logger) Trace(format string, v ...interface{}) { a.logf(zapcore.DebugLevel, format, v...) }
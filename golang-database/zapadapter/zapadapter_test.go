@@ -0,0 +1,30 @@
+package zapadapter_test
+
+import (
+   "testing"
+   "github.com/ayush/golang-database/db"
+   "github.com/ayush/golang-database/zapadapter"
+   "go.uber.org/zap"
+   "go.uber.org/zap/zapcore"
+   "go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+   core, logs := observer.New(zapcore.InfoLevel)
+   logger := zapadapter.New(zap.New(core))
+   
+   logger.Log(db.LevelInfo, "Quarantined file", db.Field{Key: "collection", Value: "users"}, db.Field{Key: "file", Value: "x.json"})
+   logger.Log(db.LevelDebug, "dropped below info")
+   logger.Error("Unable to write audit log: %v\n", "disk full")
+   
+   entries := logs.AllUntimed()
+   if len(entries) != 2 {
+      t.Fatalf("logged %d entries, want 2", len(entries))
+   }
+   if fields := entries[0].ContextMap(); fields["collection"] != "users" || fields["file"] != "x.json" {
+      t.Fatalf("fields = %v", fields)
+   }
+   if entries[1].Level != zapcore.ErrorLevel || entries[1].Message != "Unable to write audit log: disk full" {
+      t.Fatalf("printf entry = %+v", entries[1].Entry)
+   }
+}
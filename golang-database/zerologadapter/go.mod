@@ -0,0 +1,17 @@
+module github.com/ayush/golang-database/zerologadapter
+
+go 1.23
+
+require (
+	github.com/ayush/golang-database v0.0.0-00010101000000-000000000000
+	github.com/rs/zerolog v1.35.1
+)
+
+require (
+	github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25 // indirect
+	github.com/mattn/go-colorable v0.1.14 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	golang.org/x/sys v0.29.0 // indirect
+)
+
+replace github.com/ayush/golang-database => ../
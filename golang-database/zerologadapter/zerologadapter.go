@@ -0,0 +1,68 @@
+// Package zerologadapter lets the Driver log through a zerolog Logger:
+//
+//    database, err := db.New("./data", &db.Options{Logger: zerologadapter.New(log.Logger)})
+package zerologadapter
+
+import (
+   "fmt"
+   "strings"
+   "time"
+   "github.com/ayush/golang-database/db"
+   "github.com/rs/zerolog"
+)
+
+// Logger adapts a zerolog.Logger to db.StructuredLogger. Fatal logs at
+// fatal level but, like the default lumber logger, doesn't exit.
+type Logger struct {
+   l zerolog.Logger
+}
+
+func New(l zerolog.Logger) *Logger {
+   return &Logger{l: l}
+}
+
+func (a *Logger) Log(level db.Level, msg string, fields ...db.Field) {
+   e := a.l.WithLevel(toZerolog(level))
+   if e == nil {
+      return
+   }
+   for _, field := range fields {
+      switch value := field.Value.(type) {
+         case time.Duration:
+            e = e.Dur(field.Key, value)
+         case error:
+            e = e.AnErr(field.Key, value)
+         default:
+            e = e.Interface(field.Key, value)
+      }
+   }
+   e.Msg(msg)
+}
+
+func toZerolog(level db.Level) zerolog.Level {
+   switch {
+      case level <= db.LevelTrace:
+         return zerolog.TraceLevel
+      case level == db.LevelDebug:
+         return zerolog.DebugLevel
+      case level == db.LevelInfo:
+         return zerolog.InfoLevel
+      case level == db.LevelWarn:
+         return zerolog.WarnLevel
+      default:
+         return zerolog.ErrorLevel
+   }
+}
+
+func (a *Logger) logf(level zerolog.Level, format string, v ...interface{}) {
+   if e := a.l.WithLevel(level); e != nil {
+      e.Msg(strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"))
+   }
+}
+
+func (a *Logger) Fatal(format string, v ...interface{}) { a.logf(zerolog.FatalLevel, format, v...) }
+func (a *Logger) Error(format string, v ...interface{}) { a.logf(zerolog.ErrorLevel, format, v...) }
+func (a *Logger) Warn(format string, v ...interface{})  { a.logf(zerolog.WarnLevel, format, v...) }
+func (a *Logger) Info(format string, v ...interface{})  { a.logf(zerolog.InfoLevel, format, v...) }
+func (a *Logger) Debug(format string, v ...interface{}) { a.logf(zerolog.DebugLevel, format, v...) }
+func (a *Logger) Trace(format string, v ...interface{}) { a.logf(zerolog.TraceLevel, format, v...) }
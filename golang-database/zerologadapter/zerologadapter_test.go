@@ -0,0 +1,35 @@
+package zerologadapter_test
+
+import (
+   "bytes"
+   "encoding/json"
+   "errors"
+   "testing"
+   "github.com/ayush/golang-database/db"
+   "github.com/ayush/golang-database/zerologadapter"
+   "github.com/rs/zerolog"
+)
+
+func TestLogger(t *testing.T) {
+   var buf bytes.Buffer
+   logger := zerologadapter.New(zerolog.New(&buf).Level(zerolog.DebugLevel))
+   
+   logger.Log(db.LevelDebug, "Operation finished", db.Field{Key: "bytes_read", Value: int64(42)}, db.Field{Key: "error", Value: errors.New("boom")})
+   logger.Trace("dropped below debug\n")
+   logger.Fatal("still running\n")
+   
+   dec := json.NewDecoder(&buf)
+   var first, second map[string]interface{}
+   if err := dec.Decode(&first); err != nil {
+      t.Fatalf("Decode: %v", err)
+   }
+   if first["level"] != "debug" || first["bytes_read"] != float64(42) || first["error"] != "boom" {
+      t.Fatalf("structured record = %v", first)
+   }
+   if err := dec.Decode(&second); err != nil {
+      t.Fatalf("Decode: %v", err)
+   }
+   if second["level"] != "fatal" || second["message"] != "still running" {
+      t.Fatalf("printf record = %v", second)
+   }
+}
@@ -3,37 +3,234 @@ package db
 import (
    "errors"
    "log"
-   "golang.org/x/crypto/bcrypt"
+   "time"
    "secure-api-project/db/models"
+   "secure-api-project/randomStrings"
 )
 
-var users = map[string]models.User{}
+var (
+   ErrUserNotFound         = errors.New("User not found!")
+   ErrUsernameTaken        = errors.New("Username is already taken!")
+   ErrRefreshTokenNotFound = errors.New("Refresh token not found!")
+   // ErrRefreshTokenReused is returned when a refresh token is presented
+   // again after it was exchanged for a new one. Its whole family is revoked
+   // by then.
+   ErrRefreshTokenReused = errors.New("Refresh token was already used!")
+)
+
+// UserStore keeps the accounts, keyed by a random uuid.
+type UserStore interface {
+   CreateUser(uuid string, user models.User) error
+   FetchUserByID(uuid string) (models.User, error)
+   FetchUserByUsername(username string) (models.User, string, error)
+   UpdateUser(uuid string, user models.User) error
+   DeleteUser(uuid string) error
+}
+
+// Client is where a refresh token was issued to.
+type Client struct {
+   Device string
+   IP     string
+}
+
+// RefreshToken is what we know about a refresh JTI. Every token issued by
+// rotating another belongs to the same family, which stands for one login
+// session. Spent tokens are kept until they expire, so that a replay of one
+// can be told apart from a token we never issued.
+type RefreshToken struct {
+   UUID    string
+   Family  string
+   Spent   bool
+   Expires time.Time
+   // SpentAt is when the token was spent and Successor the JTI issued in
+   // its place. Predecessor is the JTI a token was issued for.
+   SpentAt     time.Time
+   Successor   string
+   Predecessor string
+   Client
+   // Started is when the session logged in, Issued when this token of it
+   // was.
+   Started time.Time
+   Issued  time.Time
+}
+
+// TokenStore keeps the refresh tokens, keyed by JTI.
+type TokenStore interface {
+   AddRefreshToken(jti string, token RefreshToken) error
+   FetchRefreshToken(jti string) (RefreshToken, error)
+   // UseRefreshToken marks a token spent and returns it. A token that was
+   // spent before revokes its whole family and fails with
+   // ErrRefreshTokenReused, unless it was spent less than
+   // models.RefreshTokenReuseGrace ago and its successor is still unused:
+   // then it's returned with Successor set, for the caller to hand out that
+   // one again.
+   UseRefreshToken(jti string) (RefreshToken, error)
+   RevokeRefreshTokenFamily(jti string) error
+   // ListRefreshTokens returns the tokens of uuid that can still be used,
+   // one per session, keyed by JTI.
+   ListRefreshTokens(uuid string) (map[string]RefreshToken, error)
+   // RevokeUserRefreshTokens revokes every refresh token of uuid.
+   RevokeUserRefreshTokens(uuid string) error
+   // FamilyActive reports whether family is a session of uuid that hasn't
+   // been revoked or expired. One whose token was just spent counts, while
+   // its successor is being issued.
+   FamilyActive(uuid, family string) (bool, error)
+}
 
-func InitDB() {}
+type Store interface {
+   UserStore
+   TokenStore
+}
+
+var store Store = NewMemoryStore()
+
+// InitDB sets the store the package functions use. Until it is called they
+// use a MemoryStore.
+func InitDB(s Store) {
+   store = s
+}
 
 func FetchUserByUsername(username string) (models.User, string, error) {
-   for k, v := range users {
-      if v.Username == username {
-         return v, k, nil
-      }
+   return store.FetchUserByUsername(username)
+}
+
+func FetchUserByID(uuid string) (models.User, error) {
+   return store.FetchUserByID(uuid)
+}
+
+func StoreUser(username, password, role string) (uuid string, err error) {
+   passwordHash, err := hashPassword(password)
+   if err != nil {
+      return "", err
    }
-   return models.User{}, "", errors.New("User not found that matches the username!")
+   
+   uuid, err = randomstrings.GenerateRandomString(32)
+   if err != nil {
+      return "", err
+   }
+   
+   user := models.User{Username: username, PasswordHash: passwordHash, Role: role}
+   if err := store.CreateUser(uuid, user); err != nil {
+      return "", err
+   }
+   return uuid, nil
 }
 
-func StoreUser(username, password, role string) (uuid string, err error) {}
+// SetUserRole gives the user named username another role. It applies to
+// tokens issued from then on, at the latest when the auth token is next
+// refreshed.
+func SetUserRole(username, role string) error {
+   user, uuid, err := store.FetchUserByUsername(username)
+   if err != nil {
+      return err
+   }
+   user.Role = role
+   return store.UpdateUser(uuid, user)
+}
 
-func DeleteUser() {}
+// DeleteUser removes the user of uuid and all of its sessions.
+func DeleteUser(uuid string) error {
+   return store.DeleteUser(uuid)
+}
 
-func FetchUserById() {}
+// StoreRefreshToken records a new refresh token and returns its JTI. A
+// token without a family starts a new session, named after the token; one
+// with a Predecessor becomes its successor.
+func StoreRefreshToken(token RefreshToken) (jti string, err error) {
+   jti, err = randomstrings.GenerateRandomString(32)
+   if err != nil {
+      return "", err
+   }
+   
+   token.Spent = false
+   token.SpentAt = time.Time{}
+   token.Successor = ""
+   token.Issued = time.Now()
+   if token.Family == "" {
+      token.Family = jti
+      token.Started = token.Issued
+   }
+   if err := store.AddRefreshToken(jti, token); err != nil {
+      return "", err
+   }
+   return jti, nil
+}
 
-func StoreRefreshToken() {}
+// UseRefreshToken spends a refresh token and returns it, so its successor
+// can join its family. See TokenStore for tokens presented again.
+func UseRefreshToken(jti string) (RefreshToken, error) {
+   return store.UseRefreshToken(jti)
+}
 
-func DeleteRefreshToken() {}
+// FetchRefreshToken returns the refresh token jti, as long as it hasn't
+// expired.
+func FetchRefreshToken(jti string) (RefreshToken, error) {
+   return store.FetchRefreshToken(jti)
+}
 
-func CheckRefreshToken() bool {}
+// RevokeRefreshTokenFamily revokes every refresh token of the family jti
+// belongs to, ending that session.
+func RevokeRefreshTokenFamily(jti string) error {
+   return store.RevokeRefreshTokenFamily(jti)
+}
+
+// ListSessions returns the live refresh token of every session of uuid,
+// keyed by JTI.
+func ListSessions(uuid string) (map[string]RefreshToken, error) {
+   return store.ListRefreshTokens(uuid)
+}
+
+// RevokeSession ends the session of uuid that jti belongs to and returns
+// its family. Any token of the session will do, even one spent since it was
+// listed. Sessions of other users are not found.
+func RevokeSession(uuid, jti string) (family string, err error) {
+   token, err := store.FetchRefreshToken(jti)
+   if err != nil {
+      return "", err
+   }
+   if token.UUID != uuid {
+      return "", ErrRefreshTokenNotFound
+   }
+   return token.Family, store.RevokeRefreshTokenFamily(jti)
+}
 
-func LogUserIn() {}
+// SessionActive reports whether the session family of uuid can still be
+// refreshed, so that its auth tokens end with it.
+func SessionActive(uuid, family string) bool {
+   active, err := store.FamilyActive(uuid, family)
+   return err == nil && active
+}
 
-func generateBcryptHash() {}
+// RevokeAllSessions logs uuid out everywhere.
+func RevokeAllSessions(uuid string) error {
+   return store.RevokeUserRefreshTokens(uuid)
+}
 
-func checkPasswordAgainstHash() error {}
\ No newline at end of file
+// LogUserIn checks a username and password, returning the user and its uuid.
+// An unknown user takes as long to turn down as a wrong password. A password
+// hash made under an older policy is replaced by one following the current
+// policy.
+func LogUserIn(username, password string) (models.User, string, error) {
+   user, uuid, err := store.FetchUserByUsername(username)
+   if err != nil {
+      if errors.Is(err, ErrUserNotFound) {
+         wasteTime(password)
+      }
+      return models.User{}, "", err
+   }
+   if err := checkPasswordAgainstHash(user.PasswordHash, password); err != nil {
+      return models.User{}, "", err
+   }
+   
+   if !currentHasher().Current(user.PasswordHash) {
+      rehashed, err := hashPassword(password)
+      if err == nil {
+         user.PasswordHash = rehashed
+         err = store.UpdateUser(uuid, user)
+      }
+      if err != nil {
+         log.Printf("Could not upgrade the password hash of %s: %v", uuid, err)
+      }
+   }
+   return user, uuid, nil
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+   "encoding/json"
+   "errors"
+   "io/ioutil"
+   "os"
+   "path/filepath"
+   "sync"
+   "secure-api-project/db/models"
+)
+
+// FileStore is a MemoryStore that saves itself to a JSON file after every
+// change, and loads it back when opened. The file is replaced atomically, so
+// a crash leaves either the old or the new content behind. If saving fails
+// the change stays in memory and the error is returned.
+type FileStore struct {
+   *MemoryStore
+   path  string
+   mutex sync.Mutex
+}
+
+type fileContent struct {
+   Users         map[string]models.User  `json:"users"`
+   RefreshTokens map[string]RefreshToken `json:"refreshTokens"`
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+   s := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+   
+   b, err := ioutil.ReadFile(path)
+   if os.IsNotExist(err) {
+      return s, os.MkdirAll(filepath.Dir(path), 0700)
+   }
+   if err != nil {
+      return nil, err
+   }
+   
+   content := fileContent{}
+   if err := json.Unmarshal(b, &content); err != nil {
+      return nil, err
+   }
+   if content.Users != nil {
+      s.users = content.Users
+   }
+   for jti, token := range content.RefreshTokens {
+      s.putRefreshToken(jti, token)
+   }
+   return s, nil
+}
+
+func (s *FileStore) CreateUser(uuid string, user models.User) error {
+   return s.update(func() error { return s.MemoryStore.CreateUser(uuid, user) })
+}
+
+func (s *FileStore) UpdateUser(uuid string, user models.User) error {
+   return s.update(func() error { return s.MemoryStore.UpdateUser(uuid, user) })
+}
+
+func (s *FileStore) DeleteUser(uuid string) error {
+   return s.update(func() error { return s.MemoryStore.DeleteUser(uuid) })
+}
+
+func (s *FileStore) AddRefreshToken(jti string, token RefreshToken) error {
+   return s.update(func() error { return s.MemoryStore.AddRefreshToken(jti, token) })
+}
+
+func (s *FileStore) UseRefreshToken(jti string) (token RefreshToken, err error) {
+   err = s.update(func() error {
+      token, err = s.MemoryStore.UseRefreshToken(jti)
+      return err
+   })
+   return token, err
+}
+
+func (s *FileStore) RevokeRefreshTokenFamily(jti string) error {
+   return s.update(func() error { return s.MemoryStore.RevokeRefreshTokenFamily(jti) })
+}
+
+func (s *FileStore) RevokeUserRefreshTokens(uuid string) error {
+   return s.update(func() error { return s.MemoryStore.RevokeUserRefreshTokens(uuid) })
+}
+
+// update applies a change and saves the result. A reused refresh token is
+// an error but still revokes its family, which has to be saved too.
+func (s *FileStore) update(change func() error) error {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   err := change()
+   if err != nil && !errors.Is(err, ErrRefreshTokenReused) {
+      return err
+   }
+   if saveErr := s.save(); saveErr != nil {
+      return saveErr
+   }
+   return err
+}
+
+func (s *FileStore) save() error {
+   s.MemoryStore.mutex.Lock()
+   b, err := json.MarshalIndent(fileContent{Users: s.users, RefreshTokens: s.refreshTokens}, "", "\t")
+   s.MemoryStore.mutex.Unlock()
+   if err != nil {
+      return err
+   }
+   
+   tmpPath := s.path + ".tmp"
+   if err := ioutil.WriteFile(tmpPath, b, 0600); err != nil {
+      return err
+   }
+   return os.Rename(tmpPath, s.path)
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+   "sync"
+   "time"
+   "secure-api-project/db/models"
+)
+
+// MemoryStore keeps everything in maps. Its content is lost on restart.
+type MemoryStore struct {
+   mutex         sync.Mutex
+   users         map[string]models.User
+   refreshTokens map[string]RefreshToken
+   // families indexes the JTIs of refreshTokens by family.
+   families map[string]map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+   return &MemoryStore{
+      users: map[string]models.User{},
+      refreshTokens: map[string]RefreshToken{},
+      families: map[string]map[string]bool{},
+   }
+}
+
+func (s *MemoryStore) CreateUser(uuid string, user models.User) error {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   for _, v := range s.users {
+      if v.Username == user.Username {
+         return ErrUsernameTaken
+      }
+   }
+   s.users[uuid] = user
+   return nil
+}
+
+func (s *MemoryStore) FetchUserByID(uuid string) (models.User, error) {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   user, ok := s.users[uuid]
+   if !ok {
+      return models.User{}, ErrUserNotFound
+   }
+   return user, nil
+}
+
+func (s *MemoryStore) FetchUserByUsername(username string) (models.User, string, error) {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   for k, v := range s.users {
+      if v.Username == username {
+         return v, k, nil
+      }
+   }
+   return models.User{}, "", ErrUserNotFound
+}
+
+func (s *MemoryStore) UpdateUser(uuid string, user models.User) error {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   if _, ok := s.users[uuid]; !ok {
+      return ErrUserNotFound
+   }
+   for k, v := range s.users {
+      if k != uuid && v.Username == user.Username {
+         return ErrUsernameTaken
+      }
+   }
+   s.users[uuid] = user
+   return nil
+}
+
+// DeleteUser removes a user along with all of its refresh tokens.
+func (s *MemoryStore) DeleteUser(uuid string) error {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   if _, ok := s.users[uuid]; !ok {
+      return ErrUserNotFound
+   }
+   delete(s.users, uuid)
+   for k, v := range s.refreshTokens {
+      if v.UUID == uuid {
+         s.forgetRefreshToken(k)
+      }
+   }
+   return nil
+}
+
+// AddRefreshToken stores a refresh token, dropping the ones that expired,
+// and links it to its predecessor.
+func (s *MemoryStore) AddRefreshToken(jti string, token RefreshToken) error {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   now := time.Now()
+   for k, v := range s.refreshTokens {
+      if now.After(v.Expires) {
+         s.forgetRefreshToken(k)
+      }
+   }
+   s.putRefreshToken(jti, token)
+   if predecessor, ok := s.refreshTokens[token.Predecessor]; ok {
+      predecessor.Successor = jti
+      s.refreshTokens[token.Predecessor] = predecessor
+   }
+   return nil
+}
+
+func (s *MemoryStore) FetchRefreshToken(jti string) (RefreshToken, error) {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   token, ok := s.refreshTokens[jti]
+   if !ok || time.Now().After(token.Expires) {
+      return RefreshToken{}, ErrRefreshTokenNotFound
+   }
+   return token, nil
+}
+
+func (s *MemoryStore) UseRefreshToken(jti string) (RefreshToken, error) {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   token, ok := s.refreshTokens[jti]
+   if !ok || time.Now().After(token.Expires) {
+      return RefreshToken{}, ErrRefreshTokenNotFound
+   }
+   if token.Spent {
+      successor, ok := s.refreshTokens[token.Successor]
+      if ok && !successor.Spent && time.Now().Before(token.SpentAt.Add(models.RefreshTokenReuseGrace)) {
+         return token, nil
+      }
+      s.revokeFamily(token.Family)
+      return RefreshToken{}, ErrRefreshTokenReused
+   }
+   
+   token.Spent = true
+   token.SpentAt = time.Now()
+   s.refreshTokens[jti] = token
+   return token, nil
+}
+
+func (s *MemoryStore) RevokeRefreshTokenFamily(jti string) error {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   if token, ok := s.refreshTokens[jti]; ok {
+      s.revokeFamily(token.Family)
+   }
+   return nil
+}
+
+func (s *MemoryStore) ListRefreshTokens(uuid string) (map[string]RefreshToken, error) {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   now := time.Now()
+   tokens := map[string]RefreshToken{}
+   for k, v := range s.refreshTokens {
+      if v.UUID == uuid && !v.Spent && now.Before(v.Expires) {
+         tokens[k] = v
+      }
+   }
+   return tokens, nil
+}
+
+func (s *MemoryStore) RevokeUserRefreshTokens(uuid string) error {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   for k, v := range s.refreshTokens {
+      if v.UUID == uuid {
+         s.forgetRefreshToken(k)
+      }
+   }
+   return nil
+}
+
+// FamilyActive looks family up in the index. A family whose tokens are all
+// spent is having the successor of the last one issued; a revoked one has
+// no tokens left.
+func (s *MemoryStore) FamilyActive(uuid, family string) (bool, error) {
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   
+   now := time.Now()
+   for jti := range s.families[family] {
+      token := s.refreshTokens[jti]
+      if token.UUID == uuid && now.Before(token.Expires) {
+         return true, nil
+      }
+   }
+   return false, nil
+}
+
+func (s *MemoryStore) revokeFamily(family string) {
+   for jti := range s.families[family] {
+      delete(s.refreshTokens, jti)
+   }
+   delete(s.families, family)
+}
+
+// putRefreshToken stores a refresh token and indexes it by family.
+func (s *MemoryStore) putRefreshToken(jti string, token RefreshToken) {
+   s.refreshTokens[jti] = token
+   if s.families[token.Family] == nil {
+      s.families[token.Family] = map[string]bool{}
+   }
+   s.families[token.Family][jti] = true
+}
+
+func (s *MemoryStore) forgetRefreshToken(jti string) {
+   token, ok := s.refreshTokens[jti]
+   if !ok {
+      return
+   }
+   delete(s.refreshTokens, jti)
+   delete(s.families[token.Family], jti)
+   if len(s.families[token.Family]) == 0 {
+      delete(s.families, token.Family)
+   }
+}
@@ -2,15 +2,27 @@ package models
 
 import (
    "time"
-   jwt "github.com/dgrijalva/jwt-go"
-   "secure-api-project/randomstrings"
+   jwt "github.com/golang-jwt/jwt/v4"
+   "secure-api-project/randomStrings"
 )
 
 const (
    RefreshTokenValidTime = time.Hour * 72
    AuthTokenValidTime = time.Minute * 15
+   // RefreshTokenReuseGrace is how long the refresh token spent last may
+   // be presented again, by another tab or a parallel request, and get the
+   // same successor instead of counting as a replay.
+   RefreshTokenReuseGrace = time.Second * 30
 )
 
+const (
+   RoleUser = "user"
+   RoleAdmin = "admin"
+)
+
+// Roles lists the roles a user can have.
+var Roles = []string{RoleUser, RoleAdmin}
+
 type User struct {
    Username     string
    PasswordHash string
@@ -21,6 +33,8 @@ type TokenClaims struct {
    jwt.StandardClaims
    Role string `json:"role"`
    Csrf string `json:"csrf"`
+   // Session is the refresh token family a token was issued in.
+   Session string `json:"sid,omitempty"`
 }
 
 func GenerateCsrfSecret() (string, error) {
@@ -0,0 +1,228 @@
+package db
+
+import (
+   "crypto/rand"
+   "crypto/subtle"
+   "encoding/base64"
+   "errors"
+   "fmt"
+   "strings"
+   "sync"
+   "golang.org/x/crypto/argon2"
+   "golang.org/x/crypto/bcrypt"
+)
+
+var (
+   ErrPasswordMismatch    = errors.New("Password doesn't match!")
+   ErrUnknownPasswordHash = errors.New("Password hash has an unknown format!")
+)
+
+// PasswordHasher hashes passwords into a self-describing string that
+// records the algorithm and its parameters, so hashes made under an older
+// policy can still be checked.
+type PasswordHasher interface {
+   Hash(password string) (string, error)
+   // Verify checks password against a hash made by this kind of hasher,
+   // whatever its parameters.
+   Verify(encoded, password string) error
+   // Current reports whether encoded was made with this hasher's algorithm
+   // and parameters. Hashes that aren't get upgraded on login.
+   Current(encoded string) bool
+}
+
+type BcryptHasher struct {
+   Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+   hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+   return string(hash), err
+}
+
+func (h BcryptHasher) Verify(encoded, password string) error {
+   err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+   if err == bcrypt.ErrMismatchedHashAndPassword {
+      return ErrPasswordMismatch
+   }
+   return err
+}
+
+func (h BcryptHasher) Current(encoded string) bool {
+   cost, err := bcrypt.Cost([]byte(encoded))
+   return err == nil && cost == h.Cost
+}
+
+// Argon2idHasher encodes hashes in the PHC string format,
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>.
+type Argon2idHasher struct {
+   Time    uint32
+   Memory  uint32 // in KiB
+   Threads uint8
+   SaltLen uint32
+   KeyLen  uint32
+}
+
+// DefaultArgon2idHasher follows the RFC 9106 recommendation for
+// memory-constrained environments.
+var DefaultArgon2idHasher = Argon2idHasher{Time: 3, Memory: 64 * 1024, Threads: 4, SaltLen: 16, KeyLen: 32}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+   salt := make([]byte, h.SaltLen)
+   if _, err := rand.Read(salt); err != nil {
+      return "", err
+   }
+   key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+   return h.encode(salt, key), nil
+}
+
+func (h Argon2idHasher) encode(salt, key []byte) string {
+   return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads,
+      base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func (h Argon2idHasher) Verify(encoded, password string) error {
+   params, salt, key, err := decodeArgon2id(encoded)
+   if err != nil {
+      return err
+   }
+   other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+   if subtle.ConstantTimeCompare(key, other) != 1 {
+      return ErrPasswordMismatch
+   }
+   return nil
+}
+
+func (h Argon2idHasher) Current(encoded string) bool {
+   params, _, _, err := decodeArgon2id(encoded)
+   return err == nil && params == h
+}
+
+func decodeArgon2id(encoded string) (params Argon2idHasher, salt, key []byte, err error) {
+   parts := strings.Split(encoded, "$")
+   if len(parts) != 6 || parts[1] != "argon2id" {
+      return params, nil, nil, ErrUnknownPasswordHash
+   }
+   
+   var version int
+   if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+      return params, nil, nil, ErrUnknownPasswordHash
+   }
+   if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+      return params, nil, nil, ErrUnknownPasswordHash
+   }
+   
+   salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+   if err != nil {
+      return params, nil, nil, ErrUnknownPasswordHash
+   }
+   key, err = base64.RawStdEncoding.DecodeString(parts[5])
+   if err != nil {
+      return params, nil, nil, ErrUnknownPasswordHash
+   }
+   params.SaltLen, params.KeyLen = uint32(len(salt)), uint32(len(key))
+   return params, salt, key, nil
+}
+
+// PasswordConfig is a password hashing policy: the algorithm, "argon2id" or
+// "bcrypt", and its cost. An empty algorithm means argon2id, and parameters
+// left at zero take the defaults.
+type PasswordConfig struct {
+   Algorithm     string
+   BcryptCost    int
+   Argon2Time    uint32
+   Argon2Memory  uint32 // in KiB
+   Argon2Threads uint8
+}
+
+// NewPasswordHasher returns the hasher config asks for.
+func NewPasswordHasher(config PasswordConfig) (PasswordHasher, error) {
+   switch config.Algorithm {
+      case "", "argon2id":
+         h := DefaultArgon2idHasher
+         if config.Argon2Time != 0 {
+            h.Time = config.Argon2Time
+         }
+         if config.Argon2Memory != 0 {
+            h.Memory = config.Argon2Memory
+         }
+         if config.Argon2Threads != 0 {
+            h.Threads = config.Argon2Threads
+         }
+         // argon2 needs at least 8 KiB of memory per thread.
+         if h.Memory < 8 * uint32(h.Threads) {
+            return nil, fmt.Errorf("Argon2id needs at least %d KiB of memory for %d threads!", 8 * uint32(h.Threads), h.Threads)
+         }
+         return h, nil
+      case "bcrypt":
+         h := BcryptHasher{Cost: bcrypt.DefaultCost}
+         if config.BcryptCost != 0 {
+            h.Cost = config.BcryptCost
+         }
+         if h.Cost < bcrypt.MinCost || h.Cost > bcrypt.MaxCost {
+            return nil, fmt.Errorf("Bcrypt cost has to be between %d and %d!", bcrypt.MinCost, bcrypt.MaxCost)
+         }
+         return h, nil
+      default:
+         return nil, fmt.Errorf("Unknown password hashing algorithm %q!", config.Algorithm)
+   }
+}
+
+var (
+   // hasherMutex guards passwordHasher and dummyHash.
+   hasherMutex    sync.Mutex
+   passwordHasher PasswordHasher = DefaultArgon2idHasher
+   // dummyHash is checked against when a login names an unknown user, so
+   // that takes as long as a wrong password.
+   dummyHash      string
+)
+
+// SetPasswordHasher sets the policy new passwords are hashed with. Existing
+// hashes keep working and are upgraded the next time their user logs in.
+func SetPasswordHasher(h PasswordHasher) {
+   hasherMutex.Lock()
+   defer hasherMutex.Unlock()
+   passwordHasher = h
+   dummyHash = ""
+}
+
+func currentHasher() PasswordHasher {
+   hasherMutex.Lock()
+   defer hasherMutex.Unlock()
+   return passwordHasher
+}
+
+// wasteTime checks password against a hash of the current policy and
+// throws the result away.
+func wasteTime(password string) {
+   hasherMutex.Lock()
+   if dummyHash == "" {
+      dummyHash, _ = passwordHasher.Hash("not a password")
+   }
+   hash := dummyHash
+   hasherMutex.Unlock()
+   checkPasswordAgainstHash(hash, password)
+}
+
+// hasherFor picks the kind of hasher that made encoded.
+func hasherFor(encoded string) (PasswordHasher, error) {
+   switch {
+      case strings.HasPrefix(encoded, "$argon2id$"):
+         return Argon2idHasher{}, nil
+      case strings.HasPrefix(encoded, "$2"):
+         return BcryptHasher{}, nil
+      default:
+         return nil, ErrUnknownPasswordHash
+   }
+}
+
+func hashPassword(password string) (string, error) {
+   return currentHasher().Hash(password)
+}
+
+func checkPasswordAgainstHash(hash, password string) error {
+   h, err := hasherFor(hash)
+   if err != nil {
+      return err
+   }
+   return h.Verify(hash, password)
+}
@@ -0,0 +1,102 @@
+package db_test
+
+import (
+   "strings"
+   "testing"
+   "secure-api-project/db"
+)
+
+var fastArgon2id = db.Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+func TestPasswordHashers(t *testing.T) {
+   for name, h := range map[string]db.PasswordHasher{"bcrypt": db.BcryptHasher{Cost: 4}, "argon2id": fastArgon2id} {
+      t.Run(name, func(t *testing.T) {
+         encoded, err := h.Hash("correct horse")
+         if err != nil {
+            t.Fatalf("Hash: %v", err)
+         }
+         if err := h.Verify(encoded, "correct horse"); err != nil {
+            t.Fatalf("Verify: %v", err)
+         }
+         if err := h.Verify(encoded, "wrong"); err != db.ErrPasswordMismatch {
+            t.Fatalf("Verify with a wrong password = %v, want ErrPasswordMismatch", err)
+         }
+         if !h.Current(encoded) {
+            t.Fatal("a fresh hash should be current")
+         }
+      })
+   }
+}
+
+func TestArgon2idEncoding(t *testing.T) {
+   encoded, _ := fastArgon2id.Hash("correct horse")
+   if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+      t.Fatalf("encoded hash = %q", encoded)
+   }
+   
+   stronger := fastArgon2id
+   stronger.Time = 2
+   if stronger.Current(encoded) {
+      t.Fatal("a hash with other parameters should not be current")
+   }
+   // Older parameters are read from the hash itself.
+   if err := stronger.Verify(encoded, "correct horse"); err != nil {
+      t.Fatalf("Verify: %v", err)
+   }
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+   h, err := db.NewPasswordHasher(db.PasswordConfig{Argon2Time: 2, Argon2Memory: 2048, Argon2Threads: 2})
+   want := db.Argon2idHasher{Time: 2, Memory: 2048, Threads: 2, SaltLen: 16, KeyLen: 32}
+   if err != nil || h != want {
+      t.Fatalf("argon2id hasher = %+v, %v; want %+v", h, err, want)
+   }
+   if h, err := db.NewPasswordHasher(db.PasswordConfig{Algorithm: "bcrypt", BcryptCost: 12}); err != nil || h != (db.BcryptHasher{Cost: 12}) {
+      t.Fatalf("bcrypt hasher = %+v, %v", h, err)
+   }
+   if h, err := db.NewPasswordHasher(db.PasswordConfig{}); err != nil || h != db.DefaultArgon2idHasher {
+      t.Fatalf("default hasher = %+v, %v", h, err)
+   }
+   
+   for _, config := range []db.PasswordConfig{
+      {Algorithm: "bcrypt", BcryptCost: 40},
+      {Argon2Memory: 8, Argon2Threads: 4},
+      {Algorithm: "md5"},
+   } {
+      if _, err := db.NewPasswordHasher(config); err == nil {
+         t.Errorf("NewPasswordHasher accepted %+v", config)
+      }
+   }
+}
+
+func TestLogUserInUpgradesHash(t *testing.T) {
+   s := db.NewMemoryStore()
+   db.InitDB(s)
+   db.SetPasswordHasher(db.BcryptHasher{Cost: 4})
+   defer db.SetPasswordHasher(db.DefaultArgon2idHasher)
+   
+   uuid, _ := db.StoreUser("ayush", "correct horse", "user")
+   user, _ := s.FetchUserByID(uuid)
+   if !strings.HasPrefix(user.PasswordHash, "$2") {
+      t.Fatalf("PasswordHash = %q, want a bcrypt hash", user.PasswordHash)
+   }
+   
+   db.SetPasswordHasher(fastArgon2id)
+   if _, _, err := db.LogUserIn("ayush", "wrong"); err == nil {
+      t.Fatal("LogUserIn accepted a wrong password")
+   }
+   if user, _ := s.FetchUserByID(uuid); !strings.HasPrefix(user.PasswordHash, "$2") {
+      t.Fatal("a failed login upgraded the hash")
+   }
+   
+   if _, _, err := db.LogUserIn("ayush", "correct horse"); err != nil {
+      t.Fatalf("LogUserIn: %v", err)
+   }
+   user, _ = s.FetchUserByID(uuid)
+   if !fastArgon2id.Current(user.PasswordHash) {
+      t.Fatalf("PasswordHash = %q, want it upgraded to argon2id", user.PasswordHash)
+   }
+   if _, _, err := db.LogUserIn("ayush", "correct horse"); err != nil {
+      t.Fatalf("LogUserIn after the upgrade: %v", err)
+   }
+}
@@ -0,0 +1,236 @@
+package db_test
+
+import (
+   "path/filepath"
+   "testing"
+   "time"
+   "secure-api-project/db"
+   "secure-api-project/db/models"
+)
+
+func eachStore(t *testing.T, test func(t *testing.T, s db.Store)) {
+   t.Run("memory", func(t *testing.T) {
+      test(t, db.NewMemoryStore())
+   })
+   t.Run("file", func(t *testing.T) {
+      s, err := db.OpenFileStore(filepath.Join(t.TempDir(), "store", "db.json"))
+      if err != nil {
+         t.Fatalf("OpenFileStore: %v", err)
+      }
+      test(t, s)
+   })
+}
+
+func TestUsers(t *testing.T) {
+   eachStore(t, func(t *testing.T, s db.Store) {
+      ayush := models.User{Username: "ayush", PasswordHash: "hash", Role: "user"}
+      if err := s.CreateUser("1", ayush); err != nil {
+         t.Fatalf("CreateUser: %v", err)
+      }
+      if err := s.CreateUser("2", ayush); err != db.ErrUsernameTaken {
+         t.Fatalf("CreateUser with a taken username = %v, want ErrUsernameTaken", err)
+      }
+      
+      user, uuid, err := s.FetchUserByUsername("ayush")
+      if err != nil || uuid != "1" || user != ayush {
+         t.Fatalf("FetchUserByUsername = %+v, %q, %v", user, uuid, err)
+      }
+      
+      ayush.Role = "admin"
+      if err := s.UpdateUser("1", ayush); err != nil {
+         t.Fatalf("UpdateUser: %v", err)
+      }
+      if user, err := s.FetchUserByID("1"); err != nil || user.Role != "admin" {
+         t.Fatalf("FetchUserByID = %+v, %v", user, err)
+      }
+      
+      if err := s.DeleteUser("1"); err != nil {
+         t.Fatalf("DeleteUser: %v", err)
+      }
+      if _, err := s.FetchUserByID("1"); err != db.ErrUserNotFound {
+         t.Fatalf("FetchUserByID after delete = %v, want ErrUserNotFound", err)
+      }
+   })
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+   eachStore(t, func(t *testing.T, s db.Store) {
+      expires := time.Now().Add(time.Hour)
+      s.AddRefreshToken("a", db.RefreshToken{UUID: "1", Family: "a", Expires: expires})
+      s.AddRefreshToken("b", db.RefreshToken{UUID: "1", Family: "a", Expires: expires})
+      s.AddRefreshToken("c", db.RefreshToken{UUID: "1", Family: "c", Expires: expires})
+      
+      if _, err := s.UseRefreshToken("a"); err != nil {
+         t.Fatalf("UseRefreshToken: %v", err)
+      }
+      if _, err := s.UseRefreshToken("a"); err != db.ErrRefreshTokenReused {
+         t.Fatalf("UseRefreshToken again = %v, want ErrRefreshTokenReused", err)
+      }
+      if _, err := s.FetchRefreshToken("b"); err != db.ErrRefreshTokenNotFound {
+         t.Fatalf("token of the revoked family = %v, want ErrRefreshTokenNotFound", err)
+      }
+      if _, err := s.FetchRefreshToken("c"); err != nil {
+         t.Fatalf("token of another family: %v", err)
+      }
+   })
+}
+
+func TestRefreshTokenReuseGrace(t *testing.T) {
+   eachStore(t, func(t *testing.T, s db.Store) {
+      expires := time.Now().Add(time.Hour)
+      s.AddRefreshToken("a", db.RefreshToken{UUID: "1", Family: "a", Expires: expires})
+      s.UseRefreshToken("a")
+      s.AddRefreshToken("b", db.RefreshToken{UUID: "1", Family: "a", Expires: expires, Predecessor: "a"})
+      
+      // Presented again before its successor was used, a token names it.
+      if token, err := s.UseRefreshToken("a"); err != nil || token.Successor != "b" {
+         t.Fatalf("UseRefreshToken again = %+v, %v; want its successor b", token, err)
+      }
+      if _, err := s.UseRefreshToken("b"); err != nil {
+         t.Fatalf("UseRefreshToken of the successor: %v", err)
+      }
+      if _, err := s.UseRefreshToken("a"); err != db.ErrRefreshTokenReused {
+         t.Fatalf("UseRefreshToken two generations back = %v, want ErrRefreshTokenReused", err)
+      }
+      
+      // The grace runs out.
+      s.AddRefreshToken("c", db.RefreshToken{UUID: "1", Family: "c", Expires: expires, Spent: true, SpentAt: time.Now().Add(-time.Hour), Successor: "d"})
+      s.AddRefreshToken("d", db.RefreshToken{UUID: "1", Family: "c", Expires: expires, Predecessor: "c"})
+      if _, err := s.UseRefreshToken("c"); err != db.ErrRefreshTokenReused {
+         t.Fatalf("UseRefreshToken after the grace = %v, want ErrRefreshTokenReused", err)
+      }
+      if _, err := s.FetchRefreshToken("d"); err != db.ErrRefreshTokenNotFound {
+         t.Fatalf("successor after a replay = %v, want ErrRefreshTokenNotFound", err)
+      }
+   })
+}
+
+func TestExpiredRefreshTokensAreGone(t *testing.T) {
+   eachStore(t, func(t *testing.T, s db.Store) {
+      s.AddRefreshToken("old", db.RefreshToken{UUID: "1", Family: "old", Expires: time.Now().Add(-time.Second)})
+      if _, err := s.UseRefreshToken("old"); err != db.ErrRefreshTokenNotFound {
+         t.Fatalf("UseRefreshToken = %v, want ErrRefreshTokenNotFound", err)
+      }
+   })
+}
+
+func TestDeleteUserDropsItsRefreshTokens(t *testing.T) {
+   eachStore(t, func(t *testing.T, s db.Store) {
+      s.CreateUser("1", models.User{Username: "ayush"})
+      s.AddRefreshToken("a", db.RefreshToken{UUID: "1", Family: "a", Expires: time.Now().Add(time.Hour)})
+      
+      s.DeleteUser("1")
+      if _, err := s.FetchRefreshToken("a"); err != db.ErrRefreshTokenNotFound {
+         t.Fatalf("FetchRefreshToken = %v, want ErrRefreshTokenNotFound", err)
+      }
+   })
+}
+
+func TestListAndRevokeUserRefreshTokens(t *testing.T) {
+   eachStore(t, func(t *testing.T, s db.Store) {
+      expires := time.Now().Add(time.Hour)
+      s.AddRefreshToken("a", db.RefreshToken{UUID: "1", Family: "a", Expires: expires, Client: db.Client{Device: "phone", IP: "10.0.0.1"}})
+      s.AddRefreshToken("b", db.RefreshToken{UUID: "1", Family: "b", Expires: expires})
+      s.AddRefreshToken("c", db.RefreshToken{UUID: "2", Family: "c", Expires: expires})
+      s.UseRefreshToken("b")
+      
+      tokens, err := s.ListRefreshTokens("1")
+      if err != nil || len(tokens) != 1 || tokens["a"].Device != "phone" || tokens["a"].IP != "10.0.0.1" {
+         t.Fatalf("ListRefreshTokens = %+v, %v; want only the live token a", tokens, err)
+      }
+      
+      if err := s.RevokeUserRefreshTokens("1"); err != nil {
+         t.Fatalf("RevokeUserRefreshTokens: %v", err)
+      }
+      for _, jti := range []string{"a", "b"} {
+         if _, err := s.FetchRefreshToken(jti); err != db.ErrRefreshTokenNotFound {
+            t.Fatalf("token %s after revoking = %v, want ErrRefreshTokenNotFound", jti, err)
+         }
+      }
+      if _, err := s.FetchRefreshToken("c"); err != nil {
+         t.Fatalf("token of another user: %v", err)
+      }
+   })
+}
+
+func TestFamilyActive(t *testing.T) {
+   eachStore(t, func(t *testing.T, s db.Store) {
+      expires := time.Now().Add(time.Hour)
+      s.AddRefreshToken("a", db.RefreshToken{UUID: "1", Family: "a", Expires: expires})
+      s.AddRefreshToken("b", db.RefreshToken{UUID: "1", Family: "b", Expires: expires})
+      s.AddRefreshToken("old", db.RefreshToken{UUID: "1", Family: "old", Expires: time.Now().Add(-time.Second)})
+      
+      if active, err := s.FamilyActive("1", "a"); err != nil || !active {
+         t.Fatalf("FamilyActive = %v, %v; want true", active, err)
+      }
+      // Until the successor of a spent token is stored, the family is only
+      // left with the spent one.
+      s.UseRefreshToken("a")
+      if active, _ := s.FamilyActive("1", "a"); !active {
+         t.Fatal("family was inactive while its next token was being issued")
+      }
+      s.AddRefreshToken("a2", db.RefreshToken{UUID: "1", Family: "a", Expires: expires})
+      if active, _ := s.FamilyActive("1", "a"); !active {
+         t.Fatal("family was inactive after rotating")
+      }
+      
+      s.RevokeRefreshTokenFamily("a2")
+      for family, want := range map[string]bool{"a": false, "b": true, "old": false, "unknown": false} {
+         if active, _ := s.FamilyActive("1", family); active != want {
+            t.Errorf("FamilyActive(%q) = %v, want %v", family, active, want)
+         }
+      }
+      if active, _ := s.FamilyActive("2", "b"); active {
+         t.Error("family of another user was active")
+      }
+   })
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+   path := filepath.Join(t.TempDir(), "db.json")
+   s, err := db.OpenFileStore(path)
+   if err != nil {
+      t.Fatalf("OpenFileStore: %v", err)
+   }
+   
+   expires := time.Now().Add(time.Hour)
+   s.CreateUser("1", models.User{Username: "ayush", PasswordHash: "hash", Role: "user"})
+   s.AddRefreshToken("a", db.RefreshToken{UUID: "1", Family: "a", Expires: expires})
+   s.AddRefreshToken("b", db.RefreshToken{UUID: "1", Family: "b", Expires: expires})
+   s.UseRefreshToken("b")
+   
+   s, err = db.OpenFileStore(path)
+   if err != nil {
+      t.Fatalf("OpenFileStore: %v", err)
+   }
+   if user, _, err := s.FetchUserByUsername("ayush"); err != nil || user.PasswordHash != "hash" {
+      t.Fatalf("FetchUserByUsername after restart = %+v, %v", user, err)
+   }
+   if token, err := s.FetchRefreshToken("a"); err != nil || token.Spent {
+      t.Fatalf("FetchRefreshToken after restart = %+v, %v", token, err)
+   }
+   if active, err := s.FamilyActive("1", "a"); err != nil || !active {
+      t.Fatalf("FamilyActive after restart = %v, %v", active, err)
+   }
+   if _, err := s.UseRefreshToken("b"); err != db.ErrRefreshTokenReused {
+      t.Fatalf("spent token after restart = %v, want ErrRefreshTokenReused", err)
+   }
+   if active, _ := s.FamilyActive("1", "b"); active {
+      t.Fatal("family revoked after restart is still active")
+   }
+}
+
+func TestLogUserIn(t *testing.T) {
+   db.InitDB(db.NewMemoryStore())
+   
+   uuid, err := db.StoreUser("ayush", "correct horse", "user")
+   if err != nil {
+      t.Fatalf("StoreUser: %v", err)
+   }
+   if _, got, err := db.LogUserIn("ayush", "correct horse"); err != nil || got != uuid {
+      t.Fatalf("LogUserIn = %q, %v", got, err)
+   }
+   if _, _, err := db.LogUserIn("ayush", "wrong"); err == nil {
+      t.Fatal("LogUserIn accepted a wrong password")
+   }
+}
@@ -3,7 +3,9 @@ module secure-api-project
 go 1.17
 
 require (
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/justinas/alice v1.2.0
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 )
+
+require golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
@@ -1,29 +1,106 @@
 package main
 
 import (
+   "fmt"
    "log"
+   "os"
+   "strconv"
+   "strings"
+   "time"
    "secure-api-project/db"
+   "secure-api-project/db/models"
+   "secure-api-project/middlewares"
    "secure-api-project/server"
-   "secure-api-project/myJWT"
+   "secure-api-project/myJwt"
 )
 
 const (
    HOST = "localhost"
    PORT = "8000"
+   STORE_PATH = "data/store.json"
 )
 
 func main() {
-   db.InitDB()
-   jwtError := myJWT.InitJWT()
+   store, storeError := db.OpenFileStore(STORE_PATH)
+   if storeError != nil {
+      log.Println("Error opening the store!")
+      log.Fatal(storeError)
+   }
+   db.InitDB(store)
+   
+   passwordConfig, configError := passwordConfigFromEnv()
+   if configError != nil {
+      log.Fatal(configError)
+   }
+   hasher, hasherError := db.NewPasswordHasher(passwordConfig)
+   if hasherError != nil {
+      log.Fatal(hasherError)
+   }
+   db.SetPasswordHasher(hasher)
+   
+   // Someone has to be able to hand out roles to begin with.
+   if admin := os.Getenv("ADMIN_USERNAME"); admin != "" {
+      if err := db.SetUserRole(admin, models.RoleAdmin); err != nil {
+         log.Printf("Could not make %s an admin: %v", admin, err)
+      }
+   }
+   
+   jwtError := myJWT.InitJWT(myJWT.Config{
+      Algorithm: os.Getenv("JWT_ALGORITHM"),
+      KeyDir: "keys",
+      RotateEvery: 30 * 24 * time.Hour,
+   })
    
    if jwtError != nil {
       log.Println("Error initializing JWT")
       log.Fatal(jwtError)
    }
    
-   serverError := server.StartServer(HOST, PORT)
+   config := middleware.Config{Cookies: middleware.DevelopmentCookies}
+   if os.Getenv("APP_ENV") == "production" {
+      config.Cookies = middleware.ProductionCookies
+   }
+   if os.Getenv("CSRF_MODE") == "double-submit" {
+      config.CSRF = middleware.CSRFDoubleSubmit
+      config.CSRFKey = []byte(os.Getenv("CSRF_KEY"))
+   }
+   if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+      config.CORS.AllowedOrigins = strings.Split(origins, ",")
+      config.CORS.AllowCredentials = true
+   }
+   
+   serverError := server.StartServer(HOST, PORT, config)
    if serverError != nil {
       log.Println("Error starting server!")
       log.Fatal(serverError)
    }
-}
\ No newline at end of file
+}
+
+// passwordConfigFromEnv reads the password hashing policy: PASSWORD_HASH
+// picks the algorithm, BCRYPT_COST or ARGON2_TIME, ARGON2_MEMORY_KIB and
+// ARGON2_THREADS its cost. Unset ones keep their defaults.
+func passwordConfigFromEnv() (db.PasswordConfig, error) {
+   config := db.PasswordConfig{Algorithm: os.Getenv("PASSWORD_HASH")}
+   settings := []struct {
+      name string
+      bits int
+      set  func(uint64)
+   }{
+      {"BCRYPT_COST", 8, func(n uint64) { config.BcryptCost = int(n) }},
+      {"ARGON2_TIME", 32, func(n uint64) { config.Argon2Time = uint32(n) }},
+      {"ARGON2_MEMORY_KIB", 32, func(n uint64) { config.Argon2Memory = uint32(n) }},
+      {"ARGON2_THREADS", 8, func(n uint64) { config.Argon2Threads = uint8(n) }},
+   }
+   for _, setting := range settings {
+      value := os.Getenv(setting.name)
+      if value == "" {
+         continue
+      }
+      n, err := strconv.ParseUint(value, 10, setting.bits)
+      if err != nil {
+         return config, fmt.Errorf("%s has to be a number, not %q!", setting.name, value)
+      }
+      setting.set(n)
+   }
+   return config, nil
+}
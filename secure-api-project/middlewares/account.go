@@ -0,0 +1,153 @@
+package middleware
+
+import (
+   "encoding/json"
+   "errors"
+   "log"
+   "math"
+   "net/http"
+   "sort"
+   "strconv"
+   "time"
+   "secure-api-project/db"
+)
+
+// Users manage their own account and sessions here. A session is a refresh
+// token family; it's listed under the JTI of its live refresh token, which
+// is also what revokes it. Auth tokens of a revoked session stop working
+// right away, see activeSession.
+
+type sessionInfo struct {
+   ID       string    `json:"id"`
+   Device   string    `json:"device"`
+   IP       string    `json:"ip"`
+   Started  time.Time `json:"started"`
+   LastUsed time.Time `json:"last_used"`
+   Expires  time.Time `json:"expires"`
+   Current  bool      `json:"current"`
+}
+
+// clientOf says where a request comes from, to tell sessions apart.
+func clientOf(req *http.Request) db.Client {
+   device := req.UserAgent()
+   if len(device) > 256 {
+      device = device[:256]
+   }
+   return db.Client{Device: device, IP: clientIP(req)}
+}
+
+// activeSession is false once the session of an auth token was revoked.
+// Tokens issued before sessions were named in them are let through.
+func activeSession(subject, session string) bool {
+   return session == "" || db.SessionActive(subject, session)
+}
+
+// listSessions handles GET /sessions.
+func listSessions(res http.ResponseWriter, req *http.Request) {
+   claims := claimsFrom(req)
+   tokens, err := db.ListSessions(claims.Subject)
+   if err != nil {
+      log.Printf("Error listing sessions: %+v", err)
+      http.Error(res, http.StatusText(500), 500)
+      return
+   }
+   
+   sessions := []sessionInfo{}
+   for jti, token := range tokens {
+      sessions = append(sessions, sessionInfo{
+         ID: jti,
+         Device: token.Device,
+         IP: token.IP,
+         Started: token.Started,
+         LastUsed: token.Issued,
+         Expires: token.Expires,
+         Current: token.Family == claims.Session,
+      })
+   }
+   sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastUsed.After(sessions[j].LastUsed) })
+   writeJSON(res, http.StatusOK, sessions)
+}
+
+// revokeSession handles POST /sessions/revoke, {"id": ...}.
+func revokeSession(res http.ResponseWriter, req *http.Request) {
+   var body struct {
+      ID string `json:"id"`
+   }
+   if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.ID == "" {
+      http.Error(res, http.StatusText(400), 400)
+      return
+   }
+   
+   claims := claimsFrom(req)
+   family, err := db.RevokeSession(claims.Subject, body.ID)
+   if errors.Is(err, db.ErrRefreshTokenNotFound) {
+      http.Error(res, http.StatusText(404), 404)
+      return
+   } else if err != nil {
+      log.Printf("Error revoking a session: %+v", err)
+      http.Error(res, http.StatusText(500), 500)
+      return
+   }
+   
+   if family == claims.Session {
+      loggedOut(res, req)
+      return
+   }
+   res.WriteHeader(http.StatusNoContent)
+}
+
+// logOutEverywhere handles POST /sessions/revoke-all.
+func logOutEverywhere(res http.ResponseWriter, req *http.Request) {
+   claims := claimsFrom(req)
+   if err := db.RevokeAllSessions(claims.Subject); err != nil {
+      log.Printf("Error revoking sessions: %+v", err)
+      http.Error(res, http.StatusText(500), 500)
+      return
+   }
+   log.Printf("%s logged out everywhere", claims.Subject)
+   loggedOut(res, req)
+}
+
+// deleteAccount handles POST /deleteUser. The password has to be confirmed
+// in the "password" form field, and is throttled like a login.
+func deleteAccount(res http.ResponseWriter, req *http.Request) {
+   claims := claimsFrom(req)
+   user, err := db.FetchUserByID(claims.Subject)
+   if err != nil {
+      log.Printf("Error fetching the account of %s: %+v", claims.Subject, err)
+      http.Error(res, http.StatusText(404), 404)
+      return
+   }
+   
+   _, _, wait, err := authenticate(req, user.Username, req.FormValue("password"))
+   if wait > 0 {
+      res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+      http.Error(res, http.StatusText(429), 429)
+      return
+   } else if err == errInvalidCredentials {
+      http.Error(res, http.StatusText(401), 401)
+      return
+   } else if err != nil {
+      http.Error(res, http.StatusText(500), 500)
+      return
+   }
+   
+   if err := db.DeleteUser(claims.Subject); err != nil {
+      log.Printf("Error deleting the account of %s: %+v", claims.Subject, err)
+      http.Error(res, http.StatusText(500), 500)
+      return
+   }
+   log.Printf("Deleted the account of %s", claims.Subject)
+   loggedOut(res, req)
+}
+
+// loggedOut answers a request whose own session just ended. Browsers lose
+// their cookies and are sent to log in.
+func loggedOut(res http.ResponseWriter, req *http.Request) {
+   if _, bearer := bearerToken(req); bearer {
+      res.WriteHeader(http.StatusNoContent)
+      return
+   }
+   expireTokenCookies(res)
+   http.Redirect(res, req, "/login", 302)
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+   "encoding/json"
+   "net/http"
+   "net/http/httptest"
+   "net/url"
+   "strings"
+   "testing"
+   "secure-api-project/db"
+   "secure-api-project/db/models"
+)
+
+// logInFrom logs the user in from another device.
+func logInFrom(t *testing.T, handler http.Handler, device, remoteAddr string) session {
+   form := url.Values{"username": {"ayush"}, "password": {"correct horse"}}
+   req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+   req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+   req.Header.Set("User-Agent", device)
+   req.RemoteAddr = remoteAddr
+   res := httptest.NewRecorder()
+   handler.ServeHTTP(res, req)
+   if res.Code != http.StatusFound {
+      t.Fatalf("login from %s = %d", device, res.Code)
+   }
+   return session{cookies: res.Result().Cookies(), csrf: res.Header().Get("X-CSRF-Token")}
+}
+
+func listedSessions(t *testing.T, handler http.Handler, s session) []sessionInfo {
+   res := s.serve(handler, "GET", "/sessions", "")
+   if res.Code != http.StatusOK {
+      t.Fatalf("GET /sessions = %d", res.Code)
+   }
+   var sessions []sessionInfo
+   if err := json.Unmarshal(res.Body.Bytes(), &sessions); err != nil {
+      t.Fatalf("sessions: %v", err)
+   }
+   return sessions
+}
+
+func TestSessions(t *testing.T) {
+   handler := setup(t)
+   db.StoreUser("ayush", "correct horse", models.RoleUser)
+   laptop := logInFrom(t, handler, "laptop", "10.0.0.1:1234")
+   phone := logInFrom(t, handler, "phone", "10.0.0.2:1234")
+   
+   sessions := listedSessions(t, handler, laptop)
+   if len(sessions) != 2 {
+      t.Fatalf("sessions = %+v, want two", sessions)
+   }
+   var phoneSession sessionInfo
+   for _, s := range sessions {
+      if s.Device == "phone" {
+         phoneSession = s
+      }
+      if s.Current != (s.Device == "laptop") || s.Started.IsZero() {
+         t.Fatalf("session = %+v", s)
+      }
+   }
+   if phoneSession.IP != "10.0.0.2" {
+      t.Fatalf("session of the phone = %+v", phoneSession)
+   }
+   
+   body := `{"id": "` + phoneSession.ID + `"}`
+   if res := laptop.serve(handler, "POST", "/sessions/revoke", body); res.Code != http.StatusNoContent {
+      t.Fatalf("revoking the phone = %d, want 204", res.Code)
+   }
+   // Its auth token goes with it, not only its refresh token.
+   if res := phone.serve(handler, "GET", "/restricted", ""); res.Code != http.StatusFound {
+      t.Fatalf("dashboard of a revoked session = %d, want a redirect", res.Code)
+   }
+   if res := laptop.serve(handler, "GET", "/restricted", ""); res.Code != http.StatusOK {
+      t.Fatalf("dashboard of the other session = %d", res.Code)
+   }
+   if res := laptop.serve(handler, "POST", "/sessions/revoke", `{"id": "nope"}`); res.Code != http.StatusNotFound {
+      t.Fatalf("revoking an unknown session = %d, want 404", res.Code)
+   }
+}
+
+func TestSessionsOfOthersCantBeRevoked(t *testing.T) {
+   handler := setup(t)
+   admin := logInAs(t, handler, "alkesh", models.RoleAdmin)
+   db.StoreUser("ayush", "correct horse", models.RoleUser)
+   user := logInFrom(t, handler, "phone", "10.0.0.2:1234")
+   
+   id := listedSessions(t, handler, user)[0].ID
+   if res := admin.serve(handler, "POST", "/sessions/revoke", `{"id": "` + id + `"}`); res.Code != http.StatusNotFound {
+      t.Fatalf("revoking the session of another user = %d, want 404", res.Code)
+   }
+}
+
+func TestLogOutEverywhere(t *testing.T) {
+   handler := setup(t)
+   db.StoreUser("ayush", "correct horse", models.RoleUser)
+   laptop := logInFrom(t, handler, "laptop", "10.0.0.1:1234")
+   phone := logInFrom(t, handler, "phone", "10.0.0.2:1234")
+   
+   res := laptop.serve(handler, "POST", "/sessions/revoke-all", "")
+   if res.Code != http.StatusFound || res.Header().Get("Location") != "/login" {
+      t.Fatalf("log out everywhere = %d, Location %q", res.Code, res.Header().Get("Location"))
+   }
+   for _, s := range []session{laptop, phone} {
+      if res := s.serve(handler, "GET", "/restricted", ""); res.Code != http.StatusFound {
+         t.Fatalf("dashboard after logging out everywhere = %d, want a redirect", res.Code)
+      }
+      if res := serve(handler, "POST", "/auth/refresh", "", s.cookies); res.Code != http.StatusUnauthorized {
+         t.Fatalf("refresh after logging out everywhere = %d, want 401", res.Code)
+      }
+   }
+}
+
+func TestDeleteAccount(t *testing.T) {
+   handler := setup(t)
+   user := logInAs(t, handler, "ayush", models.RoleUser)
+   deleteWith := func(password string) *httptest.ResponseRecorder {
+      form := url.Values{"password": {password}, "X-CSRF-Token": {user.csrf}}
+      req := httptest.NewRequest("POST", "/deleteUser", strings.NewReader(form.Encode()))
+      req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+      for _, c := range user.cookies {
+         req.AddCookie(c)
+      }
+      res := httptest.NewRecorder()
+      handler.ServeHTTP(res, req)
+      return res
+   }
+   
+   if res := deleteWith("wrong"); res.Code != http.StatusUnauthorized {
+      t.Fatalf("deletion with a wrong password = %d, want 401", res.Code)
+   }
+   if _, _, err := db.FetchUserByUsername("ayush"); err != nil {
+      t.Fatalf("account is gone after a wrong password: %v", err)
+   }
+   
+   accountThrottle.reset("account:ayush")
+   res := deleteWith("correct horse")
+   if res.Code != http.StatusFound || res.Header().Get("Location") != "/login" {
+      t.Fatalf("deletion = %d, Location %q", res.Code, res.Header().Get("Location"))
+   }
+   if c := cookieNamed(res.Result().Cookies(), "AuthToken"); c == nil || c.MaxAge >= 0 {
+      t.Fatalf("deletion should clear the auth cookie, got %+v", c)
+   }
+   if _, _, err := db.FetchUserByUsername("ayush"); err != db.ErrUserNotFound {
+      t.Fatalf("FetchUserByUsername after deletion = %v, want ErrUserNotFound", err)
+   }
+   if res := user.serve(handler, "GET", "/restricted", ""); res.Code != http.StatusFound {
+      t.Fatalf("dashboard after deletion = %d, want a redirect", res.Code)
+   }
+   if res := serve(handler, "POST", "/auth/refresh", "", user.cookies); res.Code != http.StatusUnauthorized {
+      t.Fatalf("refresh after deletion = %d, want 401", res.Code)
+   }
+}
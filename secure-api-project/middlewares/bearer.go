@@ -0,0 +1,106 @@
+package middleware
+
+import (
+   "encoding/json"
+   "math"
+   "net/http"
+   "strconv"
+   "strings"
+   "secure-api-project/db/models"
+   "secure-api-project/myJwt"
+)
+
+// Clients that can't keep cookies, like our mobile apps and the CLI, get
+// their tokens from /token and /token/refresh and send the access token as
+// "Authorization: Bearer <token>". Such requests can't be forged by another
+// site, so they skip the CSRF checks. They don't refresh on the fly either:
+// an expired access token gets a 401 and the client calls /token/refresh.
+
+type tokenResponse struct {
+   AccessToken  string `json:"access_token"`
+   RefreshToken string `json:"refresh_token"`
+   TokenType    string `json:"token_type"`
+   ExpiresIn    int    `json:"expires_in"`
+}
+
+// bearerToken returns the token of an Authorization: Bearer header.
+func bearerToken(req *http.Request) (string, bool) {
+   header := req.Header.Get("Authorization")
+   if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+      return "", false
+   }
+   return strings.TrimSpace(header[7:]), true
+}
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+   res.Header().Set("Content-Type", "application/json")
+   res.Header().Set("Cache-Control", "no-store")
+   res.WriteHeader(status)
+   json.NewEncoder(res).Encode(v)
+}
+
+func writeTokenError(res http.ResponseWriter, status int, code string) {
+   writeJSON(res, status, map[string]string{"error": code})
+}
+
+func writeTokens(res http.ResponseWriter, authToken, refreshToken string) {
+   writeJSON(res, http.StatusOK, tokenResponse{
+      AccessToken: authToken,
+      RefreshToken: refreshToken,
+      TokenType: "Bearer",
+      ExpiresIn: int(models.AuthTokenValidTime.Seconds()),
+   })
+}
+
+// issueToken handles POST /token, {"username": ..., "password": ...}.
+func issueToken(res http.ResponseWriter, req *http.Request) {
+   var body struct {
+      Username string `json:"username"`
+      Password string `json:"password"`
+   }
+   if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+      writeTokenError(res, http.StatusBadRequest, "invalid_request")
+      return
+   }
+   
+   user, uuid, wait, err := authenticate(req, body.Username, body.Password)
+   if wait > 0 {
+      res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+      writeTokenError(res, http.StatusTooManyRequests, "slow_down")
+      return
+   } else if err == errInvalidCredentials {
+      writeTokenError(res, http.StatusUnauthorized, "invalid_grant")
+      return
+   } else if err != nil {
+      writeTokenError(res, http.StatusInternalServerError, "server_error")
+      return
+   }
+   
+   authToken, refreshToken, _, err := myJWT.CreateNewTokens(uuid, user.Role, clientOf(req))
+   if err != nil {
+      writeTokenError(res, http.StatusInternalServerError, "server_error")
+      return
+   }
+   writeTokens(res, authToken, refreshToken)
+}
+
+// refreshBearerToken handles POST /token/refresh, {"refresh_token": ...}.
+func refreshBearerToken(res http.ResponseWriter, req *http.Request) {
+   var body struct {
+      RefreshToken string `json:"refresh_token"`
+   }
+   if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+      writeTokenError(res, http.StatusBadRequest, "invalid_request")
+      return
+   }
+   
+   authToken, refreshToken, _, err := myJWT.ExchangeRefreshToken(body.RefreshToken, clientOf(req))
+   if err == myJWT.ErrUnauthorized {
+      writeTokenError(res, http.StatusUnauthorized, "invalid_grant")
+      return
+   } else if err != nil {
+      writeTokenError(res, http.StatusInternalServerError, "server_error")
+      return
+   }
+   writeTokens(res, authToken, refreshToken)
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+   "encoding/json"
+   "net/http"
+   "net/http/httptest"
+   "strings"
+   "testing"
+   "secure-api-project/db"
+   "secure-api-project/db/models"
+)
+
+func postJSON(handler http.Handler, path, body string) (*httptest.ResponseRecorder, tokenResponse) {
+   req := httptest.NewRequest("POST", path, strings.NewReader(body))
+   req.Header.Set("Content-Type", "application/json")
+   req.RemoteAddr = "10.0.0.1:1234"
+   res := httptest.NewRecorder()
+   handler.ServeHTTP(res, req)
+   
+   var tokens tokenResponse
+   json.Unmarshal(res.Body.Bytes(), &tokens)
+   return res, tokens
+}
+
+func withBearer(handler http.Handler, method, path, token string) *httptest.ResponseRecorder {
+   req := httptest.NewRequest(method, path, nil)
+   req.Header.Set("Authorization", "Bearer " + token)
+   res := httptest.NewRecorder()
+   handler.ServeHTTP(res, req)
+   return res
+}
+
+func TestBearerTokens(t *testing.T) {
+   handler := setup(t)
+   db.StoreUser("ayush", "correct horse", models.RoleUser)
+   
+   res, tokens := postJSON(handler, "/token", `{"username": "ayush", "password": "correct horse"}`)
+   if res.Code != http.StatusOK || tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" {
+      t.Fatalf("/token = %d, %s", res.Code, res.Body)
+   }
+   
+   res = withBearer(handler, "GET", "/restricted", tokens.AccessToken)
+   if res.Code != http.StatusOK {
+      t.Fatalf("bearer request = %d", res.Code)
+   }
+   if len(res.Result().Cookies()) != 0 {
+      t.Fatal("a bearer request should not get cookies")
+   }
+   if res := withBearer(handler, "POST", "/admin/roles", tokens.AccessToken); res.Code != http.StatusForbidden {
+      t.Fatalf("bearer request of a user to an admin route = %d, want 403", res.Code)
+   }
+   
+   // The refresh token is signed by us too, but isn't an access token.
+   if res := withBearer(handler, "GET", "/restricted", tokens.RefreshToken); res.Code != http.StatusUnauthorized {
+      t.Fatalf("refresh token used as a bearer token = %d, want 401", res.Code)
+   }
+   
+   res = withBearer(handler, "GET", "/restricted", "garbage")
+   if res.Code != http.StatusUnauthorized || !strings.Contains(res.Header().Get("WWW-Authenticate"), "invalid_token") {
+      t.Fatalf("bad bearer token = %d, WWW-Authenticate %q", res.Code, res.Header().Get("WWW-Authenticate"))
+   }
+   
+   res, refreshed := postJSON(handler, "/token/refresh", `{"refresh_token": "` + tokens.RefreshToken + `"}`)
+   if res.Code != http.StatusOK || refreshed.RefreshToken == tokens.RefreshToken {
+      t.Fatalf("/token/refresh = %d, %s", res.Code, res.Body)
+   }
+   if res := withBearer(handler, "GET", "/restricted", refreshed.AccessToken); res.Code != http.StatusOK {
+      t.Fatalf("request with the refreshed token = %d", res.Code)
+   }
+   if res, _ := postJSON(handler, "/token/refresh", `{"refresh_token": "` + refreshed.RefreshToken + `"}`); res.Code != http.StatusOK {
+      t.Fatalf("second /token/refresh = %d, %s", res.Code, res.Body)
+   }
+   if res, _ := postJSON(handler, "/token/refresh", `{"refresh_token": "` + tokens.RefreshToken + `"}`); res.Code != http.StatusUnauthorized {
+      t.Fatalf("replayed refresh token = %d, want 401", res.Code)
+   }
+}
+
+func TestTokenRejectsBadCredentials(t *testing.T) {
+   handler := setup(t)
+   db.StoreUser("ayush", "correct horse", models.RoleUser)
+   
+   res, _ := postJSON(handler, "/token", `{"username": "ayush", "password": "wrong"}`)
+   if res.Code != http.StatusUnauthorized || !strings.Contains(res.Body.String(), "invalid_grant") {
+      t.Fatalf("/token with a wrong password = %d, %s", res.Code, res.Body)
+   }
+   res, _ = postJSON(handler, "/token", `{"username": "ayush", "password": "correct horse"}`)
+   if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") == "" {
+      t.Fatalf("/token during backoff = %d", res.Code)
+   }
+}
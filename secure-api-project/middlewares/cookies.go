@@ -0,0 +1,191 @@
+package middleware
+
+import (
+   "errors"
+   "log"
+   "net/http"
+   "net/url"
+   "strings"
+   "time"
+   "secure-api-project/db/models"
+   "secure-api-project/myJwt"
+)
+
+// CookiePolicy says how the token cookies are set. Both are HttpOnly and
+// expire with their tokens.
+type CookiePolicy struct {
+   Secure   bool
+   SameSite http.SameSite
+   // Domain shares the cookies with subdomains. It can't be used with
+   // HostPrefix.
+   Domain string
+   // HostPrefix names the cookies __Host-AuthToken, __Host-CsrfToken and
+   // __Secure-RefreshToken. Browsers only accept them over HTTPS, and the
+   // auth cookie only for this exact host. The refresh cookie can't be a
+   // __Host- one, since it isn't sent to every path.
+   HostPrefix bool
+}
+
+var (
+   DevelopmentCookies = CookiePolicy{SameSite: http.SameSiteLaxMode}
+   ProductionCookies  = CookiePolicy{Secure: true, SameSite: http.SameSiteLaxMode, HostPrefix: true}
+)
+
+// refreshPath is the only path the refresh cookie is sent to, so it doesn't
+// travel with every request. Everything that needs it lives under it.
+const refreshPath = "/auth"
+
+var cookiePolicy = DevelopmentCookies
+
+func (p CookiePolicy) validate() error {
+   if p.HostPrefix && (!p.Secure || p.Domain != "") {
+      return errors.New("Prefixed cookies have to be Secure and can't have a Domain!")
+   }
+   return nil
+}
+
+func (p CookiePolicy) authCookieName() string {
+   if p.HostPrefix {
+      return "__Host-AuthToken"
+   }
+   return "AuthToken"
+}
+
+func (p CookiePolicy) refreshCookieName() string {
+   if p.HostPrefix {
+      return "__Secure-RefreshToken"
+   }
+   return "RefreshToken"
+}
+
+func (p CookiePolicy) csrfCookieName() string {
+   if p.HostPrefix {
+      return "__Host-CsrfToken"
+   }
+   return "CsrfToken"
+}
+
+func (p CookiePolicy) cookie(name, value, path string, lifetime time.Duration) *http.Cookie {
+   return &http.Cookie{
+      Name: name,
+      Value: value,
+      Path: path,
+      Domain: p.Domain,
+      Expires: time.Now().Add(lifetime),
+      MaxAge: int(lifetime.Seconds()),
+      Secure: p.Secure,
+      HttpOnly: true,
+      SameSite: p.SameSite,
+   }
+}
+
+func (p CookiePolicy) expired(name, path string) *http.Cookie {
+   c := p.cookie(name, "", path, 0)
+   c.Expires = time.Unix(0, 0)
+   c.MaxAge = -1
+   return c
+}
+
+func expireTokenCookies(res http.ResponseWriter) {
+   http.SetCookie(res, cookiePolicy.expired(cookiePolicy.authCookieName(), "/"))
+   http.SetCookie(res, cookiePolicy.expired(cookiePolicy.refreshCookieName(), refreshPath))
+   if csrfMode == CSRFDoubleSubmit {
+      http.SetCookie(res, cookiePolicy.expired(cookiePolicy.csrfCookieName(), "/"))
+   }
+}
+
+func nullifyTokenCookies(res *http.ResponseWriter, req *http.Request) {
+   expireTokenCookies(*res)
+   
+   // The refresh cookie only comes along under refreshPath.
+   oldRefreshCookie, refreshErr := req.Cookie(cookiePolicy.refreshCookieName())
+   if refreshErr == http.ErrNoCookie {
+      return
+   } else if refreshErr != nil {
+      log.Printf("Error %+v", refreshErr)
+      return
+   }
+   
+   myJWT.RevokeRefreshToken(oldRefreshCookie.Value)
+}
+
+// setAuthAndRefreshCookies starts a session. Its CSRF token goes along in
+// the X-CSRF-Token header, and in a cookie scripts can read for
+// CSRFDoubleSubmit.
+func setAuthAndRefreshCookies(res *http.ResponseWriter, authTokenString string, refreshTokenString string) error {
+   claims, err := myJWT.ParseAuthToken(authTokenString)
+   if err != nil {
+      return err
+   }
+   csrf := csrfToken(claims)
+   
+   http.SetCookie(*res, cookiePolicy.cookie(cookiePolicy.authCookieName(), authTokenString, "/", models.AuthTokenValidTime))
+   http.SetCookie(*res, cookiePolicy.cookie(cookiePolicy.refreshCookieName(), refreshTokenString, refreshPath, models.RefreshTokenValidTime))
+   if csrfMode == CSRFDoubleSubmit {
+      csrfCookie := cookiePolicy.cookie(cookiePolicy.csrfCookieName(), csrf, "/", models.AuthTokenValidTime)
+      csrfCookie.HttpOnly = false
+      http.SetCookie(*res, csrfCookie)
+   }
+   (*res).Header().Set("X-CSRF-Token", csrf)
+   return nil
+}
+
+// sessionExpired sends a browser without a valid auth token to refresh its
+// session, and back to where it was going afterwards.
+func sessionExpired(res http.ResponseWriter, req *http.Request) {
+   if req.Method != "GET" && req.Method != "HEAD" {
+      http.Error(res, http.StatusText(401), 401)
+      return
+   }
+   http.Redirect(res, req, refreshPath + "/refresh?next=" + url.QueryEscape(req.URL.RequestURI()), 302)
+}
+
+// refreshSession handles /auth/refresh: it spends the refresh cookie on new
+// tokens. A GET then redirects to the local path in next.
+func refreshSession(res http.ResponseWriter, req *http.Request) {
+   refreshCookie, err := req.Cookie(cookiePolicy.refreshCookieName())
+   if err != nil {
+      log.Println("Unauthorized attempt! No refresh cookie was found.")
+      sessionEnded(res, req)
+      return
+   }
+   
+   authTokenString, refreshTokenString, _, err := myJWT.ExchangeRefreshToken(refreshCookie.Value, clientOf(req))
+   if err == myJWT.ErrUnauthorized {
+      log.Println("Unauthorized attempt! Refresh token is not valid.")
+      sessionEnded(res, req)
+      return
+   } else if err != nil {
+      log.Printf("Error refreshing a session: %+v", err)
+      http.Error(res, http.StatusText(500), 500)
+      return
+   }
+   
+   log.Println("Successfully recreated JWT")
+   if err := setAuthAndRefreshCookies(&res, authTokenString, refreshTokenString); err != nil {
+      http.Error(res, http.StatusText(500), 500)
+      return
+   }
+   if req.Method == "GET" {
+      http.Redirect(res, req, localPath(req.FormValue("next")), 302)
+      return
+   }
+   res.WriteHeader(http.StatusNoContent)
+}
+
+func sessionEnded(res http.ResponseWriter, req *http.Request) {
+   expireTokenCookies(res)
+   if req.Method == "GET" {
+      http.Redirect(res, req, "/login", 302)
+      return
+   }
+   http.Error(res, http.StatusText(401), 401)
+}
+
+// localPath keeps redirects on this site.
+func localPath(next string) string {
+   if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+      return "/restricted"
+   }
+   return next
+}
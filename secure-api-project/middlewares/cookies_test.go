@@ -0,0 +1,128 @@
+package middleware
+
+import (
+   "net/http"
+   "testing"
+   "secure-api-project/db"
+   "secure-api-project/db/models"
+)
+
+func cookieNamed(cookies []*http.Cookie, name string) *http.Cookie {
+   for _, c := range cookies {
+      if c.Name == name {
+         return c
+      }
+   }
+   return nil
+}
+
+func TestProductionCookies(t *testing.T) {
+   setup(t)
+   handler, err := NewHandler(Config{Cookies: ProductionCookies})
+   if err != nil {
+      t.Fatalf("NewHandler: %v", err)
+   }
+   db.StoreUser("ayush", "correct horse", models.RoleUser)
+   
+   cookies := postLogin(handler, "ayush", "correct horse", "10.0.0.1:1234").Result().Cookies()
+   auth := cookieNamed(cookies, "__Host-AuthToken")
+   if auth == nil || !auth.Secure || !auth.HttpOnly || auth.Path != "/" || auth.Domain != "" || auth.SameSite != http.SameSiteLaxMode {
+      t.Fatalf("auth cookie = %+v", auth)
+   }
+   if auth.MaxAge != int(models.AuthTokenValidTime.Seconds()) {
+      t.Fatalf("auth cookie MaxAge = %d, want the token lifetime", auth.MaxAge)
+   }
+   refresh := cookieNamed(cookies, "__Secure-RefreshToken")
+   if refresh == nil || !refresh.Secure || refresh.Path != "/auth" || refresh.MaxAge != int(models.RefreshTokenValidTime.Seconds()) {
+      t.Fatalf("refresh cookie = %+v", refresh)
+   }
+   
+   if _, err := NewHandler(Config{Cookies: CookiePolicy{HostPrefix: true}}); err == nil {
+      t.Fatal("NewHandler accepted prefixed cookies that aren't Secure")
+   }
+}
+
+func TestRefreshSession(t *testing.T) {
+   handler := setup(t)
+   db.StoreUser("ayush", "correct horse", models.RoleUser)
+   cookies := postLogin(handler, "ayush", "correct horse", "10.0.0.1:1234").Result().Cookies()
+   refresh := cookieNamed(cookies, "RefreshToken")
+   
+   res := serve(handler, "GET", "/auth/refresh?next=%2Frestricted", "", []*http.Cookie{refresh})
+   if res.Code != http.StatusFound || res.Header().Get("Location") != "/restricted" {
+      t.Fatalf("refresh = %d, Location %q", res.Code, res.Header().Get("Location"))
+   }
+   renewed := res.Result().Cookies()
+   if cookieNamed(renewed, "AuthToken") == nil || cookieNamed(renewed, "RefreshToken").Value == refresh.Value {
+      t.Fatal("refresh did not rotate the cookies")
+   }
+   if res := serve(handler, "GET", "/restricted", "", renewed); res.Code != http.StatusOK {
+      t.Fatalf("dashboard after refresh = %d", res.Code)
+   }
+   
+   // Another tab refreshing with the same cookie gets the same successor.
+   res = serve(handler, "POST", "/auth/refresh", "", []*http.Cookie{refresh})
+   if res.Code != http.StatusNoContent {
+      t.Fatalf("refresh from another tab = %d, want 204", res.Code)
+   }
+   
+   // Once the successor was spent too, the old cookie ends the session.
+   if res := serve(handler, "POST", "/auth/refresh", "", []*http.Cookie{cookieNamed(renewed, "RefreshToken")}); res.Code != http.StatusNoContent {
+      t.Fatalf("refresh with the renewed cookie = %d, want 204", res.Code)
+   }
+   res = serve(handler, "GET", "/auth/refresh", "", []*http.Cookie{refresh})
+   if res.Code != http.StatusFound || res.Header().Get("Location") != "/login" {
+      t.Fatalf("replayed refresh = %d, Location %q", res.Code, res.Header().Get("Location"))
+   }
+   if res := serve(handler, "POST", "/auth/refresh", "", nil); res.Code != http.StatusUnauthorized {
+      t.Fatalf("POST refresh without a cookie = %d, want 401", res.Code)
+   }
+}
+
+func TestLogoutRevokesRefreshToken(t *testing.T) {
+   handler := setup(t)
+   user := logInAs(t, handler, "ayush", models.RoleUser)
+   
+   res := user.serve(handler, "POST", "/auth/logout", "")
+   if c := cookieNamed(res.Result().Cookies(), "AuthToken"); c == nil || c.MaxAge >= 0 {
+      t.Fatalf("logout should clear the auth cookie, got %+v", c)
+   }
+   if res := serve(handler, "POST", "/auth/refresh", "", user.cookies); res.Code != http.StatusUnauthorized {
+      t.Fatalf("refresh after logout = %d, want 401", res.Code)
+   }
+}
+
+func TestLogoutNeedsPostAndCsrfToken(t *testing.T) {
+   handler := setup(t)
+   user := logInAs(t, handler, "ayush", models.RoleUser)
+   
+   if res := serve(handler, "GET", "/auth/logout", "", user.cookies); res.Code != http.StatusMethodNotAllowed {
+      t.Fatalf("GET logout = %d, want 405", res.Code)
+   }
+   if res := serve(handler, "POST", "/auth/logout", "", user.cookies); res.Code != http.StatusForbidden {
+      t.Fatalf("logout without a CSRF token = %d, want 403", res.Code)
+   }
+   forged := session{cookies: user.cookies, csrf: "forged"}
+   if res := forged.serve(handler, "POST", "/auth/logout", ""); res.Code != http.StatusForbidden {
+      t.Fatalf("logout with a wrong CSRF token = %d, want 403", res.Code)
+   }
+   
+   // The session survived all of them.
+   if res := user.serve(handler, "POST", "/auth/refresh", ""); res.Code != http.StatusNoContent {
+      t.Fatalf("refresh = %d, want 204", res.Code)
+   }
+}
+
+func TestLocalPath(t *testing.T) {
+   for next, want := range map[string]string{
+      "/restricted?tab=1": "/restricted?tab=1",
+      "": "/restricted",
+      "https://evil.example": "/restricted",
+      "//evil.example": "/restricted",
+      "/\\evil.example": "/restricted",
+   } {
+      if got := localPath(next); got != want {
+         t.Errorf("localPath(%q) = %q, want %q", next, got, want)
+      }
+   }
+}
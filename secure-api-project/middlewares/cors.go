@@ -0,0 +1,94 @@
+package middleware
+
+import (
+   "errors"
+   "net/http"
+   "strconv"
+   "strings"
+   "time"
+)
+
+// CORSPolicy says which other origins may call us from a browser. Requests
+// from anywhere else get no CORS headers, so browsers won't let those pages
+// read our responses.
+type CORSPolicy struct {
+   // AllowedOrigins are exact origins, like https://app.example.com.
+   AllowedOrigins []string
+   AllowedMethods []string
+   AllowedHeaders []string
+   // AllowCredentials lets the allowed origins send our cookies along.
+   AllowCredentials bool
+   // MaxAge is how long browsers may cache a preflight answer.
+   MaxAge time.Duration
+}
+
+// defaultCORSPolicy fills in what a policy leaves out. It allows no
+// origins.
+var defaultCORSPolicy = CORSPolicy{
+   AllowedMethods: []string{"GET", "POST"},
+   AllowedHeaders: []string{"Authorization", "Content-Type", "X-CSRF-Token"},
+   MaxAge: 10 * time.Minute,
+}
+
+var corsPolicy = defaultCORSPolicy
+
+func (p CORSPolicy) validate() error {
+   for _, origin := range p.AllowedOrigins {
+      if origin == "*" || origin == "null" {
+         return errors.New("CORS origins have to be listed one by one!")
+      }
+   }
+   return nil
+}
+
+func (p CORSPolicy) allowsOrigin(origin string) bool {
+   for _, allowed := range p.AllowedOrigins {
+      if allowed == origin {
+         return true
+      }
+   }
+   return false
+}
+
+func corsHandler(next http.Handler) http.Handler {
+   fn := func(res http.ResponseWriter, req *http.Request) {
+      origin := req.Header.Get("Origin")
+      if origin == "" {
+         next.ServeHTTP(res, req)
+         return
+      }
+      
+      header := res.Header()
+      header.Add("Vary", "Origin")
+      preflight := req.Method == "OPTIONS" && req.Header.Get("Access-Control-Request-Method") != ""
+      if !corsPolicy.allowsOrigin(origin) {
+         if preflight {
+            http.Error(res, http.StatusText(403), 403)
+            return
+         }
+         // Could be one of our own pages; same-origin requests need no
+         // CORS headers.
+         next.ServeHTTP(res, req)
+         return
+      }
+      
+      header.Set("Access-Control-Allow-Origin", origin)
+      if corsPolicy.AllowCredentials {
+         header.Set("Access-Control-Allow-Credentials", "true")
+      }
+      if preflight {
+         header.Add("Vary", "Access-Control-Request-Method")
+         header.Add("Vary", "Access-Control-Request-Headers")
+         header.Set("Access-Control-Allow-Methods", strings.Join(corsPolicy.AllowedMethods, ", "))
+         header.Set("Access-Control-Allow-Headers", strings.Join(corsPolicy.AllowedHeaders, ", "))
+         if corsPolicy.MaxAge > 0 {
+            header.Set("Access-Control-Max-Age", strconv.Itoa(int(corsPolicy.MaxAge.Seconds())))
+         }
+         res.WriteHeader(http.StatusNoContent)
+         return
+      }
+      header.Set("Access-Control-Expose-Headers", "X-CSRF-Token")
+      next.ServeHTTP(res, req)
+   }
+   return http.HandlerFunc(fn)
+}
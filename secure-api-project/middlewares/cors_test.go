@@ -0,0 +1,45 @@
+package middleware
+
+import (
+   "net/http"
+   "net/http/httptest"
+   "testing"
+)
+
+func TestCORS(t *testing.T) {
+   setup(t)
+   handler, _ := NewHandler(Config{Cookies: DevelopmentCookies, CORS: CORSPolicy{
+      AllowedOrigins: []string{"https://app.example.com"},
+      AllowCredentials: true,
+   }})
+   
+   preflight := func(origin string) *httptest.ResponseRecorder {
+      req := httptest.NewRequest("OPTIONS", "/token", nil)
+      req.Header.Set("Origin", origin)
+      req.Header.Set("Access-Control-Request-Method", "POST")
+      res := httptest.NewRecorder()
+      handler.ServeHTTP(res, req)
+      return res
+   }
+   
+   res := preflight("https://app.example.com")
+   if res.Code != http.StatusNoContent || res.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+      res.Header().Get("Access-Control-Allow-Credentials") != "true" || res.Header().Get("Access-Control-Allow-Methods") != "GET, POST" {
+      t.Fatalf("allowed preflight = %d, %v", res.Code, res.Header())
+   }
+   if res := preflight("https://evil.example"); res.Code != http.StatusForbidden || res.Header().Get("Access-Control-Allow-Origin") != "" {
+      t.Fatalf("preflight of another origin = %d, %v", res.Code, res.Header())
+   }
+   
+   req := httptest.NewRequest("GET", "/login", nil)
+   req.Header.Set("Origin", "https://evil.example")
+   res = httptest.NewRecorder()
+   handler.ServeHTTP(res, req)
+   if res.Code != http.StatusOK || res.Header().Get("Access-Control-Allow-Origin") != "" {
+      t.Fatalf("request of another origin = %d, %v", res.Code, res.Header())
+   }
+   
+   if _, err := NewHandler(Config{CORS: CORSPolicy{AllowedOrigins: []string{"*"}}}); err == nil {
+      t.Fatal("NewHandler accepted a wildcard origin")
+   }
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+   "crypto/hmac"
+   "crypto/rand"
+   "crypto/sha256"
+   "crypto/subtle"
+   "encoding/hex"
+   "errors"
+   "log"
+   "net/http"
+   "secure-api-project/db/models"
+   "secure-api-project/myJwt"
+   "secure-api-project/templates"
+)
+
+// CSRFMode says how a cookie session proves that an unsafe request comes
+// from one of our own pages. Either way the token is sent back in the
+// X-CSRF-Token header or form field. Bearer requests can't be forged by
+// another site and aren't checked.
+type CSRFMode int
+
+const (
+   // CSRFSynchronizer compares the token with the csrf claim of the auth
+   // token, so it only ever lives on the server side and in our pages.
+   CSRFSynchronizer CSRFMode = iota
+   // CSRFDoubleSubmit also sets the token in a cookie that our own scripts
+   // can read, and compares the two. The token is an HMAC of the session,
+   // so a cookie planted by a sibling subdomain is no good for another one.
+   CSRFDoubleSubmit
+)
+
+var (
+   csrfMode = CSRFSynchronizer
+   // csrfKey is the key of the double-submit HMAC.
+   csrfKey []byte
+)
+
+// newCsrfKey makes a key for the double-submit HMAC when none is given.
+// Tokens made with it don't survive a restart.
+func newCsrfKey() ([]byte, error) {
+   key := make([]byte, 32)
+   if _, err := rand.Read(key); err != nil {
+      return nil, err
+   }
+   return key, nil
+}
+
+func (m CSRFMode) validate() error {
+   if m != CSRFSynchronizer && m != CSRFDoubleSubmit {
+      return errors.New("Unknown CSRF mode!")
+   }
+   return nil
+}
+
+// safeMethod is true for the methods that mustn't change anything, and so
+// need no CSRF token.
+func safeMethod(method string) bool {
+   switch method {
+      case "GET", "HEAD", "OPTIONS", "TRACE":
+         return true
+   }
+   return false
+}
+
+// csrfHandler checks the CSRF token of unsafe requests of cookie sessions.
+// It has to come after authHandler, which leaves the claims of logged in
+// users on the request. Logout is public, since the auth token may have
+// expired, and is checked against the refresh token instead.
+func csrfHandler(next http.Handler) http.Handler {
+   fn := func(res http.ResponseWriter, req *http.Request) {
+      claims := claimsFrom(req)
+      if claims == nil && req.URL.Path == refreshPath + "/logout" {
+         claims = refreshClaims(req)
+      }
+      if claims == nil || safeMethod(req.Method) {
+         next.ServeHTTP(res, req)
+         return
+      }
+      if _, bearer := bearerToken(req); bearer {
+         next.ServeHTTP(res, req)
+         return
+      }
+      
+      if !validCsrf(req, claims) {
+         log.Printf("Forbidden! %s sent %s %s without a valid CSRF token", claims.Subject, req.Method, req.URL.Path)
+         http.Error(res, http.StatusText(403), 403)
+         return
+      }
+      next.ServeHTTP(res, req)
+   }
+   return http.HandlerFunc(fn)
+}
+
+// csrfToken is the token pages of a session send back: the csrf claim of
+// its tokens, or for CSRFDoubleSubmit an HMAC of its session, which tokens
+// of no session don't get.
+func csrfToken(claims *models.TokenClaims) string {
+   if csrfMode != CSRFDoubleSubmit {
+      return claims.Csrf
+   }
+   if claims.Session == "" {
+      return ""
+   }
+   mac := hmac.New(sha256.New, csrfKey)
+   mac.Write([]byte(claims.Session))
+   return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validCsrf(req *http.Request, claims *models.TokenClaims) bool {
+   expected := csrfToken(claims)
+   submitted := grabCsrfFromRequest(req)
+   if submitted == "" || expected == "" {
+      return false
+   }
+   if csrfMode == CSRFDoubleSubmit {
+      csrfCookie, err := req.Cookie(cookiePolicy.csrfCookieName())
+      if err != nil || subtle.ConstantTimeCompare([]byte(csrfCookie.Value), []byte(expected)) != 1 {
+         return false
+      }
+   }
+   return subtle.ConstantTimeCompare([]byte(submitted), []byte(expected)) == 1
+}
+
+// refreshClaims returns the claims of the refresh cookie, which carries the
+// CSRF secret of the session as well. Without one there's no session left
+// to end.
+func refreshClaims(req *http.Request) *models.TokenClaims {
+   refreshCookie, err := req.Cookie(cookiePolicy.refreshCookieName())
+   if err != nil {
+      return nil
+   }
+   claims, err := myJWT.ParseRefreshToken(refreshCookie.Value)
+   if err != nil {
+      return nil
+   }
+   return claims
+}
+
+func grabCsrfFromRequest(req *http.Request) string {
+   csrfFromForm := req.FormValue(templates.CsrfFieldName)
+   if csrfFromForm != "" {
+      return csrfFromForm
+   } else {
+      return req.Header.Get("X-CSRF-Token")
+   }
+}
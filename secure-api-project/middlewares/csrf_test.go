@@ -0,0 +1,102 @@
+package middleware
+
+import (
+   "net/http"
+   "net/http/httptest"
+   "net/url"
+   "strings"
+   "testing"
+   "secure-api-project/db/models"
+)
+
+func TestSynchronizerTokens(t *testing.T) {
+   handler := setup(t)
+   admin := logInAs(t, handler, "alkesh", models.RoleAdmin)
+   body := `{"username": "alkesh", "role": "admin"}`
+   
+   if res := serve(handler, "POST", "/admin/roles", body, admin.cookies); res.Code != http.StatusForbidden {
+      t.Fatalf("POST without a CSRF token = %d, want 403", res.Code)
+   }
+   forged := admin
+   forged.csrf = strings.Repeat("A", len(admin.csrf))
+   if res := forged.serve(handler, "POST", "/admin/roles", body); res.Code != http.StatusForbidden {
+      t.Fatalf("POST with a wrong CSRF token = %d, want 403", res.Code)
+   }
+   if res := admin.serve(handler, "POST", "/admin/roles", body); res.Code != http.StatusNoContent {
+      t.Fatalf("POST with the CSRF token = %d, want 204", res.Code)
+   }
+   // Safe methods aren't checked.
+   if res := serve(handler, "GET", "/restricted", "", admin.cookies); res.Code != http.StatusOK {
+      t.Fatalf("GET without a CSRF token = %d", res.Code)
+   }
+}
+
+func TestDoubleSubmitCookies(t *testing.T) {
+   setup(t)
+   handler, err := NewHandler(Config{Cookies: DevelopmentCookies, CSRF: CSRFDoubleSubmit})
+   if err != nil {
+      t.Fatalf("NewHandler: %v", err)
+   }
+   admin := logInAs(t, handler, "alkesh", models.RoleAdmin)
+   csrfCookie := cookieNamed(admin.cookies, "CsrfToken")
+   if csrfCookie == nil || csrfCookie.HttpOnly || csrfCookie.Value != admin.csrf {
+      t.Fatalf("CSRF cookie = %+v", csrfCookie)
+   }
+   body := `{"username": "alkesh", "role": "admin"}`
+   
+   if res := admin.serve(handler, "POST", "/admin/roles", body); res.Code != http.StatusNoContent {
+      t.Fatalf("POST with the cookie and the token = %d, want 204", res.Code)
+   }
+   withoutCookie := session{cookies: []*http.Cookie{cookieNamed(admin.cookies, "AuthToken")}, csrf: admin.csrf}
+   if res := withoutCookie.serve(handler, "POST", "/admin/roles", body); res.Code != http.StatusForbidden {
+      t.Fatalf("POST without the CSRF cookie = %d, want 403", res.Code)
+   }
+   if res := serve(handler, "POST", "/admin/roles", body, admin.cookies); res.Code != http.StatusForbidden {
+      t.Fatalf("POST with only the CSRF cookie = %d, want 403", res.Code)
+   }
+   
+   // A sibling subdomain can plant a cookie, but only the token of its own
+   // session goes with it.
+   attacker := logInAs(t, handler, "mallory", models.RoleUser)
+   planted := session{
+      cookies: []*http.Cookie{cookieNamed(admin.cookies, "AuthToken"), cookieNamed(attacker.cookies, "CsrfToken")},
+      csrf: attacker.csrf,
+   }
+   if res := planted.serve(handler, "POST", "/admin/roles", body); res.Code != http.StatusForbidden {
+      t.Fatalf("POST with a planted CSRF cookie = %d, want 403", res.Code)
+   }
+   
+   if res := admin.serve(handler, "POST", "/auth/logout", ""); res.Code != http.StatusFound {
+      t.Fatalf("logout = %d, want 302", res.Code)
+   }
+   
+   if _, err := NewHandler(Config{CSRF: CSRFMode(7)}); err == nil {
+      t.Fatal("NewHandler accepted an unknown CSRF mode")
+   }
+   if _, err := NewHandler(Config{CSRF: CSRFDoubleSubmit, CSRFKey: []byte("short")}); err == nil {
+      t.Fatal("NewHandler accepted a short CSRF key")
+   }
+}
+
+func TestDashboardFormsCarryTheToken(t *testing.T) {
+   handler := setup(t)
+   user := logInAs(t, handler, "ayush", models.RoleUser)
+   
+   res := user.serve(handler, "GET", "/restricted", "")
+   field := `name="X-CSRF-Token" value="` + user.csrf + `"`
+   if !strings.Contains(res.Body.String(), field) {
+      t.Fatalf("dashboard has no CSRF field in its forms:\n%s", res.Body)
+   }
+   
+   form := url.Values{"X-CSRF-Token": {user.csrf}}
+   req := httptest.NewRequest("POST", "/deleteUser", strings.NewReader(form.Encode()))
+   req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+   for _, c := range user.cookies {
+      req.AddCookie(c)
+   }
+   res = httptest.NewRecorder()
+   handler.ServeHTTP(res, req)
+   if res.Code == http.StatusForbidden {
+      t.Fatal("the CSRF token of a form was not accepted")
+   }
+}
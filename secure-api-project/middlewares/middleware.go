@@ -1,13 +1,17 @@
 package middleware
 
 import (
+   "errors"
    "log"
+   "math"
+   "strconv"
    "time"
    "strings"
    "net/http"
    "github.com/justinas/alice"
-   "secure-api-project/myJWT"
+   "secure-api-project/myJwt"
    "secure-api-project/db"
+   "secure-api-project/db/models"
    "secure-api-project/templates"
 )
 
@@ -15,7 +19,7 @@ func recoverHandler(next http.Handler) http.Handler {
    fn := func(res http.ResponseWriter, req *http.Request) {
       defer func() {
          if err := recover(); err != nil {
-            log.Panic("Recovered Panic: %+v", err)
+            log.Printf("Recovered Panic: %+v", err)
             http.Error(res, http.StatusText(500), 500)
          }
       }()
@@ -26,165 +30,253 @@ func recoverHandler(next http.Handler) http.Handler {
 
 func authHandler(next http.Handler) http.Handler {
    fn := func(res http.ResponseWriter, req *http.Request) {
-      switch req.URL.Path {
-         case "/restricted", "/logout", "/deleteUser":
-            log.Println("In auth restricted section!")
-            authCookie, authErr := req.Cookie("AuthToken")
-            if authErr == http.ErrNoCookie {
-               log.Println("Unauthorized attempt! No auth cookie was found.")
-               nullifyTokenCookies(&res, req)
-               http.Error(res, http.StatusText(401), 401)
-               return
-            }
-            else if authErr != nil {
-               log.Panic("Panic: %+v", authErr)
-               nullifyTokenCookies(&res, req)
-               http.Error(res, http.StatusText(500), 500)
-               return
-            }
-            
-            refreshCookie, refreshCookieErr := req.Cookie("RefreshToken")
-            if refreshCookieErr == http.ErrNoCookie {
-               log.Println("Unauthorized attempt! No refresh cookie was found.")
-               nullifyTokenCookies(&res, req)
-               http.Redirect(res, req, "/login", 302)
-               return
-            }
-            else if refreshCookieErr != nil {
-               log.Panic("Panic: %+v", refreshCookieErr)
-               nullifyTokenCookies(&res, req)
-               http.Error(res, http.StatusText(500), 500)
-               return
-            }
-            
-            requestCsrfToken := grabCsrfFromRequest(req)
-            log.Println(requestCsrfToken)
-            
-            authTokenString, refreshTokenString, csrfSecret, err := myJWT.CheckAndRefreshTokens(authCookie.Value, refreshCookie.Value, requestCsrfToken)
-            if err != nil {
-               if err.Error() == "unauthorized" {
-                  log.Println("Unauthorized attempt! No JWT's valid.")
-                  http.Error(res, http.StatusText(401), 401)
-                  return
-               }
-               else {
-                  log.Panic("Error not nil")
-                  log.Panic("Panic: %+v", err)
-                  http.Error(res, http.StatusText(500), 500)
-               }
-            }
-            log.Println("Successfully recreated JWT")
-            res.Header().Set("Access-Control-Allow-Origin", "*")
-            setAuthAndRefreshCookies(&res, authTokenString, refreshTokenString)
-            res.Header().Set("X-CSRF-Token", csrfSecret)
-         default:
-            // No checks necessary :)
+      r, ok := findRoute(req.URL.Path)
+      if !ok {
+         http.NotFound(res, req)
+         return
       }
-      next.ServeHTTP(res, req)
+      if !r.allowsMethod(req.Method) {
+         res.Header().Set("Allow", strings.Join(r.Methods, ", "))
+         http.Error(res, http.StatusText(405), 405)
+         return
+      }
+      if r.Public {
+         // No checks necessary :)
+         next.ServeHTTP(res, req)
+         return
+      }
+      
+      var claims *models.TokenClaims
+      if token, bearer := bearerToken(req); bearer {
+         claims, ok = bearerSession(res, token)
+      } else {
+         claims, ok = cookieSession(res, req)
+      }
+      if !ok {
+         return
+      }
+      
+      if !r.allowsRole(claims.Role) {
+         log.Printf("Forbidden! %s with role %q asked for %s %s", claims.Subject, claims.Role, req.Method, req.URL.Path)
+         http.Error(res, http.StatusText(403), 403)
+         return
+      }
+      next.ServeHTTP(res, withClaims(req, claims))
+   }
+   return http.HandlerFunc(fn)
+}
+
+// bearerSession checks the access token of a bearer client.
+func bearerSession(res http.ResponseWriter, token string) (*models.TokenClaims, bool) {
+   claims, err := myJWT.ParseAuthToken(token)
+   if err != nil || !activeSession(claims.Subject, claims.Session) {
+      log.Println("Unauthorized attempt! Bearer token is not valid.")
+      res.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+      http.Error(res, http.StatusText(401), 401)
+      return nil, false
+   }
+   return claims, true
+}
+
+// cookieSession checks the auth cookie of a browser. Once it's gone or
+// expired, the browser is sent to refresh its session.
+func cookieSession(res http.ResponseWriter, req *http.Request) (*models.TokenClaims, bool) {
+   authCookie, authErr := req.Cookie(cookiePolicy.authCookieName())
+   if authErr != nil {
+      log.Println("No auth cookie was found.")
+      sessionExpired(res, req)
+      return nil, false
    }
+   
+   claims, err := myJWT.ParseAuthToken(authCookie.Value)
+   if err != nil || !activeSession(claims.Subject, claims.Session) {
+      log.Println("Auth token is invalid, expired or revoked")
+      sessionExpired(res, req)
+      return nil, false
+   }
+   res.Header().Set("X-CSRF-Token", csrfToken(claims))
+   return claims, true
 }
 
 func logicHandler(res http.ResponseWriter, req *http.Request) {
    switch req.URL.Path {
       case "/restricted":
-         csrfSecret := grabCsrfFromRequest(req)
-         templates.RenderTemplate(res, "restricted", &templates.DashboardPage{csrfSecret, "Hello, Ayush!"})
+         templates.RenderTemplate(res, "restricted", &templates.DashboardPage{CsrfSecret: csrfToken(claimsFrom(req)), SecretMessage: "Hello, Ayush!"})
       case "/login":
          switch req.Method {
             case "GET":
+               templates.RenderTemplate(res, "login", &templates.LoginPage{})
             case "POST":
+               logIn(res, req)
             default:
+               res.WriteHeader(http.StatusMethodNotAllowed)
          }
       case "/register":
          switch req.Method {
             case "GET":
-               templates.RenderTemplate(res, "register", &templates.RegisterPage{false, ""})
+               templates.RenderTemplate(res, "register", &templates.RegisterPage{})
             case "POST":
                req.ParseForm()
-               log.Println(req.Form)
-               _, uuid, err := db.FetchUserByUsername(strings.Join(req.Form["username"], ""))
+               _, _, err := db.FetchUserByUsername(strings.Join(req.Form["username"], ""))
                if err == nil {
                   res.WriteHeader(http.StatusUnauthorized)
                } else {
-                  role := 
+                  role := models.RoleUser
                   uuid, err := db.StoreUser(strings.Join(req.Form["username"], ""), strings.Join(req.Form["password"], ""), role)
                   if err != nil {
                      http.Error(res, http.StatusText(500), 500)
+                     return
                   }
                   log.Println("uuid: " + uuid)
                   
-                  authToken, refreshToken, csrfSecret, err := myJWT.CreateNewTokens(uuid, role)
+                  authToken, refreshToken, _, err := myJWT.CreateNewTokens(uuid, role, clientOf(req))
+                  if err == nil {
+                     err = setAuthAndRefreshCookies(&res, authToken, refreshToken)
+                  }
                   if err != nil {
                      http.Error(res, http.StatusText(500), 500)
+                     return
                   }
-                  setAuthAndRefreshCookies(&res, authToken, refreshToken)
-                  res.Header().Set("X-CSRF-Token", csrfSecret)
                   res.WriteHeader(http.StatusOK)
                }
             default:
                res.WriteHeader(http.StatusMethodNotAllowed)
          }
-      case "/logout":
+      case refreshPath + "/refresh":
+         refreshSession(res, req)
+      case refreshPath + "/logout":
          nullifyTokenCookies(&res, req)
          http.Redirect(res, req, "/login", 302)
       case "/deleteUser":
-      default: 
+         deleteAccount(res, req)
+      case "/sessions":
+         listSessions(res, req)
+      case "/sessions/revoke":
+         revokeSession(res, req)
+      case "/sessions/revoke-all":
+         logOutEverywhere(res, req)
+      case "/.well-known/jwks.json":
+         myJWT.JWKSHandler(res, req)
+      case "/admin/roles":
+         assignRole(res, req)
+      case "/token":
+         issueToken(res, req)
+      case "/token/refresh":
+         refreshBearerToken(res, req)
+      default:
    }
 }
 
-func nullifyTokenCookies(res *http.ResponseWriter, req *http.Request) {
-   authCookie := http.Cookie{
-      Name: "AuthToken",
-      Value: "",
-      Expires: time.Now().Add(-1000 * time.Hour),
-      HttpOnly: true,
-   }
-   http.SetCookie(*res, &authCookie)
-   
-   refreshCookie := http.Cookie{
-      Name: "RefreshToken",
-      Value: "",
-      Expires: time.Now().Add(-1000 * time.Hour),
-      HttpOnly: true,
+// logIn checks the submitted credentials and starts a cookie session.
+func logIn(res http.ResponseWriter, req *http.Request) {
+   user, uuid, wait, err := authenticate(req, req.FormValue("username"), req.FormValue("password"))
+   if wait > 0 {
+      res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+      res.WriteHeader(http.StatusTooManyRequests)
+      templates.RenderTemplate(res, "login", &templates.LoginPage{BAlertUser: true, AlertMsg: "Too many failed attempts. Please try again later."})
+      return
+   } else if err == errInvalidCredentials {
+      res.WriteHeader(http.StatusUnauthorized)
+      templates.RenderTemplate(res, "login", &templates.LoginPage{BAlertUser: true, AlertMsg: "Invalid username or password."})
+      return
+   } else if err != nil {
+      http.Error(res, http.StatusText(500), 500)
+      return
    }
-   http.SetCookie(*res, &refreshCookie)
    
-   refreshCookie, refreshErr := req.Cookie("RefreshToken")
-   if refreshErr == http.ErrNoCookie {
+   authToken, refreshToken, _, err := myJWT.CreateNewTokens(uuid, user.Role, clientOf(req))
+   if err == nil {
+      err = setAuthAndRefreshCookies(&res, authToken, refreshToken)
+   }
+   if err != nil {
+      http.Error(res, http.StatusText(500), 500)
       return
-   } else if refreshErr != nil {
-      log.Panic("Error %+v", refreshErr)
-      http.Error(*res, http.StatusText(500), 500)
    }
-   
-   myJWT.RevokeRefreshToken(refreshCookie.Value)
+   http.Redirect(res, req, "/restricted", 302)
 }
 
-func setAuthAndRefreshCookies(res *http.ResponseWriter, authTokenString string, refreshTokenString string) {
-   authCookie := http.Cookie{
-      Name: "AuthToken",
-      Value: authTokenString,
-      HttpOnly: true,
+var errInvalidCredentials = errors.New("Invalid username or password!")
+
+// authenticate checks a username and password for any kind of login.
+// Failures back off exponentially per account and per address; while they
+// do, it returns how long to wait instead. An attempt counts as a failure
+// until its password has been checked, so parallel guesses back off too.
+// Unknown users and wrong passwords both fail with errInvalidCredentials,
+// so it can't be told whether the user exists.
+func authenticate(req *http.Request, username, password string) (models.User, string, time.Duration, error) {
+   accountKey, ipKey := "account:" + username, "ip:" + clientIP(req)
+   
+   now := time.Now()
+   wait := accountThrottle.claim(accountKey, now)
+   if wait == 0 {
+      if wait = ipThrottle.claim(ipKey, now); wait > 0 {
+         accountThrottle.release(accountKey)
+      }
+   }
+   if wait > 0 {
+      log.Printf("Login of %q from %s throttled for %s", username, clientIP(req), wait)
+      return models.User{}, "", wait, errInvalidCredentials
    }
-   http.SetCookie(*res, &authCookie)
    
-   refreshCookie := http.Cookie{
-      Name: "RefreshToken",
-      Value: refreshTokenString,
-      HttpOnly: true,
+   user, uuid, err := db.LogUserIn(username, password)
+   if errors.Is(err, db.ErrUserNotFound) || errors.Is(err, db.ErrPasswordMismatch) {
+      now = time.Now()
+      accountThrottle.fail(accountKey, now)
+      ipThrottle.fail(ipKey, now)
+      return models.User{}, "", 0, errInvalidCredentials
+   } else if err != nil {
+      accountThrottle.release(accountKey)
+      ipThrottle.release(ipKey)
+      log.Printf("Error logging in: %+v", err)
+      return models.User{}, "", 0, err
    }
-   http.SetCookie(*res, &refreshCookie)
+   ipThrottle.release(ipKey)
+   accountThrottle.reset(accountKey)
+   return user, uuid, 0, nil
 }
 
-func grabCsrfFromRequest(req *http.Request) string {
-   csrfFromForm := req.FormValue("X-CSRF-Token")
-   if csrfFromForm != "" {
-      return csrfFromForm
-   } else {
-      return req.Header.Get("X-CSRF-Token")
-   }
+// Config configures the handler NewHandler returns.
+type Config struct {
+   Cookies CookiePolicy
+   CORS    CORSPolicy
+   CSRF    CSRFMode
+   // CSRFKey is the key CSRFDoubleSubmit tokens are made with, at least 32
+   // bytes. Without one a random key is made.
+   CSRFKey []byte
 }
 
-func NewHandler() http.Handler {
-   return alice.New(recoverHandler, authHandler).ThenFunc(logicHandler)
-}
\ No newline at end of file
+func NewHandler(config Config) (http.Handler, error) {
+   if err := config.Cookies.validate(); err != nil {
+      return nil, err
+   }
+   if err := config.CORS.validate(); err != nil {
+      return nil, err
+   }
+   if err := config.CSRF.validate(); err != nil {
+      return nil, err
+   }
+   if len(config.CSRFKey) == 0 {
+      key, err := newCsrfKey()
+      if err != nil {
+         return nil, err
+      }
+      config.CSRFKey = key
+   } else if len(config.CSRFKey) < 32 {
+      return nil, errors.New("The CSRF key has to be at least 32 bytes!")
+   }
+   cookiePolicy = config.Cookies
+   csrfMode = config.CSRF
+   csrfKey = config.CSRFKey
+   
+   corsPolicy = config.CORS
+   if corsPolicy.AllowedMethods == nil {
+      corsPolicy.AllowedMethods = defaultCORSPolicy.AllowedMethods
+   }
+   if corsPolicy.AllowedHeaders == nil {
+      corsPolicy.AllowedHeaders = defaultCORSPolicy.AllowedHeaders
+   }
+   if corsPolicy.MaxAge == 0 {
+      corsPolicy.MaxAge = defaultCORSPolicy.MaxAge
+   }
+   return alice.New(recoverHandler, corsHandler, authHandler, csrfHandler).ThenFunc(logicHandler), nil
+}
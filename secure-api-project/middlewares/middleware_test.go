@@ -0,0 +1,185 @@
+package middleware
+
+import (
+   "fmt"
+   "net/http"
+   "net/http/httptest"
+   "net/url"
+   "strings"
+   "sync"
+   "testing"
+   "time"
+   "secure-api-project/db"
+   "secure-api-project/myJwt"
+)
+
+func setup(t *testing.T) http.Handler {
+   db.InitDB(db.NewMemoryStore())
+   db.SetPasswordHasher(db.BcryptHasher{Cost: 4})
+   if err := myJWT.InitJWT(myJWT.Config{KeyDir: t.TempDir()}); err != nil {
+      t.Fatalf("InitJWT: %v", err)
+   }
+   accountThrottle = newThrottle(time.Second, time.Minute, 3, 15 * time.Minute)
+   ipThrottle = newThrottle(0, 0, 100, 15 * time.Minute)
+   handler, err := NewHandler(Config{Cookies: DevelopmentCookies})
+   if err != nil {
+      t.Fatalf("NewHandler: %v", err)
+   }
+   return handler
+}
+
+func postLogin(handler http.Handler, username, password, remoteAddr string) *httptest.ResponseRecorder {
+   form := url.Values{"username": {username}, "password": {password}}
+   req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+   req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+   req.RemoteAddr = remoteAddr
+   res := httptest.NewRecorder()
+   handler.ServeHTTP(res, req)
+   return res
+}
+
+func TestLogin(t *testing.T) {
+   handler := setup(t)
+   db.StoreUser("ayush", "correct horse", "user")
+   
+   res := postLogin(handler, "ayush", "correct horse", "10.0.0.1:1234")
+   if res.Code != http.StatusFound || res.Header().Get("Location") != "/restricted" {
+      t.Fatalf("login = %d, want a redirect to /restricted", res.Code)
+   }
+   cookies := map[string]bool{}
+   for _, c := range res.Result().Cookies() {
+      cookies[c.Name] = c.Value != ""
+   }
+   if !cookies["AuthToken"] || !cookies["RefreshToken"] || res.Header().Get("X-CSRF-Token") == "" {
+      t.Fatalf("login did not issue tokens: %v", cookies)
+   }
+   
+   res = httptest.NewRecorder()
+   handler.ServeHTTP(res, httptest.NewRequest("GET", "/login", nil))
+   if res.Code != http.StatusOK || !strings.Contains(res.Body.String(), `name="password"`) {
+      t.Fatalf("GET /login = %d, want the login form", res.Code)
+   }
+}
+
+func TestLoginFailuresLookAlike(t *testing.T) {
+   handler := setup(t)
+   db.StoreUser("ayush", "correct horse", "user")
+   
+   wrong := postLogin(handler, "ayush", "wrong", "10.0.0.1:1234")
+   unknown := postLogin(handler, "nobody", "wrong", "10.0.0.2:1234")
+   if wrong.Code != http.StatusUnauthorized || unknown.Code != wrong.Code || unknown.Body.String() != wrong.Body.String() {
+      t.Fatalf("wrong password = %d, unknown user = %d; they should look alike", wrong.Code, unknown.Code)
+   }
+}
+
+func TestLoginThrottlesAccount(t *testing.T) {
+   handler := setup(t)
+   db.StoreUser("ayush", "correct horse", "user")
+   
+   postLogin(handler, "ayush", "wrong", "10.0.0.1:1234")
+   // The right password doesn't help while the account is backing off,
+   // even from another address.
+   res := postLogin(handler, "ayush", "correct horse", "10.0.0.2:1234")
+   if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") != "1" {
+      t.Fatalf("login during backoff = %d, Retry-After %q", res.Code, res.Header().Get("Retry-After"))
+   }
+   // Other accounts aren't affected.
+   if res := postLogin(handler, "alkesh", "wrong", "10.0.0.1:1234"); res.Code != http.StatusUnauthorized {
+      t.Fatalf("login of another account = %d, want 401", res.Code)
+   }
+}
+
+func TestThrottleBacksOffAndLocksOut(t *testing.T) {
+   th := newThrottle(time.Second, 5 * time.Second, 5, time.Hour)
+   now := time.Now()
+   
+   waits := []time.Duration{}
+   for i := 0; i < 5; i++ {
+      if wait := th.claim("key", now); wait != 0 {
+         t.Fatalf("claim %d had to wait %s", i, wait)
+      }
+      th.fail("key", now)
+      waits = append(waits, th.claim("key", now))
+      now = now.Add(waits[i])
+   }
+   want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, time.Hour}
+   for i := range want {
+      if waits[i] != want[i] {
+         t.Fatalf("waits = %v, want %v", waits, want)
+      }
+   }
+   
+   if wait := th.claim("key", now); wait != 0 {
+      t.Fatalf("wait after the lockout = %s", wait)
+   }
+   th.release("key")
+   now = now.Add(2 * time.Hour)
+   th.claim("other", now)
+   if _, ok := th.keys["key"]; ok {
+      t.Fatal("a key quiet for the lockout period should be forgotten")
+   }
+   
+   th.fail("other", now)
+   th.reset("other")
+   if wait := th.claim("other", now); wait != 0 {
+      t.Fatalf("wait after reset = %s", wait)
+   }
+}
+
+func TestThrottleCountsClaimedAttempts(t *testing.T) {
+   th := newThrottle(time.Second, 5 * time.Second, 2, time.Hour)
+   now := time.Now()
+   
+   th.claim("key", now)
+   if wait := th.claim("key", now); wait != time.Second {
+      t.Fatalf("claim during another attempt waits %s, want 1s", wait)
+   }
+   th.release("key")
+   if wait := th.claim("key", now); wait != 0 {
+      t.Fatalf("claim after a release waits %s", wait)
+   }
+   // Attempts still running count toward the lockout.
+   if wait := th.claim("key", now.Add(time.Second)); wait != 0 {
+      t.Fatalf("claim after the backoff waits %s", wait)
+   }
+   if wait := th.claim("key", now.Add(2 * time.Second)); wait != time.Hour - time.Second {
+      t.Fatalf("claim with two attempts running waits %s, want the lockout", wait)
+   }
+}
+
+func TestConcurrentLoginsAreThrottled(t *testing.T) {
+   handler := setup(t)
+   // A slower hash keeps the guesses running at the same time.
+   db.SetPasswordHasher(db.BcryptHasher{Cost: 10})
+   db.StoreUser("ayush", "correct horse", "user")
+   
+   const guesses = 10
+   codes := make(chan int, guesses)
+   start := make(chan struct{})
+   var wg sync.WaitGroup
+   for i := 0; i < guesses; i++ {
+      wg.Add(1)
+      go func(i int) {
+         defer wg.Done()
+         <-start
+         codes <- postLogin(handler, "ayush", "wrong", fmt.Sprintf("10.0.0.%d:1234", i)).Code
+      }(i)
+   }
+   close(start)
+   wg.Wait()
+   close(codes)
+   
+   checked := 0
+   for code := range codes {
+      switch code {
+         case http.StatusUnauthorized:
+            checked++
+         case http.StatusTooManyRequests:
+         default:
+            t.Fatalf("concurrent login = %d", code)
+      }
+   }
+   if checked != 1 {
+      t.Fatalf("%d of %d parallel guesses had their password checked, want 1", checked, guesses)
+   }
+}
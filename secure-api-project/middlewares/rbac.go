@@ -0,0 +1,136 @@
+package middleware
+
+import (
+   "context"
+   "encoding/json"
+   "errors"
+   "log"
+   "net/http"
+   "secure-api-project/db"
+   "secure-api-project/db/models"
+)
+
+// Permissions a role can have. Routes ask for a role or a permission.
+const (
+   PermissionViewDashboard = "dashboard:view"
+   PermissionManageAccount = "account:manage"
+)
+
+var rolePermissions = map[string][]string{
+   models.RoleUser: {PermissionViewDashboard, PermissionManageAccount},
+   models.RoleAdmin: {PermissionViewDashboard, PermissionManageAccount},
+}
+
+// route says who may use a path. A public route needs no tokens at all.
+// Otherwise the user's role has to be one of Roles or have Permission;
+// with neither set, any logged in user may use it. Methods limits the
+// methods served, all of them if empty.
+type route struct {
+   Path       string
+   Methods    []string
+   Public     bool
+   Roles      []string
+   Permission string
+}
+
+// routes is every path we serve. Paths that aren't listed are not found.
+var routes = []route{
+   {Path: "/login", Methods: []string{"GET", "POST"}, Public: true},
+   {Path: "/register", Methods: []string{"GET", "POST"}, Public: true},
+   {Path: "/.well-known/jwks.json", Methods: []string{"GET"}, Public: true},
+   {Path: "/token", Methods: []string{"POST"}, Public: true},
+   {Path: "/token/refresh", Methods: []string{"POST"}, Public: true},
+   {Path: "/restricted", Methods: []string{"GET"}, Permission: PermissionViewDashboard},
+   {Path: refreshPath + "/refresh", Methods: []string{"GET", "POST"}, Public: true},
+   {Path: refreshPath + "/logout", Methods: []string{"POST"}, Public: true},
+   {Path: "/deleteUser", Methods: []string{"POST"}, Permission: PermissionManageAccount},
+   {Path: "/sessions", Methods: []string{"GET"}, Permission: PermissionManageAccount},
+   {Path: "/sessions/revoke", Methods: []string{"POST"}, Permission: PermissionManageAccount},
+   {Path: "/sessions/revoke-all", Methods: []string{"POST"}, Permission: PermissionManageAccount},
+   {Path: "/admin/roles", Methods: []string{"POST"}, Roles: []string{models.RoleAdmin}},
+}
+
+func findRoute(path string) (route, bool) {
+   for _, r := range routes {
+      if r.Path == path {
+         return r, true
+      }
+   }
+   return route{}, false
+}
+
+func (r route) allowsMethod(method string) bool {
+   if len(r.Methods) == 0 {
+      return true
+   }
+   for _, m := range r.Methods {
+      if m == method {
+         return true
+      }
+   }
+   return false
+}
+
+func (r route) allowsRole(role string) bool {
+   if len(r.Roles) == 0 && r.Permission == "" {
+      return true
+   }
+   for _, allowed := range r.Roles {
+      if allowed == role {
+         return true
+      }
+   }
+   for _, permission := range rolePermissions[role] {
+      if permission == r.Permission {
+         return true
+      }
+   }
+   return false
+}
+
+type contextKey int
+
+const claimsKey contextKey = 0
+
+func withClaims(req *http.Request, claims *models.TokenClaims) *http.Request {
+   return req.WithContext(context.WithValue(req.Context(), claimsKey, claims))
+}
+
+// claimsFrom returns the claims of the user making an authenticated request.
+func claimsFrom(req *http.Request) *models.TokenClaims {
+   claims, _ := req.Context().Value(claimsKey).(*models.TokenClaims)
+   return claims
+}
+
+func validRole(role string) bool {
+   for _, r := range models.Roles {
+      if r == role {
+         return true
+      }
+   }
+   return false
+}
+
+// assignRole handles POST /admin/roles, {"username": ..., "role": ...}.
+func assignRole(res http.ResponseWriter, req *http.Request) {
+   var body struct {
+      Username string `json:"username"`
+      Role     string `json:"role"`
+   }
+   if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Username == "" || !validRole(body.Role) {
+      http.Error(res, http.StatusText(400), 400)
+      return
+   }
+   
+   err := db.SetUserRole(body.Username, body.Role)
+   if errors.Is(err, db.ErrUserNotFound) {
+      http.Error(res, http.StatusText(404), 404)
+      return
+   } else if err != nil {
+      log.Printf("Error assigning a role: %+v", err)
+      http.Error(res, http.StatusText(500), 500)
+      return
+   }
+   log.Printf("%s gave %q the role %q", claimsFrom(req).Subject, body.Username, body.Role)
+   res.WriteHeader(http.StatusNoContent)
+}
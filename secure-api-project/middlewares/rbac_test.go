@@ -0,0 +1,103 @@
+package middleware
+
+import (
+   "net/http"
+   "net/http/httptest"
+   "strings"
+   "testing"
+   "secure-api-project/db"
+   "secure-api-project/db/models"
+)
+
+// session is what a browser keeps of a login.
+type session struct {
+   cookies []*http.Cookie
+   csrf    string
+}
+
+// logInAs registers a user with role and logs it in.
+func logInAs(t *testing.T, handler http.Handler, username, role string) session {
+   db.StoreUser(username, "correct horse", role)
+   res := postLogin(handler, username, "correct horse", "10.0.0.1:1234")
+   if res.Code != http.StatusFound {
+      t.Fatalf("login of %s = %d", username, res.Code)
+   }
+   return session{cookies: res.Result().Cookies(), csrf: res.Header().Get("X-CSRF-Token")}
+}
+
+// serve sends a request of the session, with its CSRF token.
+func (s session) serve(handler http.Handler, method, path, body string) *httptest.ResponseRecorder {
+   req := httptest.NewRequest(method, path, strings.NewReader(body))
+   for _, c := range s.cookies {
+      req.AddCookie(c)
+   }
+   req.Header.Set("X-CSRF-Token", s.csrf)
+   res := httptest.NewRecorder()
+   handler.ServeHTTP(res, req)
+   return res
+}
+
+func serve(handler http.Handler, method, path, body string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+   req := httptest.NewRequest(method, path, strings.NewReader(body))
+   for _, c := range cookies {
+      req.AddCookie(c)
+   }
+   res := httptest.NewRecorder()
+   handler.ServeHTTP(res, req)
+   return res
+}
+
+func TestRoutesNeedTheRightRole(t *testing.T) {
+   handler := setup(t)
+   user := logInAs(t, handler, "ayush", models.RoleUser)
+   admin := logInAs(t, handler, "alkesh", models.RoleAdmin)
+   
+   if res := user.serve(handler, "GET", "/restricted", ""); res.Code != http.StatusOK {
+      t.Fatalf("dashboard of a user = %d", res.Code)
+   }
+   if res := serve(handler, "GET", "/restricted", "", nil); res.Code != http.StatusFound || res.Header().Get("Location") != "/auth/refresh?next=%2Frestricted" {
+      t.Fatalf("dashboard without tokens = %d, want a redirect to refresh the session", res.Code)
+   }
+   
+   body := `{"username": "ayush", "role": "admin"}`
+   if res := user.serve(handler, "POST", "/admin/roles", body); res.Code != http.StatusForbidden {
+      t.Fatalf("role assignment by a user = %d, want 403", res.Code)
+   }
+   if res := admin.serve(handler, "POST", "/admin/roles", body); res.Code != http.StatusNoContent {
+      t.Fatalf("role assignment by an admin = %d, want 204", res.Code)
+   }
+   if u, _, _ := db.FetchUserByUsername("ayush"); u.Role != models.RoleAdmin {
+      t.Fatalf("role = %q, want admin", u.Role)
+   }
+   
+   if res := admin.serve(handler, "POST", "/admin/roles", `{"username": "ayush", "role": "root"}`); res.Code != http.StatusBadRequest {
+      t.Fatalf("assignment of an unknown role = %d, want 400", res.Code)
+   }
+   if res := admin.serve(handler, "POST", "/admin/roles", `{"username": "nobody", "role": "user"}`); res.Code != http.StatusNotFound {
+      t.Fatalf("assignment to an unknown user = %d, want 404", res.Code)
+   }
+}
+
+func TestUnknownRoutesAndMethods(t *testing.T) {
+   handler := setup(t)
+   
+   if res := serve(handler, "GET", "/nowhere", "", nil); res.Code != http.StatusNotFound {
+      t.Fatalf("unknown path = %d, want 404", res.Code)
+   }
+   res := serve(handler, "DELETE", "/login", "", nil)
+   if res.Code != http.StatusMethodNotAllowed || res.Header().Get("Allow") != "GET, POST" {
+      t.Fatalf("unknown method = %d, Allow %q", res.Code, res.Header().Get("Allow"))
+   }
+}
+
+func TestRegisterGivesTheUserRole(t *testing.T) {
+   handler := setup(t)
+   
+   req := httptest.NewRequest("POST", "/register", strings.NewReader("username=ayush&password=correct+horse"))
+   req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+   handler.ServeHTTP(httptest.NewRecorder(), req)
+   
+   if u, _, err := db.FetchUserByUsername("ayush"); err != nil || u.Role != models.RoleUser {
+      t.Fatalf("registered user = %+v, %v", u, err)
+   }
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+   "net"
+   "net/http"
+   "sync"
+   "time"
+)
+
+// throttle slows down repeated login failures of one key, an account or a
+// client IP. Every failure doubles the wait before the next attempt, from
+// base up to max, and limit failures in a row lock the key out for lockout.
+// A key that stays quiet for lockout starts over.
+//
+// An attempt is claimed before the password is checked and counts as a
+// failure until it is settled with fail, release or reset, so parallel
+// guesses can't all get past the check before the first of them fails.
+type throttle struct {
+   mutex   sync.Mutex
+   base    time.Duration
+   max     time.Duration
+   limit   int
+   lockout time.Duration
+   keys    map[string]*failures
+}
+
+type failures struct {
+   count        int
+   pending      int
+   last         time.Time
+   blockedUntil time.Time
+   claimedUntil time.Time
+}
+
+func newThrottle(base, max time.Duration, limit int, lockout time.Duration) *throttle {
+   return &throttle{base: base, max: max, limit: limit, lockout: lockout, keys: map[string]*failures{}}
+}
+
+// Accounts lock out quickly. Addresses get more room, since many users can
+// share one behind a NAT, but still can't spray passwords across accounts.
+var (
+   accountThrottle = newThrottle(time.Second, time.Minute, 10, 15 * time.Minute)
+   ipThrottle      = newThrottle(100 * time.Millisecond, 30 * time.Second, 100, 15 * time.Minute)
+)
+
+// claim reserves an attempt for key, or returns how long key has to wait
+// before it may make one. A claimed attempt must be settled.
+func (t *throttle) claim(key string, now time.Time) time.Duration {
+   t.mutex.Lock()
+   defer t.mutex.Unlock()
+   
+   for k, f := range t.keys {
+      if f.pending == 0 && now.Sub(f.last) > t.lockout && !now.Before(f.blockedUntil) {
+         delete(t.keys, k)
+      }
+   }
+   
+   f, ok := t.keys[key]
+   if !ok {
+      f = &failures{}
+      t.keys[key] = f
+   }
+   if now.Before(f.claimedUntil) || now.Before(f.blockedUntil) {
+      wait := f.blockedUntil.Sub(now)
+      if claimed := f.claimedUntil.Sub(now); claimed > wait {
+         wait = claimed
+      }
+      return wait
+   }
+   f.pending++
+   f.last = now
+   f.claimedUntil = t.until(f.count + f.pending, now)
+   return 0
+}
+
+// fail settles a claimed attempt of key as a failure.
+func (t *throttle) fail(key string, now time.Time) {
+   t.mutex.Lock()
+   defer t.mutex.Unlock()
+   
+   f, ok := t.keys[key]
+   if !ok {
+      // The key was reset while the attempt was running.
+      f = &failures{}
+      t.keys[key] = f
+   }
+   f.unclaim()
+   f.count++
+   f.last = now
+   f.blockedUntil = t.until(f.count, now)
+}
+
+// release settles a claimed attempt of key that didn't fail.
+func (t *throttle) release(key string) {
+   t.mutex.Lock()
+   defer t.mutex.Unlock()
+   
+   if f, ok := t.keys[key]; ok {
+      f.unclaim()
+   }
+}
+
+// reset settles a claimed attempt of key that succeeded, forgetting all of
+// its failures.
+func (t *throttle) reset(key string) {
+   t.mutex.Lock()
+   defer t.mutex.Unlock()
+   delete(t.keys, key)
+}
+
+func (f *failures) unclaim() {
+   if f.pending > 0 {
+      f.pending--
+   }
+   if f.pending == 0 {
+      f.claimedUntil = time.Time{}
+   }
+}
+
+// until is when a key with count failures may try again.
+func (t *throttle) until(count int, now time.Time) time.Time {
+   if count >= t.limit {
+      return now.Add(t.lockout)
+   }
+   delay := t.base << uint(count - 1)
+   if delay > t.max || delay <= 0 {
+      delay = t.max
+   }
+   return now.Add(delay)
+}
+
+// clientIP is the address the request came from. Forwarding headers are
+// ignored, since anyone can set them.
+func clientIP(req *http.Request) string {
+   host, _, err := net.SplitHostPort(req.RemoteAddr)
+   if err != nil {
+      return req.RemoteAddr
+   }
+   return host
+}
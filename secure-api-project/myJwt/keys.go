@@ -0,0 +1,339 @@
+package myJWT
+
+import (
+   "crypto"
+   "crypto/ecdsa"
+   "crypto/ed25519"
+   "crypto/elliptic"
+   "crypto/rand"
+   "crypto/rsa"
+   "crypto/x509"
+   "encoding/base64"
+   "encoding/json"
+   "encoding/pem"
+   "errors"
+   "fmt"
+   "io/ioutil"
+   "log"
+   "math/big"
+   "net/http"
+   "os"
+   "path/filepath"
+   "sync"
+   "time"
+   jwt "github.com/golang-jwt/jwt/v4"
+   "secure-api-project/db/models"
+   "secure-api-project/randomStrings"
+)
+
+// Config says how tokens are signed.
+type Config struct {
+   // Algorithm is RS256, ES256 or EdDSA. Defaults to RS256.
+   Algorithm string
+   // KeyDir holds the signing keys and keys.json, which lists them. Keys are
+   // generated when there are none. Defaults to "keys".
+   KeyDir string
+   // RotateEvery is how old the signing key may get before a new one
+   // replaces it. Zero never rotates on schedule.
+   RotateEvery time.Duration
+   // Grace is how long a replaced key still verifies tokens. Defaults to
+   // RefreshTokenValidTime, so no session is cut short by a rotation.
+   Grace time.Duration
+}
+
+type signingKey struct {
+   Kid     string    `json:"kid"`
+   Alg     string    `json:"alg"`
+   Created time.Time `json:"created"`
+   Retired time.Time `json:"retired"`
+   private crypto.Signer
+}
+
+// keySet holds the signing key, which is the last one, and the keys that
+// were replaced but still verify tokens.
+type keySet struct {
+   mutex  sync.RWMutex
+   config Config
+   keys   []*signingKey
+}
+
+var keys *keySet
+
+func InitJWT(config Config) error {
+   if config.Algorithm == "" {
+      config.Algorithm = "RS256"
+   }
+   if config.KeyDir == "" {
+      config.KeyDir = "keys"
+   }
+   if config.Grace == 0 {
+      config.Grace = models.RefreshTokenValidTime
+   }
+   if jwt.GetSigningMethod(config.Algorithm) == nil || !supportedAlgorithm(config.Algorithm) {
+      return fmt.Errorf("Unsupported signing algorithm %q!", config.Algorithm)
+   }
+   
+   set := &keySet{config: config}
+   if err := set.load(); err != nil {
+      return err
+   }
+   if _, err := set.signingKey(); err != nil {
+      return err
+   }
+   keys = set
+   return nil
+}
+
+func supportedAlgorithm(alg string) bool {
+   return alg == "RS256" || alg == "ES256" || alg == "EdDSA"
+}
+
+// RotateKeys replaces the signing key with a new one right away.
+func RotateKeys() error {
+   keys.mutex.Lock()
+   defer keys.mutex.Unlock()
+   return keys.rotate(time.Now())
+}
+
+// signingKey returns the key to sign with, rotating it first when it is due
+// or was made for another algorithm.
+func (s *keySet) signingKey() (*signingKey, error) {
+   now := time.Now()
+   s.mutex.RLock()
+   current := s.current()
+   due := current == nil || current.Alg != s.config.Algorithm || s.config.RotateEvery > 0 && now.Sub(current.Created) >= s.config.RotateEvery
+   s.mutex.RUnlock()
+   if !due {
+      return current, nil
+   }
+   
+   s.mutex.Lock()
+   defer s.mutex.Unlock()
+   // Someone else may have rotated while we waited for the lock.
+   if next := s.current(); next != current {
+      return next, nil
+   }
+   if err := s.rotate(now); err != nil {
+      return nil, err
+   }
+   return s.current(), nil
+}
+
+func (s *keySet) current() *signingKey {
+   if len(s.keys) == 0 {
+      return nil
+   }
+   return s.keys[len(s.keys) - 1]
+}
+
+// verificationKey returns the public key of kid, if it still verifies
+// tokens.
+func (s *keySet) verificationKey(kid string) (*signingKey, bool) {
+   s.mutex.RLock()
+   defer s.mutex.RUnlock()
+   
+   now := time.Now()
+   for _, key := range s.keys {
+      if key.Kid == kid && (key.Retired.IsZero() || now.Before(key.Retired.Add(s.config.Grace))) {
+         return key, true
+      }
+   }
+   return nil, false
+}
+
+// rotate retires the signing key in favour of a new one and drops the keys
+// whose grace period is over. The caller holds the lock.
+func (s *keySet) rotate(now time.Time) error {
+   private, err := generateKey(s.config.Algorithm)
+   if err != nil {
+      return err
+   }
+   kid, err := randomstrings.GenerateRandomString(12)
+   if err != nil {
+      return err
+   }
+   
+   block, err := x509.MarshalPKCS8PrivateKey(private)
+   if err != nil {
+      return err
+   }
+   keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: block})
+   if err := ioutil.WriteFile(filepath.Join(s.config.KeyDir, kid + ".pem"), keyPEM, 0600); err != nil {
+      return err
+   }
+   
+   kept := []*signingKey{}
+   var dropped []*signingKey
+   for _, key := range s.keys {
+      if key.Retired.IsZero() {
+         key.Retired = now
+      }
+      if now.Before(key.Retired.Add(s.config.Grace)) {
+         kept = append(kept, key)
+      } else {
+         dropped = append(dropped, key)
+      }
+   }
+   s.keys = append(kept, &signingKey{Kid: kid, Alg: s.config.Algorithm, Created: now, private: private})
+   
+   if err := s.save(); err != nil {
+      return err
+   }
+   for _, key := range dropped {
+      os.Remove(filepath.Join(s.config.KeyDir, key.Kid + ".pem"))
+   }
+   return nil
+}
+
+func generateKey(alg string) (crypto.Signer, error) {
+   switch alg {
+      case "RS256":
+         return rsa.GenerateKey(rand.Reader, 2048)
+      case "ES256":
+         return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+      case "EdDSA":
+         _, private, err := ed25519.GenerateKey(rand.Reader)
+         return private, err
+      default:
+         return nil, fmt.Errorf("Unsupported signing algorithm %q!", alg)
+   }
+}
+
+func (s *keySet) load() error {
+   if err := os.MkdirAll(s.config.KeyDir, 0700); err != nil {
+      return err
+   }
+   
+   b, err := ioutil.ReadFile(filepath.Join(s.config.KeyDir, "keys.json"))
+   if os.IsNotExist(err) {
+      return nil
+   }
+   if err != nil {
+      return err
+   }
+   if err := json.Unmarshal(b, &s.keys); err != nil {
+      return err
+   }
+   
+   for _, key := range s.keys {
+      keyPEM, err := ioutil.ReadFile(filepath.Join(s.config.KeyDir, key.Kid + ".pem"))
+      if err != nil {
+         return err
+      }
+      block, _ := pem.Decode(keyPEM)
+      if block == nil {
+         return fmt.Errorf("Key %s is not PEM encoded!", key.Kid)
+      }
+      private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+      if err != nil {
+         return err
+      }
+      signer, ok := private.(crypto.Signer)
+      if !ok {
+         return fmt.Errorf("Key %s can't sign!", key.Kid)
+      }
+      key.private = signer
+   }
+   return nil
+}
+
+func (s *keySet) save() error {
+   b, err := json.MarshalIndent(s.keys, "", "\t")
+   if err != nil {
+      return err
+   }
+   path := filepath.Join(s.config.KeyDir, "keys.json")
+   if err := ioutil.WriteFile(path + ".tmp", b, 0600); err != nil {
+      return err
+   }
+   return os.Rename(path + ".tmp", path)
+}
+
+// signToken signs claims with the current signing key, naming it in the
+// kid header.
+func signToken(claims jwt.Claims) (string, error) {
+   key, err := keys.signingKey()
+   if err != nil {
+      return "", err
+   }
+   token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+   token.Header["kid"] = key.Kid
+   return token.SignedString(key.private)
+}
+
+func verifyKeyFunc(token *jwt.Token) (interface{}, error) {
+   kid, _ := token.Header["kid"].(string)
+   key, ok := keys.verificationKey(kid)
+   if !ok {
+      return nil, errors.New("Unknown signing key!")
+   }
+   if token.Method.Alg() != key.Alg {
+      return nil, errors.New("Unexpected signing method!")
+   }
+   return key.private.Public(), nil
+}
+
+type jwk struct {
+   Kty string `json:"kty"`
+   Kid string `json:"kid"`
+   Alg string `json:"alg"`
+   Use string `json:"use"`
+   Crv string `json:"crv,omitempty"`
+   N   string `json:"n,omitempty"`
+   E   string `json:"e,omitempty"`
+   X   string `json:"x,omitempty"`
+   Y   string `json:"y,omitempty"`
+}
+
+// JWKS returns the public keys that verify our tokens as a JSON Web Key Set.
+func JWKS() ([]byte, error) {
+   keys.mutex.RLock()
+   defer keys.mutex.RUnlock()
+   
+   now := time.Now()
+   set := struct {
+      Keys []jwk `json:"keys"`
+   }{Keys: []jwk{}}
+   for _, key := range keys.keys {
+      if !key.Retired.IsZero() && !now.Before(key.Retired.Add(keys.config.Grace)) {
+         continue
+      }
+      set.Keys = append(set.Keys, toJWK(key))
+   }
+   return json.Marshal(set)
+}
+
+func toJWK(key *signingKey) jwk {
+   encode := base64.RawURLEncoding.EncodeToString
+   k := jwk{Kid: key.Kid, Alg: key.Alg, Use: "sig"}
+   switch public := key.private.Public().(type) {
+      case *rsa.PublicKey:
+         k.Kty = "RSA"
+         k.N = encode(public.N.Bytes())
+         k.E = encode(big.NewInt(int64(public.E)).Bytes())
+      case *ecdsa.PublicKey:
+         size := (public.Curve.Params().BitSize + 7) / 8
+         k.Kty = "EC"
+         k.Crv = public.Curve.Params().Name
+         k.X = encode(public.X.FillBytes(make([]byte, size)))
+         k.Y = encode(public.Y.FillBytes(make([]byte, size)))
+      case ed25519.PublicKey:
+         k.Kty = "OKP"
+         k.Crv = "Ed25519"
+         k.X = encode(public)
+   }
+   return k
+}
+
+// JWKSHandler serves JWKS, for /.well-known/jwks.json.
+func JWKSHandler(res http.ResponseWriter, req *http.Request) {
+   b, err := JWKS()
+   if err != nil {
+      log.Printf("Error encoding JWKS: %v", err)
+      http.Error(res, http.StatusText(500), 500)
+      return
+   }
+   res.Header().Set("Content-Type", "application/json")
+   res.Header().Set("Cache-Control", "public, max-age=300")
+   res.Write(b)
+}
@@ -0,0 +1,103 @@
+package myJWT
+
+import (
+   "encoding/json"
+   "testing"
+   "time"
+   jwt "github.com/golang-jwt/jwt/v4"
+   "secure-api-project/db"
+)
+
+func TestAlgorithms(t *testing.T) {
+   for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+      t.Run(alg, func(t *testing.T) {
+         if err := InitJWT(Config{Algorithm: alg, KeyDir: t.TempDir()}); err != nil {
+            t.Fatalf("InitJWT: %v", err)
+         }
+         
+         auth, _, _, err := CreateNewTokens("user-1", "user", db.Client{})
+         if err != nil {
+            t.Fatalf("CreateNewTokens: %v", err)
+         }
+         token, _ := jwt.Parse(auth, verifyKeyFunc)
+         if token == nil || !token.Valid || token.Method.Alg() != alg {
+            t.Fatalf("auth token was not signed with %s", alg)
+         }
+         if _, err := ParseAuthToken(auth); err != nil {
+            t.Fatalf("ParseAuthToken: %v", err)
+         }
+      })
+   }
+   
+   if err := InitJWT(Config{Algorithm: "HS256", KeyDir: t.TempDir()}); err == nil {
+      t.Fatal("InitJWT accepted HS256")
+   }
+}
+
+func TestRotatedKeysVerifyDuringGrace(t *testing.T) {
+   InitJWT(Config{KeyDir: t.TempDir()})
+   auth, _, _, _ := CreateNewTokens("user-1", "user", db.Client{})
+   
+   if err := RotateKeys(); err != nil {
+      t.Fatalf("RotateKeys: %v", err)
+   }
+   if _, err := ParseAuthToken(auth); err != nil {
+      t.Fatalf("token of the replaced key: %v", err)
+   }
+   
+   keys.config.Grace = time.Nanosecond
+   if _, err := ParseAuthToken(auth); err != ErrUnauthorized {
+      t.Fatalf("token of a key past its grace = %v, want ErrUnauthorized", err)
+   }
+}
+
+func TestScheduledRotation(t *testing.T) {
+   InitJWT(Config{KeyDir: t.TempDir(), RotateEvery: time.Hour})
+   first := keys.current().Kid
+   
+   keys.current().Created = time.Now().Add(-2 * time.Hour)
+   CreateNewTokens("user-1", "user", db.Client{})
+   if keys.current().Kid == first {
+      t.Fatal("signing key was not rotated on schedule")
+   }
+}
+
+func TestKeysSurviveRestart(t *testing.T) {
+   dir := t.TempDir()
+   InitJWT(Config{Algorithm: "ES256", KeyDir: dir})
+   auth, _, _, _ := CreateNewTokens("user-1", "user", db.Client{})
+   
+   if err := InitJWT(Config{Algorithm: "ES256", KeyDir: dir}); err != nil {
+      t.Fatalf("InitJWT: %v", err)
+   }
+   if _, err := ParseAuthToken(auth); err != nil {
+      t.Fatalf("token signed before the restart: %v", err)
+   }
+}
+
+func TestJWKS(t *testing.T) {
+   InitJWT(Config{KeyDir: t.TempDir()})
+   RotateKeys()
+   keys.config.Algorithm = "EdDSA"
+   RotateKeys()
+   
+   b, err := JWKS()
+   if err != nil {
+      t.Fatalf("JWKS: %v", err)
+   }
+   var set struct {
+      Keys []jwk `json:"keys"`
+   }
+   if err := json.Unmarshal(b, &set); err != nil {
+      t.Fatalf("Unmarshal: %v", err)
+   }
+   if len(set.Keys) != 3 {
+      t.Fatalf("JWKS has %d keys, want 3", len(set.Keys))
+   }
+   if k := set.Keys[0]; k.Kty != "RSA" || k.Alg != "RS256" || k.N == "" || k.E != "AQAB" {
+      t.Fatalf("RSA key = %+v", k)
+   }
+   if k := set.Keys[2]; k.Kty != "OKP" || k.Crv != "Ed25519" || k.Kid != keys.current().Kid {
+      t.Fatalf("Ed25519 key = %+v", k)
+   }
+}
@@ -1,124 +1,245 @@
 package myJWT
 
 import (
-   "crypto/rsa"
-   "io/ioutil"
    "time"
    "errors"
+   "hash/fnv"
    "log"
-   jwt "github.com/dgrijalva/jwt-go"
+   "sync"
+   jwt "github.com/golang-jwt/jwt/v4"
    "secure-api-project/db"
    "secure-api-project/db/models"
 )
 
-const (
-   privateKeyPath = "keys/app.rsa"
-   publicKeyPath = "keys/app.rsa.pub"
-)
+// ErrUnauthorized is returned when none of the presented tokens is good
+// enough to keep the session going.
+var ErrUnauthorized = errors.New("unauthorized")
 
-func InitJWT() error {
-   signBytes, err := ioutil.ReadFile(privateKeyPath)
+// CreateNewTokens starts a session for a user who just logged in or
+// registered, on client. The refresh token opens a new token family.
+func CreateNewTokens(uuid, role string, client db.Client) (authTokenString, refreshTokenString, csrfSecret string, err error) {
+   // Generating CSRF Secret
+   csrfSecret, err = models.GenerateCsrfSecret()
    if err != nil {
-      return err
+      return
    }
    
-   signKey, err = jwt.ParseRSAPrivateKeyFromPEM(signBytes)
+   // Generating refresh token
+   refreshTokenString, family, err := createRefreshTokenString(uuid, role, csrfSecret, db.RefreshToken{Client: client})
    if err != nil {
-      return err
+      return
    }
    
-   verifyBytes, err := ioutil.ReadFile(publicKeyPath)
+   // Generating auth token
+   authTokenString, err = createAuthTokenString(uuid, role, csrfSecret, family)
    if err != nil {
-      return err
+      return
+   }
+   return
+}
+
+// ExchangeRefreshToken spends a refresh token on its own, without an auth
+// token, and issues the next generation of tokens, rotating its JTI and
+// revoking its family if it was spent before. The session is noted as used
+// from client now.
+//
+// It replaces CheckAndRefreshTokens, which took the auth token along. Auth
+// tokens are checked by ParseAuthToken alone; once one has expired, bearer
+// clients call /token/refresh and browsers are sent to /auth/refresh, the
+// only path their refresh cookie is sent to.
+func ExchangeRefreshToken(refreshTokenString string, client db.Client) (newAuthTokenString, newRefreshTokenString, newCsrfSecret string, err error) {
+   return refreshTokens(refreshTokenString, client)
+}
+
+// refreshTokens spends a refresh token and issues the next generation of
+// tokens. An empty client keeps the one the session was last used from.
+func refreshTokens(oldRefreshTokenString string, client db.Client) (newAuthTokenString, newRefreshTokenString, newCsrfSecret string, err error) {
+   refreshToken, err := jwt.ParseWithClaims(oldRefreshTokenString, &models.TokenClaims{}, verifyKeyFunc)
+   if err != nil || !refreshToken.Valid {
+      log.Println("Refresh token is invalid or expired")
+      err = ErrUnauthorized
+      return
+   }
+   
+   refreshTokenClaims, ok := refreshToken.Claims.(*models.TokenClaims)
+   if !ok {
+      log.Println("Could not read refresh token claims")
+      err = ErrUnauthorized
+      return
    }
    
-   verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(verifyBytes)
+   subject := refreshTokenClaims.Subject
+   
+   // A parallel refresh of the session waits for this one, and then finds
+   // the successor it can be given too.
+   unlock := lockFamily(refreshTokenClaims)
+   defer unlock()
+   
+   session, err := db.UseRefreshToken(refreshTokenClaims.Id)
    if err != nil {
-      return err
+      if errors.Is(err, db.ErrRefreshTokenReused) {
+         log.Printf("Refresh token of %s was replayed! Revoked its token family.", subject)
+      }
+      err = ErrUnauthorized
+      return
    }
-   return nil
-}
-
-func createNewTokens(uuid, role string) (authTokenString, refreshTokenString, csrfSecret string, err error) {
-   // Generating CSRF Secret
-   csrfSecret, err := models.GenerateCsrfSecret()
+   
+   // The user may have been given another role, or deleted, since the
+   // session started.
+   user, err := db.FetchUserByID(subject)
    if err != nil {
+      log.Printf("Refresh token of %s doesn't belong to a user anymore", subject)
+      err = ErrUnauthorized
       return
    }
+   refreshTokenClaims.Role = user.Role
    
-   // Generating refresh token
-   refreshTokenString, err = createRefreshTokenString(uuid, role, csrfSecret)
+   newCsrfSecret, err = models.GenerateCsrfSecret()
    if err != nil {
-      return 
+      return
    }
    
-   // Generating auth token
-   authTokenString, err = createAuthTokenString(uuid, role, csrfSecret)
+   newAuthTokenString, err = updateAuthTokenString(refreshTokenClaims, session.Family, newCsrfSecret)
    if err != nil {
       return
    }
+   
+   if session.Successor != "" {
+      log.Printf("Refresh token of %s was presented again; handing out its successor", subject)
+      newRefreshTokenString, err = reissueRefreshToken(refreshTokenClaims, session.Successor, newCsrfSecret)
+      return
+   }
+   
+   if client != (db.Client{}) {
+      session.Client = client
+   }
+   session.Predecessor = refreshTokenClaims.Id
+   newRefreshTokenString, err = updateRefreshTokenExp(refreshTokenClaims, session, newCsrfSecret)
    return
 }
 
-func CheckAndRefreshTokens() () {}
+// refreshLocks serialise the refreshes of a token family. Families share
+// them by hash, which only ever makes a refresh wait a little longer.
+var refreshLocks [64]sync.Mutex
+
+func lockFamily(refreshTokenClaims *models.TokenClaims) func() {
+   family := refreshTokenClaims.Session
+   if family == "" {
+      family = refreshTokenClaims.Id
+   }
+   h := fnv.New32a()
+   h.Write([]byte(family))
+   lock := &refreshLocks[h.Sum32() % uint32(len(refreshLocks))]
+   lock.Lock()
+   return lock.Unlock
+}
 
-func createAuthTokenString(uuid, role, csrfSecret string) (authTokenString string, err error) {
+// createAuthTokenString issues an auth token in the session of the refresh
+// token family.
+func createAuthTokenString(uuid, role, csrfSecret, family string) (authTokenString string, err error) {
    authTokenExp := time.Now().Add(models.AuthTokenValidTime).Unix()
    authClaims := models.TokenClaims{
-      jwt.StandardClaims{
+      StandardClaims: jwt.StandardClaims{
          Subject: uuid,
          ExpiresAt: authTokenExp,
       },
       Role: role,
       Csrf: csrfSecret,
+      Session: family,
    }
-   authJwt := jwt.NewWithClaims(jwt.GetSigningMethod("RS265"), authClaims)
-   authTokenString, err := authJwt.SignedString(signKey)
-   if err != nil {
-      return err
-   }
-   return
+   return signToken(authClaims)
 }
 
-func createRefreshTokenString(uuid, role, csrfSecret string) (refreshTokenString string, err error) {
-   refreshTokenExp := time.Now().Add(models.RefreshTokenValidTime).Unix()
-   refreshJti, err := db.StoreRefreshToken()
+// createRefreshTokenString issues the next refresh token of session, or
+// the first one of a new session when it has no family yet. It returns the
+// family the token ended up in.
+func createRefreshTokenString(uuid, role, csrfSecret string, session db.RefreshToken) (refreshTokenString, family string, err error) {
+   refreshTokenExp := time.Now().Add(models.RefreshTokenValidTime)
+   session.UUID = uuid
+   session.Expires = refreshTokenExp
+   refreshJti, err := db.StoreRefreshToken(session)
    if err != nil {
       return
    }
+   family = session.Family
+   if family == "" {
+      family = refreshJti
+   }
+   refreshTokenString, err = signRefreshToken(uuid, role, csrfSecret, refreshJti, family, refreshTokenExp)
+   return
+}
+
+func signRefreshToken(uuid, role, csrfSecret, jti, family string, expires time.Time) (string, error) {
    refreshClaims := models.TokenClaims{
-      jwt.StandardClaims{
-         Id: refreshJti,
+      StandardClaims: jwt.StandardClaims{
+         Id: jti,
          Subject: uuid,
-         ExpiresAt: refreshTokenExp,
+         ExpiresAt: expires.Unix(),
       },
       Role: role,
       Csrf: csrfSecret,
+      Session: family,
    }
-   refreshJwt := jwt.NewWithClaims(jwt.GetSigningMethod("RS265"), refreshClaims)
-   refreshTokenString, err = refreshJwt.SignedString(signKey)
+   return signToken(refreshClaims)
+}
+
+// reissueRefreshToken signs the refresh token successor once more, for a
+// request that presented its predecessor within the reuse grace.
+func reissueRefreshToken(oldRefreshTokenClaims *models.TokenClaims, successor, csrfSecret string) (string, error) {
+   token, err := db.FetchRefreshToken(successor)
    if err != nil {
-      return
+      return "", ErrUnauthorized
    }
-   return
+   return signRefreshToken(oldRefreshTokenClaims.Subject, oldRefreshTokenClaims.Role, csrfSecret, successor, token.Family, token.Expires)
 }
 
-func updateRefreshTokenExp() () {
-   
+// updateRefreshTokenExp issues the successor of a spent refresh token: a new
+// JTI in the same family, with a fresh expiry and the new CSRF secret.
+func updateRefreshTokenExp(oldRefreshTokenClaims *models.TokenClaims, session db.RefreshToken, csrfSecret string) (string, error) {
+   refreshTokenString, _, err := createRefreshTokenString(oldRefreshTokenClaims.Subject, oldRefreshTokenClaims.Role, csrfSecret, session)
+   return refreshTokenString, err
 }
 
-func updateAuthTokenString() () {
-   
+// updateAuthTokenString issues a new auth token for the owner of a refresh
+// token.
+func updateAuthTokenString(refreshTokenClaims *models.TokenClaims, family, csrfSecret string) (string, error) {
+   return createAuthTokenString(refreshTokenClaims.Subject, refreshTokenClaims.Role, csrfSecret, family)
 }
 
-func RevokeRefreshToken() error {
-   
+// ParseAuthToken returns the claims of a valid auth token. Refresh tokens
+// are signed the same way but carry a JTI, and are turned down: they can
+// only be spent on new tokens.
+func ParseAuthToken(authTokenString string) (*models.TokenClaims, error) {
+   claims := &models.TokenClaims{}
+   token, err := jwt.ParseWithClaims(authTokenString, claims, verifyKeyFunc)
+   if err != nil || !token.Valid || claims.Id != "" {
+      return nil, ErrUnauthorized
+   }
+   return claims, nil
 }
 
-func updateRefreshTokenCsrf() () {
+// ParseRefreshToken checks that we signed a refresh token and returns its
+// claims. Expired tokens are accepted, so they can still end their session;
+// it says nothing about whether the token can be spent.
+func ParseRefreshToken(refreshTokenString string) (*models.TokenClaims, error) {
+   refreshToken, err := jwt.ParseWithClaims(refreshTokenString, &models.TokenClaims{}, verifyKeyFunc)
+   if ve, ok := err.(*jwt.ValidationError); refreshToken == nil || err != nil && (!ok || ve.Errors != jwt.ValidationErrorExpired) {
+      return nil, errors.New("Could not parse refresh token with claims")
+   }
    
+   refreshTokenClaims, ok := refreshToken.Claims.(*models.TokenClaims)
+   if !ok {
+      return nil, errors.New("Could not read refresh token claims")
+   }
+   return refreshTokenClaims, nil
 }
 
-func grabUUID() () {
-   
-}
\ No newline at end of file
+// RevokeRefreshToken ends the session a refresh token belongs to by revoking
+// its whole family. Expired tokens are accepted, as long as we signed them.
+func RevokeRefreshToken(refreshTokenString string) error {
+   refreshTokenClaims, err := ParseRefreshToken(refreshTokenString)
+   if err != nil {
+      return err
+   }
+   return db.RevokeRefreshTokenFamily(refreshTokenClaims.Id)
+}
@@ -0,0 +1,211 @@
+package myJWT
+
+import (
+   "sync"
+   "testing"
+   jwt "github.com/golang-jwt/jwt/v4"
+   "secure-api-project/db"
+   "secure-api-project/db/models"
+)
+
+func setupKeys(t *testing.T) {
+   store := db.NewMemoryStore()
+   store.CreateUser("user-1", models.User{Username: "ayush", Role: models.RoleUser})
+   db.InitDB(store)
+   if err := InitJWT(Config{KeyDir: t.TempDir()}); err != nil {
+      t.Fatalf("InitJWT: %v", err)
+   }
+}
+
+func refreshJti(t *testing.T, refreshTokenString string) string {
+   claims := &models.TokenClaims{}
+   if _, err := jwt.ParseWithClaims(refreshTokenString, claims, verifyKeyFunc); err != nil {
+      t.Fatalf("ParseWithClaims: %v", err)
+   }
+   return claims.Id
+}
+
+func TestExchangeRefreshToken(t *testing.T) {
+   setupKeys(t)
+   
+   _, refresh, csrf, err := CreateNewTokens("user-1", "user", db.Client{})
+   if err != nil {
+      t.Fatalf("CreateNewTokens: %v", err)
+   }
+   
+   newAuth, newRefresh, newCsrf, err := ExchangeRefreshToken(refresh, db.Client{})
+   if err != nil {
+      t.Fatalf("ExchangeRefreshToken: %v", err)
+   }
+   if newCsrf == csrf {
+      t.Fatal("CSRF secret was not rotated")
+   }
+   if refreshJti(t, newRefresh) == refreshJti(t, refresh) {
+      t.Fatal("refresh token JTI was not rotated")
+   }
+   
+   claims, err := ParseAuthToken(newAuth)
+   if err != nil {
+      t.Fatalf("new auth token: %v", err)
+   }
+   if claims.Subject != "user-1" || claims.Role != "user" || claims.Csrf != newCsrf {
+      t.Fatalf("new auth token claims = %+v", claims)
+   }
+}
+
+func TestRefreshTokenIsNoAuthToken(t *testing.T) {
+   setupKeys(t)
+   
+   _, refresh, _, _ := CreateNewTokens("user-1", "user", db.Client{})
+   if _, err := ParseAuthToken(refresh); err != ErrUnauthorized {
+      t.Fatalf("ParseAuthToken(refresh token) = %v, want ErrUnauthorized", err)
+   }
+}
+
+func TestReplayedRefreshTokenRevokesFamily(t *testing.T) {
+   setupKeys(t)
+   
+   _, refresh, _, err := CreateNewTokens("user-1", "user", db.Client{})
+   if err != nil {
+      t.Fatalf("CreateNewTokens: %v", err)
+   }
+   
+   _, rotated, _, err := ExchangeRefreshToken(refresh, db.Client{})
+   if err != nil {
+      t.Fatalf("ExchangeRefreshToken: %v", err)
+   }
+   _, rotated, _, err = ExchangeRefreshToken(rotated, db.Client{})
+   if err != nil {
+      t.Fatalf("ExchangeRefreshToken: %v", err)
+   }
+   
+   // Whoever copied the first refresh token replays it, after its
+   // successor was spent too.
+   if _, _, _, err := ExchangeRefreshToken(refresh, db.Client{}); err != ErrUnauthorized {
+      t.Fatalf("replay = %v, want ErrUnauthorized", err)
+   }
+   // The legitimate successor was revoked along with it.
+   if _, _, _, err := ExchangeRefreshToken(rotated, db.Client{}); err != ErrUnauthorized {
+      t.Fatalf("successor after replay = %v, want ErrUnauthorized", err)
+   }
+}
+
+func TestConcurrentExchangesShareASuccessor(t *testing.T) {
+   setupKeys(t)
+   
+   _, refresh, _, _ := CreateNewTokens("user-1", "user", db.Client{})
+   
+   // Two tabs find the auth token expired at once.
+   var wg sync.WaitGroup
+   results := make([]string, 2)
+   errs := make([]error, 2)
+   for i := range results {
+      wg.Add(1)
+      go func(i int) {
+         defer wg.Done()
+         _, results[i], _, errs[i] = ExchangeRefreshToken(refresh, db.Client{})
+      }(i)
+   }
+   wg.Wait()
+   
+   for i, err := range errs {
+      if err != nil {
+         t.Fatalf("exchange %d: %v", i, err)
+      }
+   }
+   if refreshJti(t, results[0]) != refreshJti(t, results[1]) {
+      t.Fatal("parallel exchanges got different successors")
+   }
+   if _, _, _, err := ExchangeRefreshToken(results[1], db.Client{}); err != nil {
+      t.Fatalf("successor after parallel exchanges: %v", err)
+   }
+}
+
+func TestReplayDoesNotRevokeOtherSessions(t *testing.T) {
+   setupKeys(t)
+   
+   _, first, _, _ := CreateNewTokens("user-1", "user", db.Client{})
+   _, second, _, _ := CreateNewTokens("user-1", "user", db.Client{})
+   
+   _, rotated, _, _ := ExchangeRefreshToken(first, db.Client{})
+   ExchangeRefreshToken(rotated, db.Client{})
+   if _, _, _, err := ExchangeRefreshToken(first, db.Client{}); err != ErrUnauthorized {
+      t.Fatalf("replay = %v, want ErrUnauthorized", err)
+   }
+   
+   if _, _, _, err := ExchangeRefreshToken(second, db.Client{}); err != nil {
+      t.Fatalf("other session: %v", err)
+   }
+}
+
+func TestForgedTokensAreRejected(t *testing.T) {
+   setupKeys(t)
+   auth, refresh, _, _ := CreateNewTokens("user-1", "user", db.Client{})
+   
+   // Tokens signed with another key must not pass.
+   setupKeys(t)
+   if _, err := ParseAuthToken(auth); err != ErrUnauthorized {
+      t.Fatalf("ParseAuthToken = %v, want ErrUnauthorized", err)
+   }
+   if _, _, _, err := ExchangeRefreshToken(refresh, db.Client{}); err != ErrUnauthorized {
+      t.Fatalf("ExchangeRefreshToken = %v, want ErrUnauthorized", err)
+   }
+}
+
+func TestRevokeRefreshToken(t *testing.T) {
+   setupKeys(t)
+   
+   _, refresh, _, _ := CreateNewTokens("user-1", "user", db.Client{})
+   if err := RevokeRefreshToken(refresh); err != nil {
+      t.Fatalf("RevokeRefreshToken: %v", err)
+   }
+   
+   if _, _, _, err := ExchangeRefreshToken(refresh, db.Client{}); err != ErrUnauthorized {
+      t.Fatalf("revoked refresh token = %v, want ErrUnauthorized", err)
+   }
+}
+
+func TestRefreshPicksUpRoleChanges(t *testing.T) {
+   setupKeys(t)
+   
+   _, refresh, _, _ := CreateNewTokens("user-1", models.RoleUser, db.Client{})
+   db.SetUserRole("ayush", models.RoleAdmin)
+   
+   newAuth, newRefresh, _, err := ExchangeRefreshToken(refresh, db.Client{})
+   if err != nil {
+      t.Fatalf("ExchangeRefreshToken: %v", err)
+   }
+   if claims, err := ParseAuthToken(newAuth); err != nil || claims.Role != models.RoleAdmin {
+      t.Fatalf("ParseAuthToken = %+v, %v; want the new role", claims, err)
+   }
+   
+   db.DeleteUser("user-1")
+   if _, _, _, err := ExchangeRefreshToken(newRefresh, db.Client{}); err != ErrUnauthorized {
+      t.Fatalf("refresh of a deleted user = %v, want ErrUnauthorized", err)
+   }
+}
+
+func TestRefreshKeepsClientUnlessGiven(t *testing.T) {
+   setupKeys(t)
+   
+   phone := db.Client{Device: "phone", IP: "192.0.2.1"}
+   _, refresh, _, _ := CreateNewTokens("user-1", models.RoleUser, phone)
+   
+   _, refresh, _, err := refreshTokens(refresh, db.Client{})
+   if err != nil {
+      t.Fatalf("refreshTokens: %v", err)
+   }
+   sessions, _ := db.ListSessions("user-1")
+   if session := sessions[refreshJti(t, refresh)]; session.Client != phone {
+      t.Fatalf("client = %+v, want %+v", session.Client, phone)
+   }
+   
+   laptop := db.Client{Device: "laptop", IP: "192.0.2.2"}
+   if _, refresh, _, err = refreshTokens(refresh, laptop); err != nil {
+      t.Fatalf("refreshTokens: %v", err)
+   }
+   sessions, _ = db.ListSessions("user-1")
+   if session := sessions[refreshJti(t, refresh)]; session.Client != laptop {
+      t.Fatalf("client = %+v, want %+v", session.Client, laptop)
+   }
+}
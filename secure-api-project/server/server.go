@@ -3,13 +3,16 @@ package server
 import (
    "log"
    "net/http"
-   "secure-api-project/middleware"
+   "secure-api-project/middlewares"
 )
 
-func StartServer(hostname, port string) error {
+func StartServer(hostname, port string, config middleware.Config) error {
    host := hostname + ":" + port
    log.Printf("Listening on %s", host)
-   handler := middleware.NewHandler()
+   handler, err := middleware.NewHandler(config)
+   if err != nil {
+      return err
+   }
    http.Handle("/", handler)
    return http.ListenAndServe(host, nil)
 }
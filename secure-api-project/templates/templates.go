@@ -1,6 +1,7 @@
 package templates
 
 import (
+   "embed"
    "log"
    "net/http"
    "html/template"
@@ -17,20 +18,31 @@ type RegisterPage struct {
 }
 
 type DashboardPage struct {
-   BAlertUser bool
-   AlertMsg   string
+   CsrfSecret    string
+   SecretMessage string
 }
 
-var template = template.Must(template.ParseFiles(
-   "./server/templates/templateFiles/login.tmpl",
-   "./server/templates/templateFiles/register.tmpl",
-   "./server/templates/templateFiles/restricted.tmpl"
+// CsrfFieldName is the form field a CSRF token is submitted in.
+const CsrfFieldName = "X-CSRF-Token"
+
+// csrfField puts a CSRF token in a form: {{csrfField .CsrfSecret}}.
+func csrfField(token string) template.HTML {
+   return template.HTML(`<input type="hidden" name="` + CsrfFieldName + `" value="` + template.HTMLEscapeString(token) + `" />`)
+}
+
+//go:embed templateFiles/*.tmpl
+var templateFiles embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{"csrfField": csrfField}).ParseFS(templateFiles,
+   "templateFiles/login.tmpl",
+   "templateFiles/register.tmpl",
+   "templateFiles/restricted.tmpl",
 ))
 
 func RenderTemplate(res http.ResponseWriter, tmpl string, p interface{}) {
-   err := template.ExecuteTemplate(res, tmpl + ".tmpl", p)
+   err := templates.ExecuteTemplate(res, tmpl + ".tmpl", p)
    if err != nil {
       log.Printf("You've got template err %v", err)
       http.Error(res, err.Error(), http.StatusInternalServerError)
    }
-}
\ No newline at end of file
+}